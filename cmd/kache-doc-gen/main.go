@@ -35,6 +35,7 @@ import (
 func main() {
 	// import all commands
 	kache.RootCmd.AddCommand(kache.VersionCmd)
+	kache.RootCmd.AddCommand(kache.SentinelCmd)
 	err := doc.GenMarkdownTree(kache.RootCmd, "./")
 
 	if err != nil {
@@ -0,0 +1,132 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package arch
+
+import (
+	"time"
+
+	"github.com/kasvith/kache/internal/cmds"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// Blocked is a client blocked by a blocking command until one of its keys receives data
+type Blocked struct {
+	Timeout time.Duration        // how long the client waits, 0 means forever
+	Result  chan *protcl.Message // receives the reply once the client is served
+
+	db      *db.DB
+	blocker *db.Blocker
+	nilMsg  *protcl.Message // replied when the timeout elapses
+}
+
+// ExecuteBlocking executes a blocking command on the given database. When nothing can be served
+// immediately the client is blocked and a nil message is returned along with the blocked client,
+// otherwise the message is the reply of the command
+func (c DBCommand) ExecuteBlocking(d *db.DB, cmd string, args []string) (*protcl.Message, *Blocked) {
+	command, err := c.Validate(cmd, args)
+	if err != nil {
+		return protcl.NewMessage(nil, err), nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	message := execute(d, cmd, command, args, true)
+	d.ServeBlocked()
+	if !command.Blocking || message.Err != nil || served(message) {
+		return message, nil
+	}
+
+	// the timeout was validated by the command
+	timeout, _ := cmds.ParseTimeout(args[len(args)-1])
+	b := &Blocked{Timeout: timeout, Result: make(chan *protcl.Message, 1), db: d, nilMsg: message}
+	b.blocker = d.Block(blockedOn(cmd, args), func(d *db.DB, key string) bool {
+		// an error is replied as well, such as a BLMOVE destination of another type, so that the
+		// clients blocked behind the client are still served
+		message := execute(d, cmd, command, servedArgs(cmd, args, key), true)
+		if message.Err == nil && !served(message) {
+			return false
+		}
+
+		b.Result <- message
+		return true
+	})
+
+	return nil, b
+}
+
+// Cancel unblocks the client when its timeout elapsed or it disconnected. The nil reply is returned,
+// unless the client was served meanwhile
+func (b *Blocked) Cancel() *protcl.Message {
+	b.db.Lock()
+	defer b.db.Unlock()
+
+	if b.db.Unblock(b.blocker) {
+		return b.nilMsg
+	}
+
+	return <-b.Result
+}
+
+// blockedOn returns the keys a blocking command waits for
+func blockedOn(name string, args []string) []string {
+	if name == "blmove" {
+		return args[:1]
+	}
+
+	return args[:len(args)-1]
+}
+
+// servedArgs restricts the keys of a blocking command to key, the one which received data
+func servedArgs(name string, args []string, key string) []string {
+	if name == "blmove" {
+		return args
+	}
+
+	return []string{key, args[len(args)-1]}
+}
+
+// served reports whether a blocking command served the client, nil is replied otherwise
+func served(message *protcl.Message) bool {
+	switch reply := message.Reply.(type) {
+	case *protcl.ArrayReply:
+		return !reply.Nil
+	case *protcl.BulkStringReply:
+		return !reply.Nil
+	}
+
+	return true
+}
+
+// unblocked returns the non blocking command equivalent to a blocking command which served the client
+func unblocked(name string, args []string, message *protcl.Message) (string, []string) {
+	if name == "blmove" {
+		return "lmove", args[:4]
+	}
+
+	key := message.Reply.(*protcl.ArrayReply).Elems[0].(*protcl.BulkStringReply).Value
+	return name[1:], []string{key}
+}
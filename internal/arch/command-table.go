@@ -25,6 +25,9 @@
 package arch
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/kasvith/kache/internal/cmds"
 	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/protcl"
@@ -35,23 +38,172 @@ type CommandFunc func(*db.DB, []string) *protcl.Message
 type Command struct {
 	ModifyKeySpace bool
 	Fn             CommandFunc
+	FirstKey       int // position of the first key in args starting from 1, 0 means no keys
+	LastKey        int // position of the last key in args, -1 means the last argument
+	KeyStep        int // distance between two keys, 0 is treated as 1
 	MinArgs        int // 0
 	MaxArgs        int // -1 ~ +inf, -1 mean infinite
+
+	// KeysFn returns the keys of commands whose keys can't be located by their positions
+	KeysFn func(args []string) []string
+
+	// Blocking commands block the client until one of their keys receives data
+	Blocking bool
+}
+
+// Keys returns the keys given to the command in args
+func (cmd *Command) Keys(args []string) []string {
+	if cmd.KeysFn != nil {
+		return cmd.KeysFn(args)
+	}
+
+	if cmd.FirstKey == 0 {
+		return nil
+	}
+
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+
+	step := cmd.KeyStep
+	if step == 0 {
+		step = 1
+	}
+
+	var keys []string
+	for i := cmd.FirstKey; i <= last && i <= len(args); i += step {
+		keys = append(keys, args[i-1])
+	}
+
+	return keys
 }
 
 var CommandTable = map[string]Command{
 	// server
-	"ping": {ModifyKeySpace: false, Fn: cmds.Ping, MinArgs: 0, MaxArgs: 1},
+	"ping":         {ModifyKeySpace: false, Fn: cmds.Ping, MinArgs: 0, MaxArgs: 1},
+	"save":         {ModifyKeySpace: false, Fn: cmds.Save, MinArgs: 0, MaxArgs: 0},
+	"bgsave":       {ModifyKeySpace: false, Fn: cmds.BgSave, MinArgs: 0, MaxArgs: 0},
+	"lastsave":     {ModifyKeySpace: false, Fn: cmds.LastSave, MinArgs: 0, MaxArgs: 0},
+	"bgrewriteaof": {ModifyKeySpace: false, Fn: cmds.BgRewriteAOF, MinArgs: 0, MaxArgs: 0},
+	"memory":       {ModifyKeySpace: false, Fn: cmds.Memory, MinArgs: 1, MaxArgs: -1},
+
+	// cluster
+	"cluster": {ModifyKeySpace: false, Fn: cmds.Cluster, MinArgs: 1, MaxArgs: -1},
+
+	// pub/sub, subscriptions are managed by the connection
+	"publish": {ModifyKeySpace: false, Fn: cmds.Publish, MinArgs: 2, MaxArgs: 2},
+	"pubsub":  {ModifyKeySpace: false, Fn: cmds.PubSub, MinArgs: 1, MaxArgs: -1},
 
 	// key space
-	"exists": {ModifyKeySpace: false, Fn: cmds.Exists, MinArgs: 1, MaxArgs: 1},
-	"del":    {ModifyKeySpace: true, Fn: cmds.Del, MinArgs: 1, MaxArgs: -1},
+	"exists":    {ModifyKeySpace: false, Fn: cmds.Exists, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"del":       {ModifyKeySpace: true, Fn: cmds.Del, FirstKey: 1, LastKey: -1, MinArgs: 1, MaxArgs: -1},
+	"expire":    {ModifyKeySpace: true, Fn: cmds.Expire, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"pexpire":   {ModifyKeySpace: true, Fn: cmds.PExpire, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"expireat":  {ModifyKeySpace: true, Fn: cmds.ExpireAt, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"pexpireat": {ModifyKeySpace: true, Fn: cmds.PExpireAt, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"ttl":       {ModifyKeySpace: false, Fn: cmds.TTL, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"pttl":      {ModifyKeySpace: false, Fn: cmds.PTTL, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"persist":   {ModifyKeySpace: true, Fn: cmds.Persist, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"type":      {ModifyKeySpace: false, Fn: cmds.Type, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"rename":    {ModifyKeySpace: true, Fn: cmds.Rename, FirstKey: 1, LastKey: 2, MinArgs: 2, MaxArgs: 2},
+	"renamenx":  {ModifyKeySpace: true, Fn: cmds.RenameNX, FirstKey: 1, LastKey: 2, MinArgs: 2, MaxArgs: 2},
+	"copy":      {ModifyKeySpace: true, Fn: cmds.Copy, FirstKey: 1, LastKey: 2, MinArgs: 2, MaxArgs: 3},
+	"touch":     {ModifyKeySpace: false, Fn: cmds.Touch, FirstKey: 1, LastKey: -1, MinArgs: 1, MaxArgs: -1},
+	"unlink":    {ModifyKeySpace: true, Fn: cmds.Unlink, FirstKey: 1, LastKey: -1, MinArgs: 1, MaxArgs: -1},
+	"dbsize":    {ModifyKeySpace: false, Fn: cmds.DBSize, MinArgs: 0, MaxArgs: 0},
+	"flushdb":   {ModifyKeySpace: true, Fn: cmds.FlushDB, MinArgs: 0, MaxArgs: 1},
+	"flushall":  {ModifyKeySpace: true, Fn: cmds.FlushAll, MinArgs: 0, MaxArgs: 1},
+	"swapdb":    {ModifyKeySpace: true, Fn: cmds.SwapDB, MinArgs: 2, MaxArgs: 2},
+	"move":      {ModifyKeySpace: true, Fn: cmds.Move, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"keys":      {ModifyKeySpace: false, Fn: cmds.Keys, MinArgs: 1, MaxArgs: 1},
+	"scan":      {ModifyKeySpace: false, Fn: cmds.Scan, MinArgs: 1, MaxArgs: 7},
+	"randomkey": {ModifyKeySpace: false, Fn: cmds.RandomKey, MinArgs: 0, MaxArgs: 0},
+
+	// serialization and migration, MIGRATE locates its keys by itself as they may come after KEYS
+	"dump":           {ModifyKeySpace: false, Fn: cmds.Dump, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"restore":        {ModifyKeySpace: true, Fn: cmds.Restore, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 5},
+	"restore-asking": {ModifyKeySpace: true, Fn: cmds.Restore, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 5},
+	"migrate":        {ModifyKeySpace: false, Fn: cmds.Migrate, MinArgs: 5, MaxArgs: -1},
 
 	// strings
-	"get":  {ModifyKeySpace: false, Fn: cmds.Get, MinArgs: 1, MaxArgs: 1},
-	"set":  {ModifyKeySpace: true, Fn: cmds.Set, MinArgs: 2, MaxArgs: 2},
-	"incr": {ModifyKeySpace: true, Fn: cmds.Incr, MinArgs: 1, MaxArgs: 1},
-	"decr": {ModifyKeySpace: true, Fn: cmds.Decr, MinArgs: 1, MaxArgs: 1},
+	"get":  {ModifyKeySpace: false, Fn: cmds.Get, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"set":  {ModifyKeySpace: true, Fn: cmds.Set, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"incr": {ModifyKeySpace: true, Fn: cmds.Incr, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"decr": {ModifyKeySpace: true, Fn: cmds.Decr, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+
+	// lists
+	"lpush":  {ModifyKeySpace: true, Fn: cmds.LPush, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"rpush":  {ModifyKeySpace: true, Fn: cmds.RPush, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"lpop":   {ModifyKeySpace: true, Fn: cmds.LPop, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"rpop":   {ModifyKeySpace: true, Fn: cmds.RPop, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"lrange": {ModifyKeySpace: false, Fn: cmds.LRange, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"ltrim":  {ModifyKeySpace: true, Fn: cmds.LTrim, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"llen":   {ModifyKeySpace: false, Fn: cmds.LLen, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"lmove":  {ModifyKeySpace: true, Fn: cmds.LMove, FirstKey: 1, LastKey: 2, MinArgs: 4, MaxArgs: 4},
+	"blpop":  {ModifyKeySpace: true, Fn: cmds.BLPop, FirstKey: 1, LastKey: -2, MinArgs: 2, MaxArgs: -1, Blocking: true},
+	"brpop":  {ModifyKeySpace: true, Fn: cmds.BRPop, FirstKey: 1, LastKey: -2, MinArgs: 2, MaxArgs: -1, Blocking: true},
+	"blmove": {ModifyKeySpace: true, Fn: cmds.BLMove, FirstKey: 1, LastKey: 2, MinArgs: 5, MaxArgs: 5, Blocking: true},
+
+	// hashes
+	"hset":         {ModifyKeySpace: true, Fn: cmds.HSet, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: -1},
+	"hsetnx":       {ModifyKeySpace: true, Fn: cmds.HSetNX, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"hmset":        {ModifyKeySpace: true, Fn: cmds.HMSet, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: -1},
+	"hget":         {ModifyKeySpace: false, Fn: cmds.HGet, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"hmget":        {ModifyKeySpace: false, Fn: cmds.HMGet, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"hdel":         {ModifyKeySpace: true, Fn: cmds.HDel, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"hexists":      {ModifyKeySpace: false, Fn: cmds.HExists, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"hkeys":        {ModifyKeySpace: false, Fn: cmds.HKeys, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"hvals":        {ModifyKeySpace: false, Fn: cmds.HVals, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"hgetall":      {ModifyKeySpace: false, Fn: cmds.HGetAll, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"hincrby":      {ModifyKeySpace: true, Fn: cmds.HIncrBy, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"hincrbyfloat": {ModifyKeySpace: true, Fn: cmds.HIncrByFloat, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"hlen":         {ModifyKeySpace: false, Fn: cmds.HLen, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"hstrlen":      {ModifyKeySpace: false, Fn: cmds.HStrLen, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"hscan":        {ModifyKeySpace: false, Fn: cmds.HScan, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 6},
+
+	// sets
+	"sadd":        {ModifyKeySpace: true, Fn: cmds.SAdd, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"srem":        {ModifyKeySpace: true, Fn: cmds.SRem, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"scard":       {ModifyKeySpace: false, Fn: cmds.SCard, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"smembers":    {ModifyKeySpace: false, Fn: cmds.SMembers, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"sismember":   {ModifyKeySpace: false, Fn: cmds.SIsMember, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"sscan":       {ModifyKeySpace: false, Fn: cmds.SScan, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 6},
+	"smove":       {ModifyKeySpace: true, Fn: cmds.SMove, FirstKey: 1, LastKey: 2, MinArgs: 3, MaxArgs: 3},
+	"sdiff":       {ModifyKeySpace: false, Fn: cmds.SDiff, FirstKey: 1, LastKey: -1, MinArgs: 1, MaxArgs: -1},
+	"sinter":      {ModifyKeySpace: false, Fn: cmds.SInter, FirstKey: 1, LastKey: -1, MinArgs: 1, MaxArgs: -1},
+	"sunion":      {ModifyKeySpace: false, Fn: cmds.SUnion, FirstKey: 1, LastKey: -1, MinArgs: 1, MaxArgs: -1},
+	"sdiffstore":  {ModifyKeySpace: true, Fn: cmds.SDiffStore, FirstKey: 1, LastKey: -1, MinArgs: 2, MaxArgs: -1},
+	"sinterstore": {ModifyKeySpace: true, Fn: cmds.SInterStore, FirstKey: 1, LastKey: -1, MinArgs: 2, MaxArgs: -1},
+	"sunionstore": {ModifyKeySpace: true, Fn: cmds.SUnionStore, FirstKey: 1, LastKey: -1, MinArgs: 2, MaxArgs: -1},
+
+	// sorted sets
+	"zadd":             {ModifyKeySpace: true, Fn: cmds.ZAdd, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: -1},
+	"zincrby":          {ModifyKeySpace: true, Fn: cmds.ZIncrBy, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"zrem":             {ModifyKeySpace: true, Fn: cmds.ZRem, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"zcard":            {ModifyKeySpace: false, Fn: cmds.ZCard, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 1},
+	"zscore":           {ModifyKeySpace: false, Fn: cmds.ZScore, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"zmscore":          {ModifyKeySpace: false, Fn: cmds.ZMScore, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: -1},
+	"zrank":            {ModifyKeySpace: false, Fn: cmds.ZRank, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"zrevrank":         {ModifyKeySpace: false, Fn: cmds.ZRevRank, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 2},
+	"zcount":           {ModifyKeySpace: false, Fn: cmds.ZCount, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"zlexcount":        {ModifyKeySpace: false, Fn: cmds.ZLexCount, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"zrange":           {ModifyKeySpace: false, Fn: cmds.ZRange, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 8},
+	"zrevrange":        {ModifyKeySpace: false, Fn: cmds.ZRevRange, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 4},
+	"zrangebyscore":    {ModifyKeySpace: false, Fn: cmds.ZRangeByScore, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 7},
+	"zrevrangebyscore": {ModifyKeySpace: false, Fn: cmds.ZRevRangeByScore, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 7},
+	"zrangebylex":      {ModifyKeySpace: false, Fn: cmds.ZRangeByLex, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 6},
+	"zrevrangebylex":   {ModifyKeySpace: false, Fn: cmds.ZRevRangeByLex, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 6},
+	"zremrangebyrank":  {ModifyKeySpace: true, Fn: cmds.ZRemRangeByRank, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"zremrangebyscore": {ModifyKeySpace: true, Fn: cmds.ZRemRangeByScore, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"zremrangebylex":   {ModifyKeySpace: true, Fn: cmds.ZRemRangeByLex, FirstKey: 1, LastKey: 1, MinArgs: 3, MaxArgs: 3},
+	"zpopmin":          {ModifyKeySpace: true, Fn: cmds.ZPopMin, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 2},
+	"zpopmax":          {ModifyKeySpace: true, Fn: cmds.ZPopMax, FirstKey: 1, LastKey: 1, MinArgs: 1, MaxArgs: 2},
+	"bzpopmin":         {ModifyKeySpace: true, Fn: cmds.BZPopMin, FirstKey: 1, LastKey: -2, MinArgs: 2, MaxArgs: -1, Blocking: true},
+	"bzpopmax":         {ModifyKeySpace: true, Fn: cmds.BZPopMax, FirstKey: 1, LastKey: -2, MinArgs: 2, MaxArgs: -1, Blocking: true},
+	"zunionstore":      {ModifyKeySpace: true, Fn: cmds.ZUnionStore, KeysFn: cmds.ZStoreKeys, MinArgs: 3, MaxArgs: -1},
+	"zinterstore":      {ModifyKeySpace: true, Fn: cmds.ZInterStore, KeysFn: cmds.ZStoreKeys, MinArgs: 3, MaxArgs: -1},
+	"zscan":            {ModifyKeySpace: false, Fn: cmds.ZScan, FirstKey: 1, LastKey: 1, MinArgs: 2, MaxArgs: 6},
 }
 
 type DBCommand struct {
@@ -65,16 +217,195 @@ func getCommand(cmd string) (*Command, error) {
 	return nil, &protcl.ErrUnknownCommand{Cmd: cmd}
 }
 
-// Execute executes a single command on the given database
-func (DBCommand) Execute(db *db.DB, cmd string, args []string) *protcl.Message {
+// Validate checks whether cmd is a known command and args satisfy its number of arguments
+func (DBCommand) Validate(cmd string, args []string) (*Command, error) {
 	command, err := getCommand(cmd)
 	if err != nil {
-		return protcl.NewMessage(nil, err)
+		return nil, err
 	}
 
 	if argsLen := len(args); (command.MinArgs > 0 && argsLen < command.MinArgs) || (command.MaxArgs != -1 && argsLen > command.MaxArgs) {
-		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd})
+		return nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd}
+	}
+
+	return command, nil
+}
+
+// Execute executes a single command on the given database
+func (c DBCommand) Execute(db *db.DB, cmd string, args []string) *protcl.Message {
+	command, err := c.Validate(cmd, args)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	message := execute(db, cmd, command, args, true)
+	db.ServeBlocked()
+
+	return message
+}
+
+// Apply executes a command streamed by a primary or replayed from the append only file. The memory
+// limit is not enforced, keys evicted by the primary are deleted by the stream itself
+func (c DBCommand) Apply(db *db.DB, cmd string, args []string) *protcl.Message {
+	command, err := c.Validate(cmd, args)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	message := execute(db, cmd, command, args, false)
+	db.ServeBlocked()
+
+	return message
+}
+
+// LocalCommand executes a queued command which is not in the CommandTable as it depends on the
+// connection, such as SELECT. It is called while the lock is held and returns the database the
+// following commands of the transaction are executed on
+type LocalCommand func(d *db.DB, cmd protcl.RespCommand) (*db.DB, *protcl.Message)
+
+// ExecuteMulti executes the queued commands of a transaction atomically on the given database.
+// Nothing is executed and a nil array is replied if any of the watched keys, which are grouped by
+// their database, has changed its version. Commands which are not in the CommandTable are executed
+// with local when it is not nil
+func (c DBCommand) ExecuteMulti(db *db.DB, commands []protcl.RespCommand, watched map[*db.DB]map[string]uint64, local LocalCommand) *protcl.Message {
+	db.Lock()
+	defer db.Unlock()
+
+	for d, keys := range watched {
+		for key, version := range keys {
+			if d.Version(key) != version {
+				return protcl.NewMessage(protcl.NewArrayReply(true, nil), nil)
+			}
+		}
+	}
+
+	replies := make([]protcl.Reply, len(commands))
+	for i, cmd := range commands {
+		var message *protcl.Message
+		if _, ok := CommandTable[cmd.Name]; !ok && local != nil {
+			db, message = local(db, cmd)
+		} else if command, err := c.Validate(cmd.Name, cmd.Args); err != nil {
+			message = protcl.NewMessage(nil, err)
+		} else {
+			message = execute(db, cmd.Name, command, cmd.Args, true)
+		}
+
+		if message.Err != nil {
+			replies[i] = protcl.NewErrorReply(message.Err)
+			continue
+		}
+
+		replies[i] = message.Reply
+	}
+	db.ServeBlocked()
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+}
+
+// releasesMemory are the commands which can only release memory, they are executed even if the
+// memory limit can't be satisfied
+var releasesMemory = map[string]bool{
+	"del": true, "persist": true, "expire": true, "pexpire": true, "expireat": true, "pexpireat": true,
+	"lpop": true, "rpop": true, "ltrim": true, "hdel": true, "srem": true, "unlink": true, "flushdb": true,
+	"flushall": true, "zrem": true, "zpopmin": true, "zpopmax": true, "zremrangebyrank": true, "zremrangebyscore": true,
+	"zremrangebylex": true, "blpop": true, "brpop": true, "bzpopmin": true, "bzpopmax": true,
+}
+
+// execute runs the command while the lock of db is held, signals modified keys and propagates the command.
+// When enforceMemory is set, keys are evicted before a command modifies the key space if the memory
+// limit is reached
+func execute(db *db.DB, name string, command *Command, args []string, enforceMemory bool) *protcl.Message {
+	if enforceMemory && command.ModifyKeySpace && !db.FreeMemory() && !releasesMemory[name] {
+		return protcl.NewMessage(nil, &protcl.ErrOOM{})
+	}
+
+	if !command.ModifyKeySpace {
+		db.CountLookups(command.Keys(args))
+	}
+
+	message := command.Fn(db, args)
+
+	// a blocking command which served the client is propagated as its non blocking version
+	if command.Blocking && message.Err == nil {
+		if !served(message) {
+			return message
+		}
+		name, args = unblocked(name, args, message)
+		command, _ = getCommand(name)
+	}
+
+	if command.ModifyKeySpace && message.Err == nil {
+		db.Touch(command.Keys(args))
+		propagate(db, name, args)
+	}
+
+	return message
+}
+
+// propagate sends the command to the feeds of db. Expirations relative to the current time are
+// propagated as absolute ones, so that replaying the command later leads to the same state
+func propagate(d *db.DB, name string, args []string) {
+	switch name {
+	case "expire", "pexpire", "expireat":
+		propagateExpiration(d, args[0])
+	case "set":
+		d.Propagate(name, absoluteSetArgs(d, args))
+	case "restore", "restore-asking":
+		d.Propagate("restore", absoluteRestoreArgs(d, args))
+	default:
+		d.Propagate(name, args)
+	}
+}
+
+// absoluteSetArgs replaces the expiration options of SET with the absolute expiration of the key
+func absoluteSetArgs(d *db.DB, args []string) []string {
+	node, err := d.Get(args[0])
+	if err != nil || node.ExpiresAt == -1 {
+		return args
+	}
+
+	propagated := []string{args[0], args[1]}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "ex", "px", "exat", "pxat":
+			i++
+		case "keepttl":
+		default:
+			propagated = append(propagated, args[i])
+		}
+	}
+
+	return append(propagated, "pxat", strconv.FormatInt(node.ExpiresAt, 10))
+}
+
+// absoluteRestoreArgs replaces the time to live of RESTORE with the absolute expiration of the key
+func absoluteRestoreArgs(d *db.DB, args []string) []string {
+	expiresAt := int64(0)
+	if node, err := d.Get(args[0]); err == nil && node.ExpiresAt != -1 {
+		expiresAt = node.ExpiresAt
+	}
+
+	return []string{args[0], strconv.FormatInt(expiresAt, 10), args[2], "replace", "absttl"}
+}
+
+// propagateExpiration propagates the expiration of key, a key which does not exist anymore is deleted
+func propagateExpiration(d *db.DB, key string) {
+	node, err := d.Get(key)
+	if err != nil {
+		d.Propagate("del", []string{key})
+		return
+	}
+
+	if node.ExpiresAt == -1 {
+		d.Propagate("persist", []string{key})
+		return
 	}
 
-	return command.Fn(db, args)
+	d.Propagate("pexpireat", []string{key, strconv.FormatInt(node.ExpiresAt, 10)})
 }
@@ -5,8 +5,10 @@ import (
 
 	testifyAssert "github.com/stretchr/testify/assert"
 
+	"github.com/kasvith/kache/internal/cmds"
 	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/types/hashmap"
 )
 
 func TestCommandArgsCountValidator(t *testing.T) {
@@ -28,12 +30,12 @@ func TestCommandArgsCountValidator(t *testing.T) {
 		assert.Nil(cmd.Execute(db, "del", []string{"1", "2"}).Err)
 	}
 
-	// set equal 2
+	// set at least 2, rest are options
 	{
 		assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "set"}, cmd.Execute(db, "set", nil).Err)
 		assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "set"}, cmd.Execute(db, "set", []string{"1"}).Err)
 		assert.Nil(cmd.Execute(db, "set", []string{"1", "2"}).Err)
-		assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "set"}, cmd.Execute(db, "set", []string{"1", "2", "3"}).Err)
+		assert.Equal(&protcl.ErrSyntax{}, cmd.Execute(db, "set", []string{"1", "2", "3"}).Err)
 	}
 
 	// equal 1: get exists incr decr
@@ -43,3 +45,421 @@ func TestCommandArgsCountValidator(t *testing.T) {
 		assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: command}, cmd.Execute(db, command, []string{"1", "2"}).Err)
 	}
 }
+
+func TestListCommands(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	db := db.NewDB()
+
+	assert.Equal(protcl.NewIntegerReply(3), cmd.Execute(db, "rpush", []string{"l", "a", "b", "c"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(5), cmd.Execute(db, "lpush", []string{"l", "y", "z"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(5), cmd.Execute(db, "llen", []string{"l"}).Reply)
+	assert.Equal("*5\r\n$1\r\nz\r\n$1\r\ny\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", cmd.Execute(db, "lrange", []string{"l", "-100", "100"}).RespReply())
+
+	assert.Equal(protcl.NewBulkStringReply(false, "z"), cmd.Execute(db, "lpop", []string{"l"}).Reply)
+	assert.Equal(protcl.NewBulkStringReply(false, "c"), cmd.Execute(db, "rpop", []string{"l"}).Reply)
+
+	assert.Nil(cmd.Execute(db, "ltrim", []string{"l", "1", "-1"}).Err)
+	assert.Equal("*2\r\n$1\r\na\r\n$1\r\nb\r\n", cmd.Execute(db, "lrange", []string{"l", "0", "-1"}).RespReply())
+
+	// an empty range removes the key
+	assert.Nil(cmd.Execute(db, "ltrim", []string{"l", "5", "1"}).Err)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"l"}).Reply)
+	assert.Equal(protcl.NewBulkStringReply(true, ""), cmd.Execute(db, "lpop", []string{"l"}).Reply)
+
+	// popping the last element removes the key
+	cmd.Execute(db, "rpush", []string{"l", "a"})
+	cmd.Execute(db, "rpop", []string{"l"})
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"l"}).Reply)
+
+	// wrong type
+	cmd.Execute(db, "set", []string{"s", "v"})
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "lpush", []string{"s", "v"}).Err)
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "llen", []string{"s"}).Err)
+	assert.Equal(&protcl.ErrCastFailedToInt{Val: "x"}, cmd.Execute(db, "lrange", []string{"l", "x", "1"}).Err)
+}
+
+func TestHashCommands(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	db := db.NewDB()
+
+	assert.Equal(protcl.NewIntegerReply(2), cmd.Execute(db, "hset", []string{"h", "a", "1", "b", "2"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "hset", []string{"h", "a", "3"}).Reply)
+	assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "hset"}, cmd.Execute(db, "hset", []string{"h", "a", "3", "b"}).Err)
+	assert.Equal(protcl.NewBulkStringReply(false, "3"), cmd.Execute(db, "hget", []string{"h", "a"}).Reply)
+	assert.Equal(protcl.NewBulkStringReply(true, ""), cmd.Execute(db, "hget", []string{"h", "c"}).Reply)
+	assert.Equal("*3\r\n$1\r\n3\r\n$-1\r\n$1\r\n2\r\n", cmd.Execute(db, "hmget", []string{"h", "a", "c", "b"}).RespReply())
+
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "hsetnx", []string{"h", "a", "4"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(13), cmd.Execute(db, "hincrby", []string{"h", "a", "10"}).Reply)
+	assert.Equal(protcl.NewBulkStringReply(false, "1.5"), cmd.Execute(db, "hincrbyfloat", []string{"h", "f", "1.5"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(3), cmd.Execute(db, "hlen", []string{"h"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(2), cmd.Execute(db, "hstrlen", []string{"h", "a"}).Reply)
+
+	// removing the last field removes the key
+	assert.Equal(protcl.NewIntegerReply(3), cmd.Execute(db, "hdel", []string{"h", "a", "b", "f", "x"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"h"}).Reply)
+	assert.Equal("*0\r\n", cmd.Execute(db, "hgetall", []string{"h"}).RespReply())
+
+	// a NaN or Infinity increment does not create the hash
+	assert.Equal(&protcl.ErrGeneric{Err: hashmap.ErrNaNOrInf}, cmd.Execute(db, "hincrbyfloat", []string{"h", "f", "inf"}).Err)
+	assert.NotNil(cmd.Execute(db, "hincrbyfloat", []string{"h", "f", "nan"}).Err)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"h"}).Reply)
+
+	cmd.Execute(db, "set", []string{"s", "v"})
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "hset", []string{"s", "a", "1"}).Err)
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "hkeys", []string{"s"}).Err)
+}
+
+func TestSetCommands(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	db := db.NewDB()
+
+	assert.Equal(protcl.NewIntegerReply(3), cmd.Execute(db, "sadd", []string{"a", "1", "2", "3"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(2), cmd.Execute(db, "sadd", []string{"b", "3", "4", "3"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(3), cmd.Execute(db, "scard", []string{"a"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(db, "sismember", []string{"b", "4"}).Reply)
+
+	assert.Equal("*1\r\n$1\r\n3\r\n", cmd.Execute(db, "sinter", []string{"a", "b"}).RespReply())
+	assert.Equal("*0\r\n", cmd.Execute(db, "sinter", []string{"a", "nonexistent"}).RespReply())
+	assert.Equal(protcl.NewIntegerReply(2), cmd.Execute(db, "sdiffstore", []string{"d", "a", "b"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(4), cmd.Execute(db, "sunionstore", []string{"u", "a", "b"}).Reply)
+
+	// storing an empty result removes the destination
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "sinterstore", []string{"u", "a", "nonexistent"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"u"}).Reply)
+
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(db, "smove", []string{"d", "m", "1"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "smove", []string{"d", "m", "1"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(db, "srem", []string{"d", "2"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"d"}).Reply)
+	assert.Equal("*1\r\n$1\r\n1\r\n", cmd.Execute(db, "smembers", []string{"m"}).RespReply())
+
+	cmd.Execute(db, "set", []string{"s", "v"})
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "sadd", []string{"s", "1"}).Err)
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "sunion", []string{"a", "s"}).Err)
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "smove", []string{"a", "s", "1"}).Err)
+}
+
+func TestSortedSetCommands(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	db := db.NewDB()
+	exec := func(name string, args ...string) *protcl.Message {
+		return cmd.Execute(db, name, args)
+	}
+
+	assert.Equal(protcl.NewIntegerReply(3), exec("zadd", "z", "1", "a", "2", "b", "3", "c").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), exec("zadd", "z", "nx", "5", "a").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), exec("zadd", "z", "xx", "1", "d").Reply)
+	assert.Equal(protcl.NewIntegerReply(1), exec("zadd", "z", "gt", "ch", "0", "a", "4", "b").Reply)
+	assert.Equal("$1\r\n5\r\n", exec("zadd", "z", "incr", "2", "c").RespReply())
+	assert.Equal("$-1\r\n", exec("zadd", "z", "lt", "incr", "1", "c").RespReply())
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrZAddFlags}, exec("zadd", "z", "nx", "gt", "1", "a").Err)
+	assert.Equal(&protcl.ErrSyntax{}, exec("zadd", "z", "1", "a", "2").Err)
+	assert.Equal(&protcl.ErrCastFailedToFloat{Val: "x"}, exec("zadd", "z", "x", "a").Err)
+	assert.Equal(protcl.NewIntegerReply(0), exec("exists", "missing").Reply)
+
+	// a: 1, b: 4, c: 5
+	assert.Equal("$3\r\n2.5\r\n", exec("zincrby", "z", "1.5", "a").RespReply())
+	assert.Equal("*2\r\n$3\r\n2.5\r\n$-1\r\n", exec("zmscore", "z", "a", "d").RespReply())
+	assert.Equal(protcl.NewIntegerReply(3), exec("zcard", "z").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), exec("zrank", "z", "a").Reply)
+	assert.Equal(protcl.NewIntegerReply(2), exec("zrevrank", "z", "a").Reply)
+	assert.Equal(protcl.NewBulkStringReply(true, ""), exec("zrank", "z", "d").Reply)
+
+	assert.Equal("*2\r\n$1\r\na\r\n$1\r\nb\r\n", exec("zrange", "z", "0", "1").RespReply())
+	assert.Equal("*4\r\n$1\r\nc\r\n$1\r\n5\r\n$1\r\nb\r\n$1\r\n4\r\n", exec("zrevrange", "z", "0", "1", "withscores").RespReply())
+	assert.Equal("*2\r\n$1\r\nc\r\n$1\r\nb\r\n", exec("zrange", "z", "+inf", "(2.5", "byscore", "rev").RespReply())
+	assert.Equal("*1\r\n$1\r\nb\r\n", exec("zrangebyscore", "z", "-inf", "+inf", "limit", "1", "1").RespReply())
+	assert.Equal("*1\r\n$1\r\nb\r\n", exec("zrevrangebyscore", "z", "5", "-inf", "limit", "1", "1").RespReply())
+	assert.Equal(&protcl.ErrSyntax{}, exec("zrange", "z", "0", "1", "limit", "0", "1").Err)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrInvalidMinMax}, exec("zcount", "z", "a", "1").Err)
+	assert.Equal(protcl.NewIntegerReply(2), exec("zcount", "z", "(2.5", "5").Reply)
+
+	// lex ranges
+	exec("zadd", "lex", "0", "a", "0", "b", "0", "c", "0", "d")
+	assert.Equal("*2\r\n$1\r\nb\r\n$1\r\nc\r\n", exec("zrange", "lex", "(a", "[c", "bylex").RespReply())
+	assert.Equal("*2\r\n$1\r\nd\r\n$1\r\nc\r\n", exec("zrevrangebylex", "lex", "+", "[c").RespReply())
+	assert.Equal("*1\r\n$1\r\nb\r\n", exec("zrangebylex", "lex", "-", "+", "limit", "1", "1").RespReply())
+	assert.Equal(protcl.NewIntegerReply(0), exec("zlexcount", "lex", "+", "-").Reply)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrInvalidLexRange}, exec("zlexcount", "lex", "a", "+").Err)
+	assert.Equal(protcl.NewIntegerReply(2), exec("zremrangebylex", "lex", "[c", "+").Reply)
+	assert.Equal(protcl.NewIntegerReply(1), exec("zremrangebyrank", "lex", "0", "0").Reply)
+	assert.Equal(protcl.NewIntegerReply(1), exec("zremrangebyscore", "lex", "-inf", "0").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), exec("exists", "lex").Reply)
+
+	// unions and intersections, sets count as sorted sets with scores of 1
+	exec("zadd", "y", "10", "b", "20", "d")
+	exec("sadd", "s", "a", "d")
+	assert.Equal(protcl.NewIntegerReply(4), exec("zunionstore", "u", "3", "z", "y", "s", "weights", "1", "2", "1").Reply)
+	assert.Equal("*8\r\n$1\r\na\r\n$3\r\n3.5\r\n$1\r\nc\r\n$1\r\n5\r\n$1\r\nb\r\n$2\r\n24\r\n$1\r\nd\r\n$2\r\n41\r\n",
+		exec("zrange", "u", "0", "-1", "withscores").RespReply())
+	assert.Equal(protcl.NewIntegerReply(1), exec("zinterstore", "i", "2", "z", "y", "aggregate", "max").Reply)
+	assert.Equal("$2\r\n10\r\n", exec("zscore", "i", "b").RespReply())
+	assert.Equal(protcl.NewIntegerReply(0), exec("zinterstore", "i", "2", "z", "missing").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), exec("exists", "i").Reply)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrNoInputKeys}, exec("zunionstore", "u", "0", "z").Err)
+	assert.Equal(&protcl.ErrSyntax{}, exec("zunionstore", "u", "3", "z", "y").Err)
+
+	// pops
+	assert.Equal("*2\r\n$1\r\na\r\n$3\r\n2.5\r\n", exec("zpopmin", "z").RespReply())
+	assert.Equal("*4\r\n$1\r\nc\r\n$1\r\n5\r\n$1\r\nb\r\n$1\r\n4\r\n", exec("zpopmax", "z", "5").RespReply())
+	assert.Equal(protcl.NewIntegerReply(0), exec("exists", "z").Reply)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrNotPositive}, exec("zpopmin", "y", "-1").Err)
+	assert.Equal(protcl.NewIntegerReply(1), exec("zrem", "y", "b", "x").Reply)
+	assert.Equal(protcl.NewSimpleStringReply("zset"), exec("type", "y").Reply)
+	assert.Equal(&protcl.ErrWrongType{}, exec("zadd", "s", "1", "a").Err)
+}
+
+func TestExpireCommands(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	db := db.NewDB()
+
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "expire", []string{"k", "10"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(-2), cmd.Execute(db, "ttl", []string{"k"}).Reply)
+
+	cmd.Execute(db, "set", []string{"k", "1"})
+	assert.Equal(protcl.NewIntegerReply(-1), cmd.Execute(db, "ttl", []string{"k"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(db, "expire", []string{"k", "10"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(10), cmd.Execute(db, "ttl", []string{"k"}).Reply)
+
+	// incr keeps the expiration
+	cmd.Execute(db, "incr", []string{"k"})
+	assert.Equal(protcl.NewIntegerReply(10), cmd.Execute(db, "ttl", []string{"k"}).Reply)
+
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(db, "persist", []string{"k"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(-1), cmd.Execute(db, "pttl", []string{"k"}).Reply)
+
+	// an expiration that overflows is rejected and keeps the key
+	assert.Equal(&protcl.ErrInvalidExpireTime{Cmd: "expire"}, cmd.Execute(db, "expire", []string{"k", "9223372036854775807"}).Err)
+	assert.Equal(&protcl.ErrInvalidExpireTime{Cmd: "pexpire"}, cmd.Execute(db, "pexpire", []string{"k", "9223372036854775807"}).Err)
+	assert.Equal(&protcl.ErrInvalidExpireTime{Cmd: "expireat"}, cmd.Execute(db, "expireat", []string{"k", "-9223372036854775808"}).Err)
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(db, "exists", []string{"k"}).Reply)
+
+	// a time in the past removes the key
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(db, "expireat", []string{"k", "1"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"k"}).Reply)
+}
+
+func TestSetOptions(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	db := db.NewDB()
+
+	nilReply := protcl.NewBulkStringReply(true, "")
+	okReply := protcl.NewSimpleStringReply("OK")
+
+	// lock acquisition
+	assert.Equal(okReply, cmd.Execute(db, "set", []string{"lock", "a", "NX", "PX", "30000"}).Reply)
+	assert.Equal(nilReply, cmd.Execute(db, "set", []string{"lock", "b", "NX", "PX", "30000"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(30), cmd.Execute(db, "ttl", []string{"lock"}).Reply)
+
+	// xx only updates existing keys, keepttl retains the expiration
+	assert.Equal(nilReply, cmd.Execute(db, "set", []string{"k", "a", "XX"}).Reply)
+	assert.Equal(okReply, cmd.Execute(db, "set", []string{"lock", "c", "XX", "KEEPTTL"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(30), cmd.Execute(db, "ttl", []string{"lock"}).Reply)
+
+	// a plain set clears the expiration
+	assert.Equal(okReply, cmd.Execute(db, "set", []string{"lock", "d"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(-1), cmd.Execute(db, "ttl", []string{"lock"}).Reply)
+
+	// get returns the old value
+	assert.Equal(protcl.NewBulkStringReply(false, "d"), cmd.Execute(db, "set", []string{"lock", "e", "GET", "EX", "10"}).Reply)
+	assert.Equal(nilReply, cmd.Execute(db, "set", []string{"k", "e", "GET"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(10), cmd.Execute(db, "ttl", []string{"lock"}).Reply)
+
+	assert.Equal(okReply, cmd.Execute(db, "set", []string{"k", "v", "EXAT", "99999999999"}).Reply)
+	assert.Equal(okReply, cmd.Execute(db, "set", []string{"k", "v", "PXAT", "1"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(db, "exists", []string{"k"}).Reply)
+
+	// conflicting and invalid options
+	assert.Equal(&protcl.ErrSyntax{}, cmd.Execute(db, "set", []string{"k", "v", "NX", "XX"}).Err)
+	assert.Equal(&protcl.ErrSyntax{}, cmd.Execute(db, "set", []string{"k", "v", "EX", "10", "PX", "10"}).Err)
+	assert.Equal(&protcl.ErrSyntax{}, cmd.Execute(db, "set", []string{"k", "v", "KEEPTTL", "EX", "10"}).Err)
+	assert.Equal(&protcl.ErrSyntax{}, cmd.Execute(db, "set", []string{"k", "v", "EX"}).Err)
+	assert.Equal(&protcl.ErrInvalidExpireTime{Cmd: "set"}, cmd.Execute(db, "set", []string{"k", "v", "EX", "0"}).Err)
+	assert.Equal(&protcl.ErrInvalidExpireTime{Cmd: "set"}, cmd.Execute(db, "set", []string{"k", "v", "EX", "9223372036854775807"}).Err)
+	assert.Equal(&protcl.ErrInvalidExpireTime{Cmd: "set"}, cmd.Execute(db, "set", []string{"k", "v", "PX", "9223372036854775807"}).Err)
+	assert.Equal(&protcl.ErrInvalidExpireTime{Cmd: "set"}, cmd.Execute(db, "set", []string{"k", "v", "EXAT", "9223372036854775807"}).Err)
+
+	cmd.Execute(db, "rpush", []string{"l", "v"})
+	assert.Equal(&protcl.ErrWrongType{}, cmd.Execute(db, "set", []string{"l", "v", "GET"}).Err)
+}
+
+func TestCommand_Keys(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.Nil((&Command{}).Keys([]string{"a"}))
+	assert.Equal([]string{"a"}, (&Command{FirstKey: 1, LastKey: 1}).Keys([]string{"a", "b"}))
+	assert.Equal([]string{"a", "b", "c"}, (&Command{FirstKey: 1, LastKey: -1}).Keys([]string{"a", "b", "c"}))
+	assert.Equal([]string{"a", "c"}, (&Command{FirstKey: 1, LastKey: -1, KeyStep: 2}).Keys([]string{"a", "b", "c", "d"}))
+	assert.Equal([]string{"b", "c"}, (&Command{FirstKey: 2, LastKey: -2}).Keys([]string{"a", "b", "c", "d"}))
+	zunionstore := CommandTable["zunionstore"]
+	assert.Equal([]string{"d", "a", "b"}, zunionstore.Keys([]string{"d", "2", "a", "b", "weights", "1", "2"}))
+}
+
+func TestBlockingCommands(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	d := db.NewDB()
+	feed := &recordingFeed{}
+	d.AddFeed(feed)
+
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrNegativeTimeout}, cmd.Execute(d, "blpop", []string{"l", "-1"}).Err)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrTimeoutNotFloat}, cmd.Execute(d, "blpop", []string{"l", "x"}).Err)
+
+	// served immediately when a list holds elements, propagated as the non blocking version
+	cmd.Execute(d, "rpush", []string{"l2", "a", "b"})
+	message, blocked := cmd.ExecuteBlocking(d, "brpop", []string{"l1", "l2", "0"})
+	assert.Nil(blocked)
+	assert.Equal("*2\r\n$2\r\nl2\r\n$1\r\nb\r\n", message.RespReply())
+	assert.Equal([]string{"rpop", "l2"}, feed.commands[1])
+
+	// clients are served in the order they were blocked
+	_, first := cmd.ExecuteBlocking(d, "blpop", []string{"l1", "0"})
+	_, second := cmd.ExecuteBlocking(d, "blpop", []string{"l3", "l1", "0"})
+	assert.NotNil(first)
+	assert.NotNil(second)
+
+	cmd.Execute(d, "rpush", []string{"l1", "x", "y", "z"})
+	assert.Equal("*2\r\n$2\r\nl1\r\n$1\r\nx\r\n", (<-first.Result).RespReply())
+	assert.Equal("*2\r\n$2\r\nl1\r\n$1\r\ny\r\n", (<-second.Result).RespReply())
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(d, "llen", []string{"l1"}).Reply)
+
+	// a blocking command does not block inside a transaction, pushes of a transaction wake clients
+	_, blocked = cmd.ExecuteBlocking(d, "bzpopmin", []string{"z", "0"})
+	rep := cmd.ExecuteMulti(d, []protcl.RespCommand{
+		{Name: "blpop", Args: []string{"empty", "0"}},
+		{Name: "zadd", Args: []string{"z", "2", "b", "1", "a"}},
+		{Name: "zcard", Args: []string{"z"}},
+	}, nil, nil)
+	assert.Equal("*3\r\n*-1\r\n:2\r\n:2\r\n", rep.RespReply())
+	assert.Equal("*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\n1\r\n", (<-blocked.Result).RespReply())
+
+	// BLMOVE wakes the clients blocked on its destination
+	_, moved := cmd.ExecuteBlocking(d, "blmove", []string{"src", "dst", "left", "right", "0"})
+	_, popped := cmd.ExecuteBlocking(d, "brpop", []string{"dst", "0"})
+	cmd.Execute(d, "lpush", []string{"src", "v"})
+	assert.Equal(protcl.NewBulkStringReply(false, "v"), (<-moved.Result).Reply)
+	assert.Equal("*2\r\n$3\r\ndst\r\n$1\r\nv\r\n", (<-popped.Result).RespReply())
+
+	// a client which fails to be served gets the error, the clients behind it are still served
+	cmd.Execute(d, "set", []string{"str", "v"})
+	_, failed := cmd.ExecuteBlocking(d, "blmove", []string{"src", "str", "left", "right", "0"})
+	_, popped = cmd.ExecuteBlocking(d, "blpop", []string{"src", "0"})
+	cmd.Execute(d, "rpush", []string{"src", "x"})
+	assert.Equal(&protcl.ErrWrongType{}, (<-failed.Result).Err)
+	assert.Equal("*2\r\n$3\r\nsrc\r\n$1\r\nx\r\n", (<-popped.Result).RespReply())
+	assert.Equal(protcl.NewIntegerReply(0), cmd.Execute(d, "llen", []string{"src"}).Reply)
+	assert.Equal(0, d.BlockedKeys())
+
+	// the nil reply is returned once the timeout elapses
+	_, blocked = cmd.ExecuteBlocking(d, "blmove", []string{"src", "dst", "left", "right", "1"})
+	assert.Equal(protcl.NewBulkStringReply(true, ""), blocked.Cancel().Reply)
+	assert.Equal(0, d.BlockedKeys())
+}
+
+func TestDBCommand_ExecuteMulti(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	d := db.NewDB()
+
+	commands := []protcl.RespCommand{
+		{Name: "set", Args: []string{"a", "1"}},
+		{Name: "incr", Args: []string{"a"}},
+		{Name: "lpush", Args: []string{"a", "1"}},
+	}
+
+	rep := cmd.ExecuteMulti(d, commands, nil, nil)
+	assert.Equal("*3\r\n+OK\r\n:2\r\n-WRONGTYP: invalid operation against key holding invalid type of value\r\n", rep.RespReply())
+
+	// a modified watched key aborts the transaction
+	d.Lock()
+	watched := map[*db.DB]map[string]uint64{d: {"a": d.Watch("a")}}
+	d.Unlock()
+
+	cmd.Execute(d, "get", []string{"a"})
+	assert.Equal(protcl.NewArrayReply(false, []protcl.Reply{protcl.NewBulkStringReply(false, "2")}),
+		cmd.ExecuteMulti(d, []protcl.RespCommand{{Name: "get", Args: []string{"a"}}}, watched, nil).Reply)
+
+	cmd.Execute(d, "incr", []string{"a"})
+	assert.Equal(protcl.NewArrayReply(true, nil), cmd.ExecuteMulti(d, commands, watched, nil).Reply)
+	assert.Equal(protcl.NewBulkStringReply(false, "3"), cmd.Execute(d, "get", []string{"a"}).Reply)
+
+	// local commands switch the database of the following commands
+	dbs := db.NewDBs(2)
+	selectDB := func(d *db.DB, cmd protcl.RespCommand) (*db.DB, *protcl.Message) {
+		return dbs[1], protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+	}
+	rep = cmd.ExecuteMulti(dbs[0], []protcl.RespCommand{
+		{Name: "set", Args: []string{"a", "0"}},
+		{Name: "select", Args: []string{"1"}},
+		{Name: "set", Args: []string{"a", "1"}},
+	}, nil, selectDB)
+	assert.Equal("*3\r\n+OK\r\n+OK\r\n+OK\r\n", rep.RespReply())
+	assert.Equal(protcl.NewBulkStringReply(false, "0"), cmd.Execute(dbs[0], "get", []string{"a"}).Reply)
+	assert.Equal(protcl.NewBulkStringReply(false, "1"), cmd.Execute(dbs[1], "get", []string{"a"}).Reply)
+}
+
+type recordingFeed struct {
+	commands [][]string
+}
+
+func (f *recordingFeed) Feed(db int, cmd string, args []string) {
+	f.commands = append(f.commands, append([]string{cmd}, args...))
+}
+
+func TestExecute_Propagate(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	d := db.NewDB()
+	feed := &recordingFeed{}
+	d.AddFeed(feed)
+
+	cmd.Execute(d, "set", []string{"k", "v"})
+	cmd.Execute(d, "get", []string{"k"})
+	cmd.Execute(d, "lpush", []string{"k", "v"})
+	cmd.Execute(d, "pexpireat", []string{"k", "4102444800000"})
+	cmd.Execute(d, "expireat", []string{"k", "1"})
+	assert.Equal([][]string{{"set", "k", "v"}, {"pexpireat", "k", "4102444800000"}, {"del", "k"}}, feed.commands)
+
+	// relative expirations are propagated as absolute ones
+	feed.commands = nil
+	cmd.Execute(d, "set", []string{"k", "v", "EX", "100", "get"})
+	cmd.Execute(d, "expire", []string{"k", "200"})
+	assert.Len(feed.commands, 2)
+	assert.Equal([]string{"set", "k", "v", "get", "pxat"}, feed.commands[0][:5])
+	assert.Equal("pexpireat", feed.commands[1][0])
+
+	d.RemoveFeed(feed)
+	cmd.Execute(d, "del", []string{"k"})
+	assert.Len(feed.commands, 2)
+}
+
+func TestExecute_MaxMemory(t *testing.T) {
+	assert := testifyAssert.New(t)
+	cmd := &DBCommand{}
+	d := db.NewDB()
+
+	cmd.Execute(d, "set", []string{"a", "1"})
+	cmd.Execute(d, "set", []string{"b", "1"})
+	assert.Nil(d.SetMaxMemory(1, db.NoEviction, 0))
+
+	// writes are rejected, reads and commands which release memory are not
+	assert.Equal(&protcl.ErrOOM{}, cmd.Execute(d, "set", []string{"c", "1"}).Err)
+	assert.Equal("-OOM: command not allowed when used memory > 'maxmemory'\r\n", protcl.RespError(&protcl.ErrOOM{}))
+	assert.Equal(protcl.NewBulkStringReply(false, "1"), cmd.Execute(d, "get", []string{"a"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(1), cmd.Execute(d, "del", []string{"a"}).Reply)
+
+	// replicated commands are applied regardless of the limit
+	assert.Nil(cmd.Apply(d, "set", []string{"c", "1"}).Err)
+
+	// keys are evicted to make room for writes
+	assert.Nil(d.SetMaxMemory(d.UsedMemory()-1, db.AllKeysLRU, 0))
+	assert.Nil(cmd.Execute(d, "set", []string{"d", "1"}).Err)
+	assert.Equal(int64(1), d.Evicted())
+}
@@ -0,0 +1,73 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package arch
+
+import (
+	"strconv"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// Replay applies a stream of commands, such as the replication stream or the append only file, in
+// which SELECT switches the database the following commands are applied to
+type Replay struct {
+	db *db.DB
+}
+
+// NewReplay returns a Replay which applies commands to d until a SELECT is replayed
+func NewReplay(d *db.DB) *Replay {
+	return &Replay{db: d}
+}
+
+// Apply applies a command of the stream. SELECT is propagated as is, so that the stream of a replica
+// matches the stream of its primary
+func (r *Replay) Apply(cmd string, args []string) *protcl.Message {
+	if cmd != "select" {
+		return DBCommand{}.Apply(r.db, cmd, args)
+	}
+
+	if len(args) != 1 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd})
+	}
+
+	index, err := strconv.Atoi(args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[0]})
+	}
+
+	target, err := r.db.Select(index)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	target.Lock()
+	target.Propagate(cmd, args)
+	target.Unlock()
+
+	r.db = target
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
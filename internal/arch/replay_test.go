@@ -0,0 +1,55 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package arch
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+func TestReplay_Apply(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := db.NewDBs(2)
+	feed := &recordingFeed{}
+	dbs[0].AddFeed(feed)
+	replay := NewReplay(dbs[0])
+
+	assert.Nil(replay.Apply("set", []string{"a", "0"}).Err)
+	assert.Nil(replay.Apply("select", []string{"1"}).Err)
+	assert.Nil(replay.Apply("set", []string{"a", "1"}).Err)
+	assert.Equal(protcl.NewBulkStringReply(false, "0"), DBCommand{}.Execute(dbs[0], "get", []string{"a"}).Reply)
+	assert.Equal(protcl.NewBulkStringReply(false, "1"), DBCommand{}.Execute(dbs[1], "get", []string{"a"}).Reply)
+
+	// SELECT is propagated as is
+	assert.Equal([][]string{{"set", "a", "0"}, {"select", "1"}, {"set", "a", "1"}}, feed.commands)
+
+	assert.Equal(&protcl.ErrCastFailedToInt{Val: "a"}, replay.Apply("select", []string{"a"}).Err)
+	assert.Equal(&protcl.ErrGeneric{Err: db.ErrInvalidDBIndex}, replay.Apply("select", []string{"2"}).Err)
+	assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "select"}, replay.Apply("select", nil).Err)
+}
@@ -0,0 +1,262 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/kasvith/kache/internal/klogs"
+)
+
+// types of the messages exchanged over the cluster bus
+const (
+	msgMeet = "meet" // asks a node to add the sender to its view
+	msgPing = "ping"
+	msgPong = "pong" // reply to meet and ping
+)
+
+const (
+	// how often every known node is pinged
+	gossipInterval = time.Second
+	// maximum time to exchange a message with a node
+	busTimeout = 2 * time.Second
+)
+
+// nodeInfo is the description of a node sent over the cluster bus
+type nodeInfo struct {
+	ID      string      `json:"id"`
+	Host    string      `json:"host,omitempty"`
+	Port    int         `json:"port"`
+	BusPort int         `json:"busPort"`
+	Epoch   uint64      `json:"epoch"`
+	Slots   []SlotRange `json:"slots,omitempty"`
+}
+
+// message is sent over the cluster bus as a single JSON document. Besides the description of the sender,
+// it carries the other nodes known by the sender so that nodes discover each other
+type message struct {
+	Type         string     `json:"type"`
+	CurrentEpoch uint64     `json:"currentEpoch"`
+	Sender       nodeInfo   `json:"sender"`
+	Gossip       []nodeInfo `json:"gossip,omitempty"`
+}
+
+// info describes node, the lock must be held
+func (c *Cluster) info(node *Node) nodeInfo {
+	return nodeInfo{
+		ID:      node.ID,
+		Host:    node.Host,
+		Port:    node.Port,
+		BusPort: node.BusPort,
+		Epoch:   node.Epoch,
+		Slots:   c.ranges(node),
+	}
+}
+
+func (c *Cluster) message(typ string) *message {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	msg := &message{Type: typ, CurrentEpoch: c.currentEpoch, Sender: c.info(c.myself)}
+	for _, node := range c.nodes {
+		if node != c.myself && !node.Failed {
+			msg.Gossip = append(msg.Gossip, nodeInfo{ID: node.ID, Host: node.Host, Port: node.Port, BusPort: node.BusPort})
+		}
+	}
+
+	return msg
+}
+
+// Listen accepts the messages of the other nodes on the cluster bus at addr
+func (c *Cluster) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				klogs.Logger.Errorf("cluster bus stopped: %s", err)
+				return
+			}
+
+			go c.handle(conn)
+		}
+	}()
+
+	return nil
+}
+
+// handle processes a message received on the cluster bus and replies with a pong
+func (c *Cluster) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(busTimeout))
+
+	var msg message
+	if err := json.NewDecoder(conn).Decode(&msg); err != nil {
+		klogs.Logger.Debugf("invalid cluster bus message from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+
+	// the sender is reachable at the address it connected from
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	msg.Sender.Host = host
+	c.process(&msg, msg.Type == msgMeet)
+
+	json.NewEncoder(conn).Encode(c.message(msgPong))
+}
+
+// send sends a message of type typ to the node at addr and processes the reply
+func (c *Cluster) send(addr, typ string) error {
+	conn, err := net.DialTimeout("tcp", addr, busTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(busTimeout))
+
+	if err := json.NewEncoder(conn).Encode(c.message(typ)); err != nil {
+		return err
+	}
+
+	var reply message
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return err
+	}
+
+	reply.Sender.Host, _, _ = net.SplitHostPort(addr)
+	c.process(&reply, typ == msgMeet)
+
+	return nil
+}
+
+// Meet adds the node listening on host and port to the cluster, the handshake is done in the background
+func (c *Cluster) Meet(host string, port, busPort int) {
+	addr := net.JoinHostPort(host, strconv.Itoa(busPort))
+
+	go func() {
+		if err := c.send(addr, msgMeet); err != nil {
+			klogs.Logger.Warnf("unable to meet %s: %s", addr, err)
+		}
+	}()
+}
+
+// process updates the view with a message, unknown senders are added only if accept is set
+func (c *Cluster) process(msg *message, accept bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	sender, known := c.nodes[msg.Sender.ID]
+	if msg.Sender.ID == c.myself.ID || (!known && !accept) {
+		return
+	}
+
+	if !known {
+		sender = &Node{ID: msg.Sender.ID}
+		c.nodes[sender.ID] = sender
+		klogs.Logger.Infof("node %s joined the cluster", sender.ID)
+	}
+
+	sender.Host, sender.Port, sender.BusPort = msg.Sender.Host, msg.Sender.Port, msg.Sender.BusPort
+	sender.PongAt, sender.Failed = time.Now(), false
+	if msg.CurrentEpoch > c.currentEpoch {
+		c.currentEpoch = msg.CurrentEpoch
+	}
+	c.claim(sender, msg.Sender.Epoch, msg.Sender.Slots)
+
+	for _, info := range msg.Gossip {
+		if _, ok := c.nodes[info.ID]; ok || info.ID == c.myself.ID {
+			continue
+		}
+
+		c.nodes[info.ID] = &Node{ID: info.ID, Host: info.Host, Port: info.Port, BusPort: info.BusPort, PongAt: time.Now()}
+		klogs.Logger.Infof("node %s discovered through %s", info.ID, sender.ID)
+	}
+	c.changed = true
+}
+
+// claim updates the owners of the slots with the slots claimed by node. A slot owned by another
+// node is taken over only if the claim has a higher config epoch. The lock must be held
+func (c *Cluster) claim(node *Node, epoch uint64, ranges []SlotRange) {
+	node.Epoch = epoch
+
+	var claimed [SlotCount]bool
+	for _, r := range ranges {
+		for slot := r.Start; slot <= r.End && slot >= 0 && slot < SlotCount; slot++ {
+			claimed[slot] = true
+		}
+	}
+
+	for slot, owner := range c.slots {
+		switch {
+		case claimed[slot] && (owner == nil || epoch > owner.Epoch):
+			// a slot migrated away from this node is not migrating anymore
+			if owner == c.myself {
+				delete(c.migrating, slot)
+			}
+			c.slots[slot] = node
+		case !claimed[slot] && owner == node:
+			c.slots[slot] = nil
+		}
+	}
+}
+
+// Run pings the known nodes periodically, flags the nodes which do not answer and saves the view
+func (c *Cluster) Run() {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mux.Lock()
+		for _, node := range c.nodes {
+			if node == c.myself {
+				continue
+			}
+
+			if !node.Failed && time.Since(node.PongAt) > c.timeout {
+				node.Failed = true
+				c.changed = true
+				klogs.Logger.Warnf("node %s at %s is not reachable", node.ID, node.Addr())
+			}
+
+			go c.send(node.BusAddr(), msgPing)
+		}
+
+		changed := c.changed
+		c.changed = false
+		c.mux.Unlock()
+
+		if changed {
+			if err := c.save(); err != nil {
+				klogs.Logger.Errorf("error saving the cluster config to %s: %s", c.path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// Default is the cluster the server is part of, it is nil when cluster mode is disabled
+var Default *Cluster
+
+// BusPortOffset is added to the port of a node to get the port of its cluster bus
+const BusPortOffset = 10000
+
+// Node is a member of the cluster
+type Node struct {
+	ID      string
+	Host    string
+	Port    int
+	BusPort int
+	Epoch   uint64    // config epoch, a claim of a slot with a higher epoch wins
+	PongAt  time.Time // last time the node was heard from
+	Failed  bool      // the node did not answer within the node timeout
+}
+
+// Addr returns the address clients connect to
+func (n *Node) Addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// BusAddr returns the address of the cluster bus of the node
+func (n *Node) BusAddr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.BusPort))
+}
+
+// Cluster is the view of the cluster from this node, the owner of every slot and the known nodes.
+// Nodes exchange their view over the cluster bus so that it converges on every node
+type Cluster struct {
+	myself       *Node
+	nodes        map[string]*Node
+	slots        [SlotCount]*Node
+	migrating    map[int]*Node // slots of this node being moved to another node
+	importing    map[int]*Node // slots being moved to this node from another node
+	currentEpoch uint64
+	timeout      time.Duration
+	path         string // file the view is saved to
+	changed      bool   // the view changed since it was saved
+	mux          sync.Mutex
+}
+
+// NewNodeID returns a random node id of 40 hex characters
+func NewNodeID() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+// New returns the cluster of the node listening on host and port, the view saved at path is restored
+// if it exists. Nodes which are not heard from within timeout are flagged as failed
+func New(host string, port int, timeout time.Duration, path string) (*Cluster, error) {
+	c := &Cluster{
+		myself:    &Node{ID: NewNodeID()},
+		nodes:     make(map[string]*Node),
+		migrating: make(map[int]*Node),
+		importing: make(map[int]*Node),
+		timeout:   timeout,
+		path:      path,
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	c.myself.Host, c.myself.Port, c.myself.BusPort = host, port, port+BusPortOffset
+	c.nodes[c.myself.ID] = c.myself
+	c.changed = true
+
+	return c, nil
+}
+
+// MyID returns the id of this node
+func (c *Cluster) MyID() string {
+	return c.myself.ID
+}
+
+// Route checks whether the keys of a command can be served by this node. The keys must hash to the same
+// slot and the slot must be served by this node, otherwise clients are redirected to the owner of the slot.
+// While a slot is migrated, missing keys are redirected to the target with ASK, which are served by the
+// target only to clients asking for them. exists tells whether a key is held by this node
+func (c *Cluster) Route(keys []string, asking bool, exists func(key string) bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return &protcl.ErrCrossSlot{}
+		}
+	}
+
+	c.mux.Lock()
+	owner, migrating, importing := c.slots[slot], c.migrating[slot], c.importing[slot]
+	c.mux.Unlock()
+
+	switch {
+	case owner == c.myself && migrating != nil:
+		missing := 0
+		for _, key := range keys {
+			if !exists(key) {
+				missing++
+			}
+		}
+
+		if missing == len(keys) {
+			return &protcl.ErrAsk{Slot: slot, Addr: migrating.Addr()}
+		} else if missing > 0 {
+			return &protcl.ErrTryAgain{}
+		}
+
+		return nil
+	case owner == c.myself, importing != nil && asking:
+		return nil
+	case owner == nil:
+		return &protcl.ErrClusterDown{}
+	}
+
+	return &protcl.ErrMoved{Slot: slot, Addr: owner.Addr()}
+}
+
+// ParseSlot parses a slot number
+func ParseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("invalid or out of range slot %s", s)
+	}
+
+	return slot, nil
+}
+
+// AddSlots assigns slots to this node, nothing is assigned if any of them is already assigned
+func (c *Cluster) AddSlots(slots []int) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return fmt.Errorf("slot %d is already busy", slot)
+		}
+
+		if seen[slot] {
+			return fmt.Errorf("slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+	}
+
+	for _, slot := range slots {
+		c.slots[slot] = c.myself
+	}
+	c.changed = true
+
+	return nil
+}
+
+// CountSlots returns the number of assigned slots
+func (c *Cluster) CountSlots() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	n := 0
+	for _, owner := range c.slots {
+		if owner != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+// SlotOwner is a range of slots served by the same node
+type SlotOwner struct {
+	SlotRange
+	Node Node
+}
+
+// Slots returns the ranges of slots with their owners
+func (c *Cluster) Slots() []SlotOwner {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var owners []SlotOwner
+	for _, node := range c.nodes {
+		for _, r := range c.ranges(node) {
+			owners = append(owners, SlotOwner{SlotRange: r, Node: *node})
+		}
+	}
+
+	return owners
+}
+
+// ranges returns the slots served by node, the lock must be held
+func (c *Cluster) ranges(node *Node) []SlotRange {
+	return slotRanges(func(slot int) bool { return c.slots[slot] == node })
+}
+
+// Describe returns the view of the cluster in the format of CLUSTER NODES, one line per node
+func (c *Cluster) Describe() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	builder := strings.Builder{}
+	for _, node := range c.nodes {
+		flags, link := "master", "connected"
+		if node == c.myself {
+			flags = "myself,master"
+		} else if node.Failed {
+			flags, link = "master,fail", "disconnected"
+		}
+
+		fmt.Fprintf(&builder, "%s %s@%d %s - 0 %d %d %s", node.ID, node.Addr(), node.BusPort, flags,
+			node.PongAt.UnixNano()/int64(time.Millisecond), node.Epoch, link)
+		for _, r := range c.ranges(node) {
+			builder.WriteString(" " + r.String())
+		}
+		if node == c.myself {
+			for slot, target := range c.migrating {
+				fmt.Fprintf(&builder, " [%d->-%s]", slot, target.ID)
+			}
+			for slot, source := range c.importing {
+				fmt.Fprintf(&builder, " [%d-<-%s]", slot, source.ID)
+			}
+		}
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// Info returns the state of the cluster in the format of CLUSTER INFO
+func (c *Cluster) Info() []string {
+	assigned := c.CountSlots()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	state := "ok"
+	if assigned < SlotCount {
+		state = "fail"
+	}
+
+	size, failed := 0, 0
+	for _, node := range c.nodes {
+		if len(c.ranges(node)) > 0 {
+			size++
+		}
+		if node.Failed {
+			failed++
+		}
+	}
+
+	return []string{
+		"cluster_state:" + state,
+		fmt.Sprintf("cluster_slots_assigned:%d", assigned),
+		fmt.Sprintf("cluster_known_nodes:%d", len(c.nodes)),
+		fmt.Sprintf("cluster_failed_nodes:%d", failed),
+		fmt.Sprintf("cluster_size:%d", size),
+		fmt.Sprintf("cluster_current_epoch:%d", c.currentEpoch),
+		fmt.Sprintf("cluster_my_epoch:%d", c.myself.Epoch),
+	}
+}
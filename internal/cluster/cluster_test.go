@@ -0,0 +1,203 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+func TestKeySlot(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.Equal(12182, KeySlot("foo"))
+	assert.Equal(5061, KeySlot("bar"))
+	assert.Equal(KeySlot("user1000"), KeySlot("{user1000}.following"))
+	assert.Equal(KeySlot("{user1000}.followers"), KeySlot("{user1000}.following"))
+
+	// empty hash tags are not considered
+	assert.Equal(KeySlot("{}foo"), int(crc16("{}foo")%SlotCount))
+	assert.Equal(KeySlot("foo{}{bar}"), int(crc16("foo{}{bar}")%SlotCount))
+	assert.Equal(KeySlot("bar"), KeySlot("foo{bar}{zap}"))
+}
+
+func slotsBetween(start, end int) []int {
+	slots := make([]int, 0, end-start+1)
+	for slot := start; slot <= end; slot++ {
+		slots = append(slots, slot)
+	}
+
+	return slots
+}
+
+// exists reports every key as existing
+func exists(string) bool {
+	return true
+}
+
+// freePort returns a port which is free at the time of the call
+func freePort() int {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestCluster_Route(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c, err := New("127.0.0.1", 7000, time.Second, filepath.Join(dir, "nodes.conf"))
+	assert.Nil(err)
+	assert.Nil(c.AddSlots(slotsBetween(0, 8191)))
+	assert.NotNil(c.AddSlots([]int{8191, 8192}))
+	assert.NotNil(c.AddSlots([]int{8192, 8192}))
+	assert.Equal(8192, c.CountSlots())
+
+	assert.Nil(c.Route(nil, false, exists))
+	assert.Nil(c.Route([]string{"bar", "{bar}2"}, false, exists))
+	assert.Equal(&protcl.ErrCrossSlot{}, c.Route([]string{"bar", "foo"}, false, exists))
+	assert.Equal(&protcl.ErrClusterDown{}, c.Route([]string{"foo"}, false, exists))
+
+	other := &Node{ID: NewNodeID(), Host: "127.0.0.1", Port: 7001}
+	c.nodes[other.ID] = other
+	c.claim(other, 0, []SlotRange{{8192, SlotCount - 1}})
+	assert.Equal(&protcl.ErrMoved{Slot: 12182, Addr: "127.0.0.1:7001"}, c.Route([]string{"foo"}, false, exists))
+
+	// slots of other nodes are taken over only by a higher epoch
+	c.claim(other, 0, []SlotRange{{0, 10}, {8192, SlotCount - 1}})
+	assert.Nil(c.Route([]string{"bar"}, false, exists))
+	c.claim(other, 1, []SlotRange{{0, SlotCount - 1}})
+	assert.Equal(&protcl.ErrMoved{Slot: 5061, Addr: "127.0.0.1:7001"}, c.Route([]string{"bar"}, false, exists))
+
+	// the view is restored with the same id
+	assert.Nil(c.save())
+	restored, err := New("127.0.0.1", 7000, time.Second, filepath.Join(dir, "nodes.conf"))
+	assert.Nil(err)
+	assert.Equal(c.MyID(), restored.MyID())
+	assert.Equal(&protcl.ErrMoved{Slot: 5061, Addr: "127.0.0.1:7001"}, restored.Route([]string{"bar"}, false, exists))
+}
+
+func TestCluster_Meet(t *testing.T) {
+	assert := testifyAssert.New(t)
+	klogs.InitLoggers(config.AppConfig{LogType: "default"})
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	nodes := make([]*Cluster, 3)
+	busPorts := make([]int, 3)
+	for i := range nodes {
+		busPorts[i] = freePort()
+		nodes[i], err = New("127.0.0.1", 7000+i, time.Second, filepath.Join(dir, strconv.Itoa(i)))
+		assert.Nil(err)
+		assert.Nil(nodes[i].Listen(net.JoinHostPort("127.0.0.1", strconv.Itoa(busPorts[i]))))
+	}
+
+	nodes[0].AddSlots(slotsBetween(0, 5000))
+	nodes[1].AddSlots(slotsBetween(5001, 10000))
+	nodes[2].AddSlots(slotsBetween(10001, SlotCount-1))
+
+	// the third node is discovered through the second one
+	assert.Nil(nodes[1].send(net.JoinHostPort("127.0.0.1", strconv.Itoa(busPorts[2])), msgMeet))
+	assert.Nil(nodes[0].send(net.JoinHostPort("127.0.0.1", strconv.Itoa(busPorts[1])), msgMeet))
+	assert.Len(nodes[0].nodes, 3)
+	assert.Equal(10001, nodes[0].CountSlots())
+
+	// nodes accept pings only from nodes they know, the first node is gossiped to the third one
+	assert.Nil(nodes[1].send(net.JoinHostPort("127.0.0.1", strconv.Itoa(busPorts[2])), msgPing))
+	assert.Len(nodes[2].nodes, 3)
+
+	// the bus port advertised in gossip is derived from the port, tests use random ones
+	nodes[0].mux.Lock()
+	third := nodes[0].nodes[nodes[2].MyID()]
+	third.BusPort = busPorts[2]
+	nodes[0].mux.Unlock()
+	assert.Nil(nodes[0].send(third.BusAddr(), msgPing))
+
+	for _, node := range nodes {
+		assert.Equal(SlotCount, node.CountSlots())
+	}
+	assert.Equal(&protcl.ErrMoved{Slot: 12182, Addr: "127.0.0.1:7002"}, nodes[0].Route([]string{"foo"}, false, exists))
+	assert.Equal(&protcl.ErrMoved{Slot: 5061, Addr: "127.0.0.1:7001"}, nodes[2].Route([]string{"bar"}, false, exists))
+	assert.Contains(nodes[0].Info(), "cluster_state:ok")
+}
+
+func TestCluster_SetSlot(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	source, err := New("127.0.0.1", 7000, time.Second, filepath.Join(dir, "source.conf"))
+	assert.Nil(err)
+	target, err := New("127.0.0.1", 7001, time.Second, filepath.Join(dir, "target.conf"))
+	assert.Nil(err)
+
+	source.nodes[target.MyID()] = &Node{ID: target.MyID(), Host: "127.0.0.1", Port: 7001}
+	target.nodes[source.MyID()] = &Node{ID: source.MyID(), Host: "127.0.0.1", Port: 7000}
+	assert.Nil(source.AddSlots([]int{5061}))
+	target.claim(target.nodes[source.MyID()], 0, []SlotRange{{5061, 5061}})
+
+	assert.Equal(ErrNotOwner, target.SetSlotMigrating(5061, source.MyID()))
+	assert.Equal(ErrIsOwner, source.SetSlotImporting(5061, target.MyID()))
+	assert.Equal(ErrMyself, source.SetSlotMigrating(5061, source.MyID()))
+	assert.Equal(&ErrUnknownNode{ID: "foo"}, source.SetSlotMigrating(5061, "foo"))
+	assert.Nil(source.SetSlotMigrating(5061, target.MyID()))
+	assert.Nil(target.SetSlotImporting(5061, source.MyID()))
+	assert.Contains(source.Describe(), "[5061->-"+target.MyID()+"]")
+	assert.Contains(target.Describe(), "[5061-<-"+source.MyID()+"]")
+
+	// keys which were moved already are redirected with ASK, the target serves them only after ASKING
+	onlyBar := func(key string) bool { return key == "bar" }
+	assert.Nil(source.Route([]string{"bar"}, false, exists))
+	assert.Equal(&protcl.ErrAsk{Slot: 5061, Addr: "127.0.0.1:7001"}, source.Route([]string{"{bar}1"}, false, onlyBar))
+	assert.Equal(&protcl.ErrTryAgain{}, source.Route([]string{"bar", "{bar}1"}, false, onlyBar))
+	assert.Equal(&protcl.ErrMoved{Slot: 5061, Addr: "127.0.0.1:7000"}, target.Route([]string{"bar"}, false, exists))
+	assert.Nil(target.Route([]string{"bar"}, true, exists))
+
+	// the slot is assigned to the target, which then wins it over the source
+	assert.Nil(target.SetSlotNode(5061, target.MyID()))
+	assert.Nil(target.Route([]string{"bar"}, false, exists))
+	source.claim(source.nodes[target.MyID()], target.myself.Epoch, []SlotRange{{5061, 5061}})
+	assert.Equal(&protcl.ErrMoved{Slot: 5061, Addr: "127.0.0.1:7001"}, source.Route([]string{"bar"}, false, exists))
+	assert.NotContains(source.Describe(), "->-")
+}
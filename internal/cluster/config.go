@@ -0,0 +1,104 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// savedView is the view of the cluster saved to the cluster config file, so that a node restarts
+// with the same id and knows the other nodes and their slots
+type savedView struct {
+	CurrentEpoch uint64     `json:"currentEpoch"`
+	Myself       string     `json:"myself"`
+	Nodes        []nodeInfo `json:"nodes"`
+}
+
+// load restores the view saved at the path of c, a missing file is not an error
+func (c *Cluster) load() error {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	var view savedView
+	if err := json.Unmarshal(data, &view); err != nil {
+		return err
+	}
+
+	c.currentEpoch = view.CurrentEpoch
+	for _, info := range view.Nodes {
+		node := &Node{ID: info.ID, Host: info.Host, Port: info.Port, BusPort: info.BusPort, PongAt: time.Now()}
+		if node.ID == view.Myself {
+			c.myself = node
+		}
+
+		c.nodes[node.ID] = node
+		c.claim(node, info.Epoch, info.Slots)
+	}
+
+	return nil
+}
+
+// save writes the view to the path of c
+func (c *Cluster) save() error {
+	c.mux.Lock()
+	view := savedView{CurrentEpoch: c.currentEpoch, Myself: c.myself.ID}
+	for _, node := range c.nodes {
+		view.Nodes = append(view.Nodes, c.info(node))
+	}
+	c.mux.Unlock()
+
+	data, err := json.MarshalIndent(view, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), "temp-nodes-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
@@ -0,0 +1,136 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotOwner = errors.New("i'm not the owner of hash slot")
+	ErrIsOwner  = errors.New("i'm already the owner of hash slot")
+	ErrMyself   = errors.New("can't migrate or import a slot to or from myself")
+)
+
+type ErrUnknownNode struct {
+	ID string
+}
+
+func (e *ErrUnknownNode) Error() string {
+	return fmt.Sprintf("i don't know about node %s", e.ID)
+}
+
+// node returns the known node with id, the lock must be held
+func (c *Cluster) node(id string) (*Node, error) {
+	node, ok := c.nodes[id]
+	if !ok {
+		return nil, &ErrUnknownNode{ID: id}
+	}
+
+	return node, nil
+}
+
+// SetSlotMigrating starts moving a slot served by this node to the node with id
+func (c *Cluster) SetSlotMigrating(slot int, id string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.slots[slot] != c.myself {
+		return ErrNotOwner
+	}
+
+	target, err := c.node(id)
+	if err != nil {
+		return err
+	}
+
+	if target == c.myself {
+		return ErrMyself
+	}
+
+	c.migrating[slot] = target
+	c.changed = true
+
+	return nil
+}
+
+// SetSlotImporting starts moving a slot served by the node with id to this node
+func (c *Cluster) SetSlotImporting(slot int, id string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.slots[slot] == c.myself {
+		return ErrIsOwner
+	}
+
+	source, err := c.node(id)
+	if err != nil {
+		return err
+	}
+
+	if source == c.myself {
+		return ErrMyself
+	}
+
+	c.importing[slot] = source
+	c.changed = true
+
+	return nil
+}
+
+// SetSlotStable stops moving a slot
+func (c *Cluster) SetSlotStable(slot int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.migrating, slot)
+	delete(c.importing, slot)
+	c.changed = true
+}
+
+// SetSlotNode assigns a slot to the node with id, which ends its migration. When this node takes
+// over the slot its config epoch is bumped, so that its claim of the slot wins on the other nodes
+func (c *Cluster) SetSlotNode(slot int, id string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	node, err := c.node(id)
+	if err != nil {
+		return err
+	}
+
+	if node == c.myself && c.slots[slot] != c.myself {
+		c.currentEpoch++
+		c.myself.Epoch = c.currentEpoch
+	}
+
+	c.slots[slot] = node
+	delete(c.migrating, slot)
+	delete(c.importing, slot)
+	c.changed = true
+
+	return nil
+}
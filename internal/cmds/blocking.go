@@ -0,0 +1,149 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+var (
+	ErrTimeoutNotFloat = errors.New("timeout is not a float or out of range")
+	ErrNegativeTimeout = errors.New("timeout is negative")
+)
+
+// ParseTimeout parses the timeout of a blocking command given in seconds, 0 blocks forever
+func ParseTimeout(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) || secs > math.MaxInt64/float64(time.Second) {
+		return 0, &protcl.ErrGeneric{Err: ErrTimeoutNotFloat}
+	}
+
+	if secs < 0 {
+		return 0, &protcl.ErrGeneric{Err: ErrNegativeTimeout}
+	}
+
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// The blocking commands below only try to serve the client without blocking, a nil reply means
+// nothing could be popped. The client is blocked on the keys by the caller, so they behave like
+// their non blocking versions inside a transaction
+
+// BLPop pops the head of the first non empty list and replies with its key and the element
+func BLPop(d *db.DB, args []string) *protcl.Message {
+	return bpop(d, args, true)
+}
+
+// BRPop pops the tail of the first non empty list and replies with its key and the element
+func BRPop(d *db.DB, args []string) *protcl.Message {
+	return bpop(d, args, false)
+}
+
+func bpop(d *db.DB, args []string, head bool) *protcl.Message {
+	if _, err := ParseTimeout(args[len(args)-1]); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	for _, key := range args[:len(args)-1] {
+		l, err := getList(d, key)
+		if err != nil {
+			return protcl.NewMessage(nil, err)
+		}
+
+		if l == nil || l.Len() == 0 {
+			continue
+		}
+
+		var val string
+		if head {
+			val = l.HPop()
+		} else {
+			val = l.TPop()
+		}
+		delIfEmptyList(d, key, l)
+
+		return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies([]string{key, val})), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(true, nil), nil)
+}
+
+// BLMove is the blocking version of LMOVE
+func BLMove(d *db.DB, args []string) *protcl.Message {
+	if _, err := ParseTimeout(args[4]); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return move(d, args[0], args[1], args[2], args[3])
+}
+
+// BZPopMin pops the member with the lowest score of the first non empty sorted set and replies
+// with its key, the member and its score
+func BZPopMin(d *db.DB, args []string) *protcl.Message {
+	return bzpop(d, args, false)
+}
+
+// BZPopMax pops the member with the highest score of the first non empty sorted set and replies
+// with its key, the member and its score
+func BZPopMax(d *db.DB, args []string) *protcl.Message {
+	return bzpop(d, args, true)
+}
+
+func bzpop(d *db.DB, args []string, max bool) *protcl.Message {
+	if _, err := ParseTimeout(args[len(args)-1]); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	for _, key := range args[:len(args)-1] {
+		z, err := getZSet(d, key)
+		if err != nil {
+			return protcl.NewMessage(nil, err)
+		}
+
+		if z == nil || z.Card() == 0 {
+			continue
+		}
+
+		var entries []zset.Entry
+		if max {
+			entries = z.PopMax(1)
+		} else {
+			entries = z.PopMin(1)
+		}
+		delIfEmptyZSet(d, key, z)
+
+		replies := []protcl.Reply{protcl.NewBulkStringReply(false, key)}
+		return protcl.NewMessage(protcl.NewArrayReply(false, append(replies, entriesToReplies(entries, true)...)), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(true, nil), nil)
+}
@@ -0,0 +1,247 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/cluster"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+var (
+	ErrClusterDisabled = errors.New("this instance has cluster support disabled")
+	ErrSelectInCluster = errors.New("SELECT is not allowed in cluster mode")
+)
+
+// Cluster manages cluster mode with the KEYSLOT, ADDSLOTS, MEET, SLOTS, NODES, MYID, INFO, SETSLOT,
+// GETKEYSINSLOT and COUNTKEYSINSLOT subcommands
+func Cluster(d *db.DB, args []string) *protcl.Message {
+	sub := strings.ToLower(args[0])
+	if sub == "keyslot" {
+		if len(args) != 2 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster " + sub})
+		}
+
+		return protcl.NewMessage(protcl.NewIntegerReply(cluster.KeySlot(args[1])), nil)
+	}
+
+	c := cluster.Default
+	if c == nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrClusterDisabled})
+	}
+
+	switch sub {
+	case "addslots":
+		return clusterAddSlots(c, args[1:])
+	case "meet":
+		return clusterMeet(c, args[1:])
+	case "slots":
+		return clusterSlots(c, args[1:])
+	case "setslot":
+		return clusterSetSlot(d, c, args[1:])
+	case "getkeysinslot":
+		return clusterGetKeysInSlot(d, args[1:])
+	case "countkeysinslot":
+		if len(args) != 2 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster " + sub})
+		}
+
+		slot, err := cluster.ParseSlot(args[1])
+		if err != nil {
+			return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+		}
+
+		return protcl.NewMessage(protcl.NewIntegerReply(len(keysInSlot(d, slot, -1))), nil)
+	case "nodes":
+		if len(args) != 1 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster " + sub})
+		}
+
+		return protcl.NewMessage(protcl.NewBulkStringReply(false, c.Describe()), nil)
+	case "myid":
+		if len(args) != 1 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster " + sub})
+		}
+
+		return protcl.NewMessage(protcl.NewBulkStringReply(false, c.MyID()), nil)
+	case "info":
+		if len(args) != 1 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster " + sub})
+		}
+
+		return protcl.NewMessage(protcl.NewBulkStringReply(false, strings.Join(c.Info(), "\r\n")+"\r\n"), nil)
+	}
+
+	return protcl.NewMessage(nil, &protcl.ErrUnknownCommand{Cmd: "cluster " + args[0]})
+}
+
+func clusterAddSlots(c *cluster.Cluster, args []string) *protcl.Message {
+	if len(args) == 0 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster addslots"})
+	}
+
+	slots := make([]int, len(args))
+	for i, arg := range args {
+		slot, err := cluster.ParseSlot(arg)
+		if err != nil {
+			return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+		}
+		slots[i] = slot
+	}
+
+	if err := c.AddSlots(slots); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+func clusterMeet(c *cluster.Cluster, args []string) *protcl.Message {
+	if len(args) != 2 && len(args) != 3 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster meet"})
+	}
+
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port <= 0 || port > 65535-cluster.BusPortOffset {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: fmt.Errorf("invalid port %s", args[1])})
+	}
+
+	busPort := port + cluster.BusPortOffset
+	if len(args) == 3 {
+		if busPort, err = strconv.Atoi(args[2]); err != nil || busPort <= 0 || busPort > 65535 {
+			return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: fmt.Errorf("invalid bus port %s", args[2])})
+		}
+	}
+
+	c.Meet(args[0], port, busPort)
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+func clusterSlots(c *cluster.Cluster, args []string) *protcl.Message {
+	if len(args) != 0 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster slots"})
+	}
+
+	owners := c.Slots()
+	sort.Slice(owners, func(i, j int) bool { return owners[i].Start < owners[j].Start })
+
+	replies := make([]protcl.Reply, len(owners))
+	for i, owner := range owners {
+		replies[i] = protcl.NewArrayReply(false, []protcl.Reply{
+			protcl.NewIntegerReply(owner.Start),
+			protcl.NewIntegerReply(owner.End),
+			protcl.NewArrayReply(false, []protcl.Reply{
+				protcl.NewBulkStringReply(false, owner.Node.Host),
+				protcl.NewIntegerReply(owner.Node.Port),
+				protcl.NewBulkStringReply(false, owner.Node.ID),
+			}),
+		})
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+}
+
+// keysInSlot returns at most count keys of d which hash to slot, all of them if count is negative
+func keysInSlot(d *db.DB, slot, count int) []string {
+	var keys []string
+
+	for _, key := range d.Keys() {
+		if count >= 0 && len(keys) >= count {
+			break
+		}
+
+		if cluster.KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func clusterGetKeysInSlot(d *db.DB, args []string) *protcl.Message {
+	if len(args) != 2 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster getkeysinslot"})
+	}
+
+	slot, err := cluster.ParseSlot(args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: fmt.Errorf("invalid number of keys %s", args[1])})
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies(keysInSlot(d, slot, count))), nil)
+}
+
+// clusterSetSlot changes the state of a slot with IMPORTING, MIGRATING, STABLE and NODE
+func clusterSetSlot(d *db.DB, c *cluster.Cluster, args []string) *protcl.Message {
+	if len(args) < 2 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster setslot"})
+	}
+
+	slot, err := cluster.ParseSlot(args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	state := strings.ToLower(args[1])
+	if (state == "stable" && len(args) != 2) || (state != "stable" && len(args) != 3) {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "cluster setslot"})
+	}
+
+	switch state {
+	case "migrating":
+		err = c.SetSlotMigrating(slot, args[2])
+	case "importing":
+		err = c.SetSlotImporting(slot, args[2])
+	case "stable":
+		c.SetSlotStable(slot)
+	case "node":
+		// keys of the slot must be migrated before the slot is given away
+		if args[2] != c.MyID() && len(keysInSlot(d, slot, 1)) > 0 {
+			err = fmt.Errorf("i still hold keys of slot %d, can't assign to %s", slot, args[2])
+		} else {
+			err = c.SetSlotNode(slot, args[2])
+		}
+	default:
+		return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+	}
+
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
@@ -0,0 +1,389 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+var (
+	ErrUnknownConfig   = errors.New("unsupported CONFIG parameter")
+	ErrImmutableConfig = errors.New("can't set immutable config")
+	ErrNoConfigFile    = errors.New("the server is running without a config file")
+)
+
+// configParam is a setting of the server exposed by CONFIG
+type configParam struct {
+	name    string                                // key of the setting in the config file
+	field   func(c *config.AppConfig) interface{} // pointer to the field of the setting
+	mutable bool                                  // the setting can be changed at runtime with CONFIG SET
+	min     int                                   // lowest value of an integer setting
+	apply   func(d *db.DB, c config.AppConfig) error
+}
+
+// configParams are the settings exposed by CONFIG in the order they are listed
+var configParams = []configParam{
+	{name: "host", field: func(c *config.AppConfig) interface{} { return &c.Host }},
+	{name: "port", field: func(c *config.AppConfig) interface{} { return &c.Port }},
+	{name: "maxClients", field: func(c *config.AppConfig) interface{} { return &c.MaxClients }, mutable: true},
+	{name: "maxTimeout", field: func(c *config.AppConfig) interface{} { return &c.MaxTimeout }, mutable: true},
+	{name: "maxMultiBlkLength", field: func(c *config.AppConfig) interface{} { return &c.MaxMultiBlkLength }, mutable: true, min: 1},
+	{name: "databases", field: func(c *config.AppConfig) interface{} { return &c.Databases }},
+	{name: "verbose", field: func(c *config.AppConfig) interface{} { return &c.Verbose }, mutable: true, apply: applyLogLevel},
+	{name: "debug", field: func(c *config.AppConfig) interface{} { return &c.Debug }, mutable: true, apply: applyLogLevel},
+	{name: "logging", field: func(c *config.AppConfig) interface{} { return &c.Logging }},
+	{name: "logfile", field: func(c *config.AppConfig) interface{} { return &c.Logfile }},
+	{name: "logtype", field: func(c *config.AppConfig) interface{} { return &c.LogType }},
+	{name: "dir", field: func(c *config.AppConfig) interface{} { return &c.Dir }},
+	{name: "dbfilename", field: func(c *config.AppConfig) interface{} { return &c.DbFilename }},
+	{name: "save", field: func(c *config.AppConfig) interface{} { return &c.Save }},
+	{name: "appendonly", field: func(c *config.AppConfig) interface{} { return &c.AppendOnly }},
+	{name: "appendfilename", field: func(c *config.AppConfig) interface{} { return &c.AppendFilename }},
+	{name: "appendfsync", field: func(c *config.AppConfig) interface{} { return &c.AppendFsync }},
+	{name: "replicaof", field: func(c *config.AppConfig) interface{} { return &c.ReplicaOf }},
+	{name: "replicaReadOnly", field: func(c *config.AppConfig) interface{} { return &c.ReplicaReadOnly }},
+	{name: "replBacklogSize", field: func(c *config.AppConfig) interface{} { return &c.ReplBacklogSize }},
+	{name: "clusterEnabled", field: func(c *config.AppConfig) interface{} { return &c.ClusterEnabled }},
+	{name: "clusterConfigFile", field: func(c *config.AppConfig) interface{} { return &c.ClusterConfigFile }},
+	{name: "clusterNodeTimeout", field: func(c *config.AppConfig) interface{} { return &c.ClusterNodeTimeout }},
+	{name: "maxmemory", field: func(c *config.AppConfig) interface{} { return &c.MaxMemory }, mutable: true, apply: applyMaxMemory},
+	{name: "maxmemoryPolicy", field: func(c *config.AppConfig) interface{} { return &c.MaxMemoryPolicy }, mutable: true, apply: applyMaxMemory},
+	{name: "maxmemorySamples", field: func(c *config.AppConfig) interface{} { return &c.MaxMemorySamples }, mutable: true, min: 1, apply: applyMaxMemory},
+}
+
+var (
+	// configChanged are the settings changed with CONFIG SET, they are written by CONFIG REWRITE even
+	// if the config file does not hold them
+	configChanged = make(map[string]bool)
+	// configMux guards configChanged and serializes CONFIG SET and CONFIG REWRITE, which run without
+	// the lock of the databases
+	configMux sync.Mutex
+)
+
+func applyLogLevel(d *db.DB, c config.AppConfig) error {
+	klogs.SetLevel(c.Debug, c.Verbose)
+	return nil
+}
+
+// applyMaxMemory changes the memory limit, keys are evicted right away if it is exceeded
+func applyMaxMemory(d *db.DB, c config.AppConfig) error {
+	maxMemory, err := util.ParseMemory(c.MaxMemory)
+	if err != nil {
+		return err
+	}
+
+	if err := d.SetMaxMemory(maxMemory, c.MaxMemoryPolicy, c.MaxMemorySamples); err != nil {
+		return err
+	}
+	d.FreeMemory()
+
+	return nil
+}
+
+func findConfigParam(name string) (*configParam, bool) {
+	for i := range configParams {
+		if strings.EqualFold(configParams[i].name, name) {
+			return &configParams[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// format returns the value of the setting as shown by CONFIG GET
+func (p *configParam) format(c *config.AppConfig) string {
+	switch v := p.field(c).(type) {
+	case *string:
+		return *v
+	case *int:
+		return strconv.Itoa(*v)
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *[]string:
+		return strings.Join(*v, ", ")
+	}
+
+	return ""
+}
+
+// toml returns the value of the setting as written to the config file
+func (p *configParam) toml(c *config.AppConfig) string {
+	switch v := p.field(c).(type) {
+	case *string:
+		return strconv.Quote(*v)
+	case *[]string:
+		quoted := make([]string, len(*v))
+		for i, s := range *v {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+
+	return p.format(c)
+}
+
+// parse stores val in the setting of c
+func (p *configParam) parse(c *config.AppConfig, val string) error {
+	invalid := fmt.Errorf("invalid argument '%s' for CONFIG SET '%s'", val, strings.ToLower(p.name))
+
+	switch v := p.field(c).(type) {
+	case *string:
+		*v = val
+	case *int:
+		n, err := strconv.Atoi(val)
+		if err != nil || n < p.min {
+			return invalid
+		}
+		*v = n
+	case *bool:
+		switch strings.ToLower(val) {
+		case "true", "yes":
+			*v = true
+		case "false", "no":
+			*v = false
+		default:
+			return invalid
+		}
+	default:
+		return invalid
+	}
+
+	return nil
+}
+
+// Config inspects and changes the settings of the server with the GET, SET and REWRITE subcommands.
+// It is executed by the connection rather than the CommandTable, so that REWRITE does not write the
+// file while the lock of d is held, GET and SET must be called with the lock held
+func Config(d *db.DB, args []string) *protcl.Message {
+	if len(args) == 0 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "config"})
+	}
+
+	sub := strings.ToLower(args[0])
+	switch sub {
+	case "get":
+		if len(args) < 2 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "config " + sub})
+		}
+
+		return configGet(args[1:])
+	case "set":
+		if len(args) != 3 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "config " + sub})
+		}
+
+		return configSet(d, args[1], args[2])
+	case "rewrite":
+		if len(args) != 1 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "config " + sub})
+		}
+
+		return ConfigRewrite()
+	}
+
+	return protcl.NewMessage(nil, &protcl.ErrUnknownCommand{Cmd: "config " + args[0]})
+}
+
+// configGet replies with the names and the values of the settings matching one of the glob patterns
+func configGet(patterns []string) *protcl.Message {
+	c := config.Get()
+
+	var replies []protcl.Reply
+	for i := range configParams {
+		p := &configParams[i]
+		name := strings.ToLower(p.name)
+
+		for _, pattern := range patterns {
+			if util.GlobMatch(strings.ToLower(pattern), name) {
+				replies = append(replies, protcl.NewBulkStringReply(false, name), protcl.NewBulkStringReply(false, p.format(&c)))
+				break
+			}
+		}
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+}
+
+// configSet validates and applies a new value of a setting which can be changed at runtime
+func configSet(d *db.DB, name, val string) *protcl.Message {
+	p, ok := findConfigParam(name)
+	if !ok {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrUnknownConfig})
+	}
+
+	if !p.mutable {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrImmutableConfig})
+	}
+
+	configMux.Lock()
+	defer configMux.Unlock()
+
+	c := config.Get()
+	if err := p.parse(&c, val); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	if p.apply != nil {
+		if err := p.apply(d, c); err != nil {
+			return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+		}
+	}
+
+	config.Set(c)
+	configChanged[p.name] = true
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// tomlValueLen returns the length of the TOML value at the start of s, which may be followed by
+// spaces and a comment. -1 is returned if the value does not end on the line
+func tomlValueLen(s string) int {
+	depth := 0 // nesting of arrays
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			// escapes are only allowed in basic strings
+			quote := s[i]
+			for i++; i < len(s) && s[i] != quote; i++ {
+				if quote == '"' && s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				return -1
+			}
+			if depth == 0 {
+				return i + 1
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case ' ', '\t', '#':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	if depth > 0 {
+		return -1
+	}
+
+	return len(s)
+}
+
+// ConfigRewrite writes the live settings to the config file, it does not need the lock of the databases
+func ConfigRewrite() *protcl.Message {
+	configMux.Lock()
+	defer configMux.Unlock()
+
+	if err := configRewrite(config.File); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// configRewrite writes the live settings to the config file at path. Lines holding a setting are
+// updated in place, so that comments and the order of the file are preserved, and changed settings
+// missing from the file are appended
+func configRewrite(path string) error {
+	if path == "" {
+		return ErrNoConfigFile
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// the line endings of the file are kept
+	content := string(data)
+	sep := "\n"
+	if strings.Contains(content, "\r\n") {
+		sep = "\r\n"
+	} else if !strings.Contains(content, "\n") && strings.Contains(content, "\r") {
+		sep = "\r"
+	}
+
+	c := config.Get()
+	lines := strings.Split(strings.TrimSuffix(content, sep), sep)
+	written := make(map[string]bool)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		eq := strings.Index(trimmed, "=")
+		if strings.HasPrefix(trimmed, "#") || eq < 0 {
+			continue
+		}
+
+		key := strings.TrimSpace(trimmed[:eq])
+		p, ok := findConfigParam(key)
+		if !ok {
+			continue
+		}
+		written[p.name] = true
+
+		// only the value is replaced, the spacing and an inline comment after it are kept
+		start := strings.Index(line, "=") + 1
+		for start < len(line) && (line[start] == ' ' || line[start] == '\t') {
+			start++
+		}
+
+		// a value spanning several lines, such as an array, is left as is
+		if end := tomlValueLen(line[start:]); end >= 0 {
+			lines[i] = line[:start] + p.toml(&c) + line[start+end:]
+		}
+	}
+
+	for i := range configParams {
+		if p := &configParams[i]; configChanged[p.name] && !written[p.name] {
+			lines = append(lines, p.name+"="+p.toml(&c))
+		}
+	}
+
+	// the file is replaced at once, so that a failure can't leave it half written
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, sep)+sep), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,279 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+)
+
+// getHashMap returns the hash stored at key, a nil hash is returned when the key does not exist
+func getHashMap(d *db.DB, key string) (*hashmap.HashMap, error) {
+	val, err := d.Get(key)
+	if err != nil {
+		return nil, nil
+	}
+
+	if val.Type != db.TypeHashMap {
+		return nil, &protcl.ErrWrongType{}
+	}
+
+	return val.Value.(*hashmap.HashMap), nil
+}
+
+// getOrCreateHashMap returns the hash stored at key, a new hash is stored at key if it does not exist
+func getOrCreateHashMap(d *db.DB, key string) (*hashmap.HashMap, error) {
+	m, err := getHashMap(d, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		m = hashmap.New()
+		d.Set(key, db.NewDataNode(db.TypeHashMap, -1, m))
+	}
+
+	return m, nil
+}
+
+func stringsToReplies(vals []string) []protcl.Reply {
+	replies := make([]protcl.Reply, len(vals))
+	for i, v := range vals {
+		replies[i] = protcl.NewBulkStringReply(false, v)
+	}
+
+	return replies
+}
+
+func HSet(d *db.DB, args []string) *protcl.Message {
+	if len(args)%2 != 1 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "hset"})
+	}
+
+	m, err := getOrCreateHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		added += m.Set(args[i], args[i+1])
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(added), nil)
+}
+
+func HSetNX(d *db.DB, args []string) *protcl.Message {
+	m, err := getOrCreateHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(m.Setx(args[1], args[2])), nil)
+}
+
+func HMSet(d *db.DB, args []string) *protcl.Message {
+	if len(args)%2 != 1 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "hmset"})
+	}
+
+	m, err := getOrCreateHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	res, err := m.SetBulk(args[1:])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply(res), nil)
+}
+
+func HGet(d *db.DB, args []string) *protcl.Message {
+	m, err := getHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if m == nil || m.Exists(args[1]) == 0 {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, m.Get(args[1])), nil)
+}
+
+func HMGet(d *db.DB, args []string) *protcl.Message {
+	m, err := getHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	fields := args[1:]
+	replies := make([]protcl.Reply, len(fields))
+
+	for i, field := range fields {
+		if m == nil || m.Exists(field) == 0 {
+			replies[i] = protcl.NewBulkStringReply(true, "")
+			continue
+		}
+
+		replies[i] = protcl.NewBulkStringReply(false, m.Get(field))
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+}
+
+func HDel(d *db.DB, args []string) *protcl.Message {
+	m, err := getHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if m == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	deleted := m.Delete(args[1:])
+
+	// last field was removed, we dont keep empty hashes
+	if m.Len() == 0 {
+		d.Del([]string{args[0]})
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(deleted), nil)
+}
+
+func HExists(d *db.DB, args []string) *protcl.Message {
+	m, err := getHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if m == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(m.Exists(args[1])), nil)
+}
+
+func HKeys(d *db.DB, args []string) *protcl.Message {
+	return hashElems(d, args[0], (*hashmap.HashMap).Keys)
+}
+
+func HVals(d *db.DB, args []string) *protcl.Message {
+	return hashElems(d, args[0], (*hashmap.HashMap).Vals)
+}
+
+func HGetAll(d *db.DB, args []string) *protcl.Message {
+	return hashElems(d, args[0], (*hashmap.HashMap).Fields)
+}
+
+// hashElems replies with the elements returned by fn for the hash stored at key
+func hashElems(d *db.DB, key string, fn func(*hashmap.HashMap) []string) *protcl.Message {
+	m, err := getHashMap(d, key)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if m == nil {
+		return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{}), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies(fn(m))), nil)
+}
+
+func HIncrBy(d *db.DB, args []string) *protcl.Message {
+	amount, err := parseInt(args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	m, err := getOrCreateHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	n, err := m.IncrementBy(args[1], amount)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(n), nil)
+}
+
+func HIncrByFloat(d *db.DB, args []string) *protcl.Message {
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToFloat{Val: args[2]})
+	}
+
+	// rejected before the hash is created so that no empty hash is left behind
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: hashmap.ErrNaNOrInf})
+	}
+
+	m, err := getOrCreateHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	n, err := m.IncrementByFloat(args[1], amount)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, strconv.FormatFloat(n, 'f', -1, 64)), nil)
+}
+
+func HLen(d *db.DB, args []string) *protcl.Message {
+	m, err := getHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if m == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(m.Len()), nil)
+}
+
+func HStrLen(d *db.DB, args []string) *protcl.Message {
+	m, err := getHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if m == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(m.FLen(args[1])), nil)
+}
@@ -25,10 +25,21 @@
 package cmds
 
 import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/protcl"
 )
 
+var (
+	ErrNoSuchKey = errors.New("no such key")
+	ErrSameDB    = errors.New("source and destination objects are the same")
+)
+
 func Exists(d *db.DB, args []string) *protcl.Message {
 	found := d.Exists(args[0])
 	return protcl.NewMessage(protcl.NewIntegerReply(found), nil)
@@ -38,3 +49,248 @@ func Del(d *db.DB, args []string) *protcl.Message {
 	deleted := d.Del(args)
 	return protcl.NewMessage(protcl.NewIntegerReply(deleted), nil)
 }
+
+func Expire(d *db.DB, args []string) *protcl.Message {
+	return expire(d, args, "expire", db.Now(), int64(time.Second/time.Millisecond))
+}
+
+func PExpire(d *db.DB, args []string) *protcl.Message {
+	return expire(d, args, "pexpire", db.Now(), 1)
+}
+
+func ExpireAt(d *db.DB, args []string) *protcl.Message {
+	return expire(d, args, "expireat", 0, int64(time.Second/time.Millisecond))
+}
+
+func PExpireAt(d *db.DB, args []string) *protcl.Message {
+	return expire(d, args, "pexpireat", 0, 1)
+}
+
+// expireTime returns base + t * unit for a base that is not negative,
+// false when the result overflows an int64
+func expireTime(base int64, t int, unit int64) (int64, bool) {
+	v := int64(t)
+	if v > (math.MaxInt64-base)/unit || v < math.MinInt64/unit {
+		return 0, false
+	}
+
+	return base + v*unit, true
+}
+
+// expire sets the expiration of the key to base + args[1] * unit milliseconds,
+// a time in the past deletes the key right away
+func expire(d *db.DB, args []string, cmd string, base int64, unit int64) *protcl.Message {
+	t, err := parseInt(args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	at, ok := expireTime(base, t, unit)
+	if !ok {
+		return protcl.NewMessage(nil, &protcl.ErrInvalidExpireTime{Cmd: cmd})
+	}
+
+	if at <= db.Now() {
+		return protcl.NewMessage(protcl.NewIntegerReply(d.Del([]string{args[0]})), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(d.Expire(args[0], at)), nil)
+}
+
+func TTL(d *db.DB, args []string) *protcl.Message {
+	ttl := d.TTL(args[0])
+	if ttl < 0 {
+		return protcl.NewMessage(protcl.NewIntegerReply(int(ttl)), nil)
+	}
+
+	// round to the nearest second
+	return protcl.NewMessage(protcl.NewIntegerReply(int((ttl+500)/1000)), nil)
+}
+
+func PTTL(d *db.DB, args []string) *protcl.Message {
+	return protcl.NewMessage(protcl.NewIntegerReply(int(d.TTL(args[0]))), nil)
+}
+
+func Persist(d *db.DB, args []string) *protcl.Message {
+	return protcl.NewMessage(protcl.NewIntegerReply(d.Persist(args[0])), nil)
+}
+
+// Type replies with the type of the value at key, none if the key does not exist
+func Type(d *db.DB, args []string) *protcl.Message {
+	node, err := d.Get(args[0])
+	if err != nil {
+		return protcl.NewMessage(protcl.NewSimpleStringReply("none"), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply(node.Type.String()), nil)
+}
+
+func Rename(d *db.DB, args []string) *protcl.Message {
+	if !d.Rename(args[0], args[1]) {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrNoSuchKey})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// RenameNX renames key only if the new key does not exist
+func RenameNX(d *db.DB, args []string) *protcl.Message {
+	if d.Exists(args[0]) == 0 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrNoSuchKey})
+	}
+
+	if d.Exists(args[1]) == 1 {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	d.Rename(args[0], args[1])
+
+	return protcl.NewMessage(protcl.NewIntegerReply(1), nil)
+}
+
+// Copy copies the value of source to destination along with its expiration, containers are copied
+// deeply. The destination is overwritten only with REPLACE
+func Copy(d *db.DB, args []string) *protcl.Message {
+	replace := false
+	for _, opt := range args[2:] {
+		if strings.ToLower(opt) != "replace" {
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+		replace = true
+	}
+
+	node, err := d.Get(args[0])
+	if err != nil || args[0] == args[1] {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	if !replace && d.Exists(args[1]) == 1 {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	d.Set(args[1], node.Copy())
+
+	return protcl.NewMessage(protcl.NewIntegerReply(1), nil)
+}
+
+// Touch updates the last access time of keys and replies with the number of keys which exist
+func Touch(d *db.DB, args []string) *protcl.Message {
+	touched := 0
+	for _, key := range args {
+		touched += d.Exists(key)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(touched), nil)
+}
+
+// Unlink deletes keys like DEL, but large values are released in the background
+func Unlink(d *db.DB, args []string) *protcl.Message {
+	return protcl.NewMessage(protcl.NewIntegerReply(d.Unlink(args)), nil)
+}
+
+func DBSize(d *db.DB, args []string) *protcl.Message {
+	return protcl.NewMessage(protcl.NewIntegerReply(d.Size()), nil)
+}
+
+// FlushDB removes all the keys, ASYNC releases them in the background
+func FlushDB(d *db.DB, args []string) *protcl.Message {
+	async, err := parseFlushArgs(args)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if async {
+		d.FlushAsync()
+	} else {
+		d.Flush()
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// FlushAll removes the keys of all the databases, ASYNC releases them in the background
+func FlushAll(d *db.DB, args []string) *protcl.Message {
+	async, err := parseFlushArgs(args)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	for i := 0; i < d.Databases(); i++ {
+		target, _ := d.Select(i)
+		if async {
+			target.FlushAsync()
+		} else {
+			target.Flush()
+		}
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// parseFlushArgs reports whether ASYNC was given to FLUSHDB or FLUSHALL
+func parseFlushArgs(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "async":
+		return true, nil
+	case "sync":
+		return false, nil
+	}
+
+	return false, &protcl.ErrSyntax{}
+}
+
+// parseDBIndex parses the index of a database of the server of d
+func parseDBIndex(d *db.DB, arg string) (*db.DB, error) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, &protcl.ErrCastFailedToInt{Val: arg}
+	}
+
+	target, err := d.Select(index)
+	if err != nil {
+		return nil, &protcl.ErrGeneric{Err: err}
+	}
+
+	return target, nil
+}
+
+// SwapDB swaps two databases, clients see the keys of the other database right away
+func SwapDB(d *db.DB, args []string) *protcl.Message {
+	a, err := parseDBIndex(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	b, err := parseDBIndex(d, args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if err := d.SwapDB(a.ID(), b.ID()); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// Move moves key to another database, 0 is replied if key does not exist or the database holds it
+func Move(d *db.DB, args []string) *protcl.Message {
+	target, err := parseDBIndex(d, args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if target == d {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrSameDB})
+	}
+
+	if !d.Move(args[0], target) {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(1), nil)
+}
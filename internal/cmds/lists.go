@@ -0,0 +1,284 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/types/list"
+)
+
+// getList returns the list stored at key, a nil list is returned when the key does not exist
+func getList(d *db.DB, key string) (*list.TList, error) {
+	val, err := d.Get(key)
+	if err != nil {
+		return nil, nil
+	}
+
+	if val.Type != db.TypeList {
+		return nil, &protcl.ErrWrongType{}
+	}
+
+	return val.Value.(*list.TList), nil
+}
+
+// getOrCreateList returns the list stored at key, a new list is stored at key if it does not exist
+func getOrCreateList(d *db.DB, key string) (*list.TList, error) {
+	l, err := getList(d, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if l == nil {
+		l = list.New()
+		d.Set(key, db.NewDataNode(db.TypeList, -1, l))
+	}
+
+	return l, nil
+}
+
+// delIfEmptyList removes the key when the list does not hold any element
+func delIfEmptyList(d *db.DB, key string, l *list.TList) {
+	if l.Len() == 0 {
+		d.Del([]string{key})
+	}
+}
+
+func parseInt(val string) (int, error) {
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, &protcl.ErrCastFailedToInt{Val: val}
+	}
+
+	return i, nil
+}
+
+func LPush(d *db.DB, args []string) *protcl.Message {
+	return push(d, args[0], args[1:], true)
+}
+
+func RPush(d *db.DB, args []string) *protcl.Message {
+	return push(d, args[0], args[1:], false)
+}
+
+// push inserts values to the head or tail of the list stored at key
+func push(d *db.DB, key string, vals []string, head bool) *protcl.Message {
+	l, err := getOrCreateList(d, key)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if head {
+		err = l.HPush(vals)
+	} else {
+		err = l.TPush(vals)
+	}
+
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+	d.SignalReady(key)
+
+	return protcl.NewMessage(protcl.NewIntegerReply(l.Len()), nil)
+}
+
+func LPop(d *db.DB, args []string) *protcl.Message {
+	return pop(d, args[0], true)
+}
+
+func RPop(d *db.DB, args []string) *protcl.Message {
+	return pop(d, args[0], false)
+}
+
+// pop removes an element from the head or tail of the list stored at key
+func pop(d *db.DB, key string, head bool) *protcl.Message {
+	l, err := getList(d, key)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if l == nil || l.Len() == 0 {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	var val string
+	if head {
+		val = l.HPop()
+	} else {
+		val = l.TPop()
+	}
+
+	delIfEmptyList(d, key, l)
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, val), nil)
+}
+
+// LMove atomically pops an element from the head or tail of the source list and pushes it to the head or
+// tail of the destination list, replies with the moved element
+func LMove(d *db.DB, args []string) *protcl.Message {
+	return move(d, args[0], args[1], args[2], args[3])
+}
+
+// parseDirection returns whether where names the head of a list
+func parseDirection(where string) (bool, error) {
+	switch strings.ToLower(where) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	}
+
+	return false, &protcl.ErrSyntax{}
+}
+
+// move moves an element from the list stored at src to the list stored at dst
+func move(d *db.DB, src, dst, wherefrom, whereto string) *protcl.Message {
+	fromHead, err := parseDirection(wherefrom)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	toHead, err := parseDirection(whereto)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	l, err := getList(d, src)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if l == nil || l.Len() == 0 {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	// the destination is checked before anything is popped
+	if _, err := getList(d, dst); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	var val string
+	if fromHead {
+		val = l.HPop()
+	} else {
+		val = l.TPop()
+	}
+
+	if message := push(d, dst, []string{val}, toHead); message.Err != nil {
+		return message
+	}
+	delIfEmptyList(d, src, l)
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, val), nil)
+}
+
+func LRange(d *db.DB, args []string) *protcl.Message {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	l, err := getList(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if l == nil {
+		return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{}), nil)
+	}
+
+	// an out of range negative start means the head of the list
+	if start < 0 && start+l.Len() < 0 {
+		start = 0
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies(l.Range(start, stop))), nil)
+}
+
+func LTrim(d *db.DB, args []string) *protcl.Message {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	l, err := getList(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if l == nil {
+		return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+	}
+
+	length := l.Len()
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	// an empty range removes the whole list
+	if start >= length || stop < start {
+		d.Del([]string{args[0]})
+		return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+	}
+
+	l.Trim(start, stop)
+	delIfEmptyList(d, args[0], l)
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+func LLen(d *db.DB, args []string) *protcl.Message {
+	l, err := getList(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if l == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(l.Len()), nil)
+}
@@ -0,0 +1,168 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package cmds
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// number of keys reported by MEMORY BIGKEYS when COUNT is not given
+const defaultBigKeysCount = 10
+
+// Memory reports the memory used by the keys with the USAGE, STATS and BIGKEYS subcommands
+func Memory(d *db.DB, args []string) *protcl.Message {
+	sub := strings.ToLower(args[0])
+	switch sub {
+	case "usage":
+		return memoryUsage(d, args[1:])
+	case "stats":
+		if len(args) != 1 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "memory " + sub})
+		}
+
+		return memoryStats(d)
+	case "bigkeys":
+		return memoryBigKeys(d, args[1:])
+	}
+
+	return protcl.NewMessage(nil, &protcl.ErrUnknownCommand{Cmd: "memory " + args[0]})
+}
+
+// memoryUsage replies with the bytes used by a key, SAMPLES limits the elements of containers which are
+// measured and 0 measures all of them
+func memoryUsage(d *db.DB, args []string) *protcl.Message {
+	if len(args) != 1 && len(args) != 3 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "memory usage"})
+	}
+
+	samples := db.DefaultMemorySamples
+	if len(args) == 3 {
+		if strings.ToLower(args[1]) != "samples" {
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[2]})
+		}
+		samples = n
+	}
+
+	bytes, ok := d.MemoryUsage(args[0], samples)
+	if !ok {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(int(bytes)), nil)
+}
+
+// memoryStats replies with the names of the statistics followed by their values
+func memoryStats(d *db.DB) *protcl.Message {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var keys int64
+	for i := 0; i < d.Databases(); i++ {
+		target, _ := d.Select(i)
+		keys += int64(target.Size())
+	}
+	used := d.UsedMemory()
+	maxMemory, policy := d.MaxMemory()
+
+	var perKey int64
+	if keys > 0 {
+		perKey = used / keys
+	}
+
+	var percentage int64
+	if mem.HeapAlloc > 0 {
+		percentage = used * 100 / int64(mem.HeapAlloc)
+	}
+
+	stats := []struct {
+		name  string
+		value interface{}
+	}{
+		{"total.allocated", int64(mem.HeapAlloc)},
+		{"dataset.bytes", used},
+		{"dataset.percentage", percentage},
+		{"peak.dataset", d.PeakMemory()},
+		{"keys.count", keys},
+		{"keys.bytes-per-key", perKey},
+		{"maxmemory", maxMemory},
+		{"maxmemory.policy", policy},
+		{"evicted.keys", d.Evicted()},
+	}
+
+	replies := make([]protcl.Reply, 0, len(stats)*2)
+	for _, stat := range stats {
+		replies = append(replies, protcl.NewBulkStringReply(false, stat.name))
+		switch v := stat.value.(type) {
+		case int64:
+			replies = append(replies, protcl.NewIntegerReply(int(v)))
+		case string:
+			replies = append(replies, protcl.NewBulkStringReply(false, v))
+		}
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+}
+
+// memoryBigKeys replies with the keys using the most memory, largest first, each one as its name, type
+// and bytes. COUNT sets the number of keys. All keys are visited, so it should be used sparingly
+func memoryBigKeys(d *db.DB, args []string) *protcl.Message {
+	if len(args) != 0 && len(args) != 2 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "memory bigkeys"})
+	}
+
+	count := defaultBigKeysCount
+	if len(args) == 2 {
+		if strings.ToLower(args[0]) != "count" {
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[1]})
+		}
+		count = n
+	}
+
+	keys := d.BigKeys(count)
+	replies := make([]protcl.Reply, len(keys))
+	for i, key := range keys {
+		replies[i] = protcl.NewArrayReply(false, []protcl.Reply{
+			protcl.NewBulkStringReply(false, key.Key),
+			protcl.NewBulkStringReply(false, key.Type.String()),
+			protcl.NewIntegerReply(int(key.Bytes)),
+		})
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+}
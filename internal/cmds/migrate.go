@@ -0,0 +1,242 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/persist"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// Dump serializes the value at key, which can be recreated with RESTORE
+func Dump(d *db.DB, args []string) *protcl.Message {
+	node, err := d.Get(args[0])
+	if err != nil {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	payload, err := persist.Dump(node)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, string(payload)), nil)
+}
+
+// Restore creates key from a value serialized by DUMP which expires after ttl milliseconds, 0 means no
+// expiration. REPLACE overwrites an existing key and ABSTTL makes ttl a unix time in milliseconds
+func Restore(d *db.DB, args []string) *protcl.Message {
+	key := args[0]
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[1]})
+	}
+
+	if ttl < 0 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: errors.New("invalid TTL value, must be >= 0")})
+	}
+
+	replace, absTTL := false, false
+	for _, opt := range args[3:] {
+		switch strings.ToLower(opt) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		default:
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+	}
+
+	if !replace && d.Exists(key) == 1 {
+		return protcl.NewMessage(nil, &protcl.ErrBusyKey{})
+	}
+
+	expiresAt := int64(-1)
+	if ttl > 0 && absTTL {
+		expiresAt = ttl
+	} else if ttl > 0 {
+		expiresAt = db.Now() + ttl
+	}
+
+	node, err := persist.Restore([]byte(args[2]), expiresAt)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	d.Set(key, node)
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// Migrate transfers keys with their remaining time to live to another node with RESTORE-ASKING and deletes
+// them unless COPY is given. The database stays locked meanwhile, so the transfer is atomic for clients.
+// Deletions are propagated by the command itself since MIGRATE can't be replayed
+func Migrate(d *db.DB, args []string) *protcl.Message {
+	addr := net.JoinHostPort(args[0], args[1])
+
+	index, err := strconv.Atoi(args[3])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[3]})
+	}
+
+	if index < 0 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: db.ErrInvalidDBIndex})
+	}
+
+	timeout, err := strconv.Atoi(args[4])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[4]})
+	}
+
+	if timeout <= 0 {
+		timeout = 1000
+	}
+
+	keys, copyKeys, replace := []string{args[2]}, false, false
+loop:
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "keys":
+			if args[2] != "" {
+				return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: errors.New("when using MIGRATE KEYS option, the key argument must be set to the empty string")})
+			}
+
+			keys = args[i+1:]
+			break loop
+		default:
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+	}
+
+	var found []string
+	var nodes []*db.DataNode
+	for _, key := range keys {
+		if node, err := d.Get(key); err == nil {
+			found = append(found, key)
+			nodes = append(nodes, node)
+		}
+	}
+
+	if len(found) == 0 {
+		return protcl.NewMessage(protcl.NewSimpleStringReply("NOKEY"), nil)
+	}
+
+	migrated, err := migrate(addr, time.Duration(timeout)*time.Millisecond, index, found, nodes, replace)
+	if !copyKeys && len(migrated) > 0 {
+		d.Del(migrated)
+		d.Propagate("del", migrated)
+	}
+
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// migrate restores nodes at keys in the database at index of the node at addr and returns the keys
+// restored successfully
+func migrate(addr string, timeout time.Duration, index int, keys []string, nodes []*db.DataNode, replace bool) ([]string, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("IOERR error or timeout connecting to the client: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// the database is selected before anything is restored, so that no key lands in another database
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+	protcl.WriteCommand(w, "select", []string{strconv.Itoa(index)})
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("IOERR error or timeout writing to target instance: %s", err)
+	}
+
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("IOERR error or timeout reading from target instance: %s", err)
+	}
+
+	if strings.HasPrefix(reply, "-") {
+		return nil, fmt.Errorf("target instance replied with error: %s", strings.TrimSpace(reply[1:]))
+	}
+
+	now := db.Now()
+	for i, key := range keys {
+		payload, err := persist.Dump(nodes[i])
+		if err != nil {
+			return nil, err
+		}
+
+		ttl := int64(0)
+		if nodes[i].ExpiresAt != -1 {
+			ttl = nodes[i].ExpiresAt - now
+			if ttl < 1 {
+				ttl = 1
+			}
+		}
+
+		restoreArgs := []string{key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			restoreArgs = append(restoreArgs, "replace")
+		}
+		protcl.WriteCommand(w, "restore-asking", restoreArgs)
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("IOERR error or timeout writing to target instance: %s", err)
+	}
+
+	var migrated []string
+	var replyErr error
+	for _, key := range keys {
+		reply, err := r.ReadString('\n')
+		if err != nil {
+			return migrated, fmt.Errorf("IOERR error or timeout reading from target instance: %s", err)
+		}
+
+		if strings.HasPrefix(reply, "-") {
+			replyErr = fmt.Errorf("target instance replied with error: %s", strings.TrimSpace(reply[1:]))
+			continue
+		}
+
+		migrated = append(migrated, key)
+	}
+
+	return migrated, replyErr
+}
@@ -0,0 +1,70 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/internal/pubsub"
+)
+
+func Publish(d *db.DB, args []string) *protcl.Message {
+	receivers := pubsub.Default.Publish(args[0], args[1])
+	return protcl.NewMessage(protcl.NewIntegerReply(receivers), nil)
+}
+
+// PubSub introspects the state of the pub/sub subsystem with CHANNELS, NUMSUB and NUMPAT subcommands
+func PubSub(d *db.DB, args []string) *protcl.Message {
+	switch sub := strings.ToLower(args[0]); sub {
+	case "channels":
+		if len(args) > 2 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "pubsub " + sub})
+		}
+
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+
+		return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies(pubsub.Default.Channels(pattern))), nil)
+	case "numsub":
+		replies := make([]protcl.Reply, 0, (len(args)-1)*2)
+		for _, channel := range args[1:] {
+			replies = append(replies, protcl.NewBulkStringReply(false, channel), protcl.NewIntegerReply(pubsub.Default.NumSub(channel)))
+		}
+
+		return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+	case "numpat":
+		if len(args) != 1 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "pubsub " + sub})
+		}
+
+		return protcl.NewMessage(protcl.NewIntegerReply(pubsub.Default.NumPat()), nil)
+	}
+
+	return protcl.NewMessage(nil, &protcl.ErrUnknownCommand{Cmd: "pubsub " + args[0]})
+}
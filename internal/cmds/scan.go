@@ -0,0 +1,221 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+// number of elements SCAN, HSCAN, SSCAN and ZSCAN try to return when COUNT is not given
+const defaultScanCount = 10
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// scanOptions are the arguments of SCAN, HSCAN, SSCAN and ZSCAN following the cursor
+type scanOptions struct {
+	pattern string
+	count   int
+	t       db.DataType // 0 means any type
+}
+
+// match reports whether s matches the MATCH pattern, which matches anything when it is not given
+func (opts *scanOptions) match(s string) bool {
+	return opts.pattern == "" || util.GlobMatch(opts.pattern, s)
+}
+
+// parseScanArgs parses the cursor and the options in args, TYPE is accepted only if withType is set
+func parseScanArgs(args []string, withType bool) (uint64, *scanOptions, error) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, nil, &protcl.ErrGeneric{Err: ErrInvalidCursor}
+	}
+
+	opts := &scanOptions{count: defaultScanCount}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return 0, nil, &protcl.ErrSyntax{}
+		}
+
+		val := args[i+1]
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "match":
+			opts.pattern = val
+		case opt == "count":
+			if opts.count, err = strconv.Atoi(val); err != nil || opts.count <= 0 {
+				return 0, nil, &protcl.ErrCastFailedToInt{Val: val}
+			}
+		case opt == "type" && withType:
+			t, ok := db.ParseDataType(strings.ToLower(val))
+			if !ok {
+				return 0, nil, &protcl.ErrGeneric{Err: fmt.Errorf("unknown type name %s", val)}
+			}
+			opts.t = t
+		default:
+			return 0, nil, &protcl.ErrSyntax{}
+		}
+	}
+
+	return cursor, opts, nil
+}
+
+// scanReply replies with the cursor to continue the iteration and the elements found
+func scanReply(cursor uint64, elems []string) *protcl.Message {
+	return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewBulkStringReply(false, strconv.FormatUint(cursor, 10)),
+		protcl.NewArrayReply(false, stringsToReplies(elems)),
+	}), nil)
+}
+
+// Keys replies with all the keys matching the glob style pattern, all keys are visited at once so SCAN
+// should be preferred on large databases
+func Keys(d *db.DB, args []string) *protcl.Message {
+	keys := d.Keys()
+	if args[0] != "*" {
+		matched := keys[:0]
+		for _, key := range keys {
+			if util.GlobMatch(args[0], key) {
+				matched = append(matched, key)
+			}
+		}
+		keys = matched
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies(keys)), nil)
+}
+
+// Scan iterates the key space incrementally with a cursor, MATCH, COUNT and TYPE filter the keys
+func Scan(d *db.DB, args []string) *protcl.Message {
+	cursor, opts, err := parseScanArgs(args, true)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	keys, cursor := d.Scan(cursor, opts.count, opts.t)
+	matched := keys[:0]
+	for _, key := range keys {
+		if opts.match(key) {
+			matched = append(matched, key)
+		}
+	}
+
+	return scanReply(cursor, matched)
+}
+
+func RandomKey(d *db.DB, args []string) *protcl.Message {
+	key, ok := d.RandomKey()
+	if !ok {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, key), nil)
+}
+
+// HScan iterates the fields of a hash incrementally with a cursor, replying with fields followed by
+// their values
+func HScan(d *db.DB, args []string) *protcl.Message {
+	m, err := getHashMap(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	cursor, opts, err := parseScanArgs(args[1:], false)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if m == nil {
+		return scanReply(0, nil)
+	}
+
+	pairs, cursor := m.Scan(cursor, opts.count)
+	matched := pairs[:0]
+	for i := 0; i < len(pairs); i += 2 {
+		if opts.match(pairs[i]) {
+			matched = append(matched, pairs[i], pairs[i+1])
+		}
+	}
+
+	return scanReply(cursor, matched)
+}
+
+// SScan iterates the elements of a set incrementally with a cursor
+func SScan(d *db.DB, args []string) *protcl.Message {
+	s, err := getSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	cursor, opts, err := parseScanArgs(args[1:], false)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if s == nil {
+		return scanReply(0, nil)
+	}
+
+	elems, cursor := s.Scan(cursor, opts.count)
+	matched := elems[:0]
+	for _, elem := range elems {
+		if opts.match(elem) {
+			matched = append(matched, elem)
+		}
+	}
+
+	return scanReply(cursor, matched)
+}
+
+// ZScan iterates the members of a sorted set incrementally with a cursor, each member is followed by its score
+func ZScan(d *db.DB, args []string) *protcl.Message {
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	cursor, opts, err := parseScanArgs(args[1:], false)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return scanReply(0, nil)
+	}
+
+	entries, cursor := z.Scan(cursor, opts.count)
+	var matched []string
+	for _, e := range entries {
+		if opts.match(e.Member) {
+			matched = append(matched, e.Member, formatScore(e.Score))
+		}
+	}
+
+	return scanReply(cursor, matched)
+}
@@ -26,6 +26,7 @@ package cmds
 
 import (
 	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/persist"
 	"github.com/kasvith/kache/internal/protcl"
 )
 
@@ -36,3 +37,31 @@ func Ping(d *db.DB, args []string) *protcl.Message {
 
 	return protcl.NewMessage(protcl.NewBulkStringReply(false, args[0]), nil)
 }
+
+func Save(d *db.DB, args []string) *protcl.Message {
+	if err := persist.Save(d); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+func BgSave(d *db.DB, args []string) *protcl.Message {
+	if err := persist.BackgroundSave(d); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("Background saving started"), nil)
+}
+
+func LastSave(d *db.DB, args []string) *protcl.Message {
+	return protcl.NewMessage(protcl.NewIntegerReply(int(persist.LastSave())), nil)
+}
+
+func BgRewriteAOF(d *db.DB, args []string) *protcl.Message {
+	if err := persist.RewriteAppendOnly(d); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("Background append only file rewriting started"), nil)
+}
@@ -0,0 +1,240 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/types/set"
+)
+
+// getSet returns the set stored at key, a nil set is returned when the key does not exist
+func getSet(d *db.DB, key string) (*set.Set, error) {
+	val, err := d.Get(key)
+	if err != nil {
+		return nil, nil
+	}
+
+	if val.Type != db.TypeSet {
+		return nil, &protcl.ErrWrongType{}
+	}
+
+	return val.Value.(*set.Set), nil
+}
+
+// getOrCreateSet returns the set stored at key, a new set is stored at key if it does not exist
+func getOrCreateSet(d *db.DB, key string) (*set.Set, error) {
+	s, err := getSet(d, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		s = set.New()
+		d.Set(key, db.NewDataNode(db.TypeSet, -1, s))
+	}
+
+	return s, nil
+}
+
+// getSets returns the sets stored at keys, a missing key is treated as an empty set
+func getSets(d *db.DB, keys []string) ([]set.Set, error) {
+	sets := make([]set.Set, len(keys))
+
+	for i, key := range keys {
+		s, err := getSet(d, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if s == nil {
+			s = set.New()
+		}
+
+		sets[i] = *s
+	}
+
+	return sets, nil
+}
+
+// delIfEmptySet removes the key when the set does not hold any element
+func delIfEmptySet(d *db.DB, key string, s *set.Set) {
+	if s.Card() == 0 {
+		d.Del([]string{key})
+	}
+}
+
+func SAdd(d *db.DB, args []string) *protcl.Message {
+	s, err := getOrCreateSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(s.Add(args[1:])), nil)
+}
+
+func SRem(d *db.DB, args []string) *protcl.Message {
+	s, err := getSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if s == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	deleted := s.Delete(args[1:])
+	delIfEmptySet(d, args[0], s)
+
+	return protcl.NewMessage(protcl.NewIntegerReply(deleted), nil)
+}
+
+func SCard(d *db.DB, args []string) *protcl.Message {
+	s, err := getSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if s == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(s.Card()), nil)
+}
+
+func SMembers(d *db.DB, args []string) *protcl.Message {
+	s, err := getSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if s == nil {
+		return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{}), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies(s.Elems())), nil)
+}
+
+func SIsMember(d *db.DB, args []string) *protcl.Message {
+	s, err := getSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if s == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(s.Exists(args[1])), nil)
+}
+
+func SMove(d *db.DB, args []string) *protcl.Message {
+	src, err := getSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	dest, err := getSet(d, args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	member := args[2]
+	if src == nil || src.Exists(member) == 0 {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	// moving to the same set is a no-op
+	if src == dest {
+		return protcl.NewMessage(protcl.NewIntegerReply(1), nil)
+	}
+
+	if dest == nil {
+		dest, _ = getOrCreateSet(d, args[1])
+	}
+
+	moved := set.Move(member, src, dest)
+	delIfEmptySet(d, args[0], src)
+
+	return protcl.NewMessage(protcl.NewIntegerReply(moved), nil)
+}
+
+func SDiff(d *db.DB, args []string) *protcl.Message {
+	return setOperation(d, args, diff)
+}
+
+func SInter(d *db.DB, args []string) *protcl.Message {
+	return setOperation(d, args, set.IntersectionS)
+}
+
+func SUnion(d *db.DB, args []string) *protcl.Message {
+	return setOperation(d, args, set.UnionS)
+}
+
+func SDiffStore(d *db.DB, args []string) *protcl.Message {
+	return setOperationStore(d, args[0], args[1:], diff)
+}
+
+func SInterStore(d *db.DB, args []string) *protcl.Message {
+	return setOperationStore(d, args[0], args[1:], set.IntersectionS)
+}
+
+func SUnionStore(d *db.DB, args []string) *protcl.Message {
+	return setOperationStore(d, args[0], args[1:], set.UnionS)
+}
+
+// diff returns the members of the first set which are not in the rest of the sets
+func diff(sets []set.Set) *set.Set {
+	return sets[0].DiffS(sets[1:])
+}
+
+// setOperation replies with the members of the set computed by op over sets stored at keys
+func setOperation(d *db.DB, keys []string, op func([]set.Set) *set.Set) *protcl.Message {
+	sets, err := getSets(d, keys)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, stringsToReplies(op(sets).Elems())), nil)
+}
+
+// setOperationStore stores the set computed by op over sets stored at keys in dest,
+// an empty result removes dest
+func setOperationStore(d *db.DB, dest string, keys []string, op func([]set.Set) *set.Set) *protcl.Message {
+	sets, err := getSets(d, keys)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	res := op(sets)
+	if res.Card() == 0 {
+		d.Del([]string{dest})
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	d.Set(dest, db.NewDataNode(db.TypeSet, -1, res))
+
+	return protcl.NewMessage(protcl.NewIntegerReply(res.Card()), nil)
+}
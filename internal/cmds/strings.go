@@ -26,6 +26,7 @@ package cmds
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/protcl"
@@ -45,13 +46,117 @@ func Get(d *db.DB, args []string) *protcl.Message {
 	return protcl.NewMessage(protcl.NewBulkStringReply(false, util.ToString(val.Value)), nil)
 }
 
+// setOptions holds the options given to SET after the key and the value
+type setOptions struct {
+	nx, xx, keepTTL, get bool
+	expiresAt            int64 // -1 when no expiration is given
+}
+
+// parseSetOptions parses EX/PX/EXAT/PXAT/NX/XX/KEEPTTL/GET options of SET
+func parseSetOptions(args []string) (*setOptions, error) {
+	opts := &setOptions{expiresAt: -1}
+	hasExpire := false
+
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "nx":
+			opts.nx = true
+		case "xx":
+			opts.xx = true
+		case "get":
+			opts.get = true
+		case "keepttl":
+			opts.keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if hasExpire || i+1 >= len(args) {
+				return nil, &protcl.ErrSyntax{}
+			}
+			hasExpire = true
+			i++
+
+			t, err := parseInt(args[i])
+			if err != nil {
+				return nil, err
+			}
+
+			if t <= 0 {
+				return nil, &protcl.ErrInvalidExpireTime{Cmd: "set"}
+			}
+
+			ok := true
+			switch opt {
+			case "ex":
+				opts.expiresAt, ok = expireTime(db.Now(), t, 1000)
+			case "px":
+				opts.expiresAt, ok = expireTime(db.Now(), t, 1)
+			case "exat":
+				opts.expiresAt, ok = expireTime(0, t, 1000)
+			case "pxat":
+				opts.expiresAt = int64(t)
+			}
+
+			if !ok {
+				return nil, &protcl.ErrInvalidExpireTime{Cmd: "set"}
+			}
+		default:
+			return nil, &protcl.ErrSyntax{}
+		}
+	}
+
+	if (opts.nx && opts.xx) || (opts.keepTTL && hasExpire) {
+		return nil, &protcl.ErrSyntax{}
+	}
+
+	return opts, nil
+}
+
 func Set(d *db.DB, args []string) *protcl.Message {
 	key := args[0]
 	val := args[1]
 
-	d.Set(key, db.NewDataNode(db.TypeString, -1, val))
+	opts, err := parseSetOptions(args[2:])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	old, err := d.Get(key)
+	if err != nil {
+		old = nil
+	}
+
+	if opts.get && old != nil && old.Type != db.TypeString {
+		return protcl.NewMessage(nil, &protcl.ErrWrongType{})
+	}
+
+	// reply with the old value for GET and OK otherwise, a nil reply when the value was not set
+	reply := func(set bool) *protcl.Message {
+		if opts.get {
+			if old == nil {
+				return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+			}
+
+			return protcl.NewMessage(protcl.NewBulkStringReply(false, util.ToString(old.Value)), nil)
+		}
+
+		if !set {
+			return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+		}
+
+		return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+	}
+
+	if (opts.nx && old != nil) || (opts.xx && old == nil) {
+		return reply(false)
+	}
+
+	expiresAt := opts.expiresAt
+	if opts.keepTTL && old != nil {
+		expiresAt = old.ExpiresAt
+	}
+
+	d.Set(key, db.NewDataNode(db.TypeString, expiresAt, val))
 
-	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+	return reply(true)
 }
 
 func Incr(d *db.DB, args []string) *protcl.Message {
@@ -87,7 +192,8 @@ func accumulateBy(d *db.DB, key string, v int, incr bool) *protcl.Message {
 		n = i - v
 	}
 
-	d.Set(key, db.NewDataNode(db.TypeString, -1, strconv.Itoa(n)))
+	// keep the expiration of the key as it is
+	d.Set(key, db.NewDataNode(db.TypeString, val.ExpiresAt, strconv.Itoa(n)))
 
 	return protcl.NewMessage(protcl.NewIntegerReply(n), nil)
 }
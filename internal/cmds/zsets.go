@@ -0,0 +1,897 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmds
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+var (
+	ErrZAddFlags       = errors.New("XX, NX, GT and LT options at the same time are not compatible")
+	ErrIncrPair        = errors.New("INCR option supports a single increment-element pair")
+	ErrInvalidMinMax   = errors.New("min or max is not a float")
+	ErrInvalidLexRange = errors.New("min or max not valid string range item")
+	ErrNoInputKeys     = errors.New("at least 1 input key is needed")
+	ErrWeightNotFloat  = errors.New("weight value is not a float")
+	ErrNotPositive     = errors.New("value is out of range, must be positive")
+)
+
+// getZSet returns the sorted set stored at key, a nil sorted set is returned when the key does not exist
+func getZSet(d *db.DB, key string) (*zset.ZSet, error) {
+	val, err := d.Get(key)
+	if err != nil {
+		return nil, nil
+	}
+
+	if val.Type != db.TypeZSet {
+		return nil, &protcl.ErrWrongType{}
+	}
+
+	return val.Value.(*zset.ZSet), nil
+}
+
+// getOrCreateZSet returns the sorted set stored at key, a new sorted set is stored at key if it does not exist
+func getOrCreateZSet(d *db.DB, key string) (*zset.ZSet, error) {
+	z, err := getZSet(d, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if z == nil {
+		z = zset.New()
+		d.Set(key, db.NewDataNode(db.TypeZSet, -1, z))
+	}
+
+	return z, nil
+}
+
+// delIfEmptyZSet removes the key when the sorted set does not hold any member
+func delIfEmptyZSet(d *db.DB, key string, z *zset.ZSet) {
+	if z.Card() == 0 {
+		d.Del([]string{key})
+	}
+}
+
+// parseScore parses a score, infinities are accepted as +inf and -inf
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, &protcl.ErrCastFailedToFloat{Val: s}
+	}
+
+	return score, nil
+}
+
+// formatScore formats a score the way it is replied to clients
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// entriesToReplies returns the members of entries, each one followed by its score if withScores is set
+func entriesToReplies(entries []zset.Entry, withScores bool) []protcl.Reply {
+	replies := make([]protcl.Reply, 0, len(entries))
+	for _, e := range entries {
+		replies = append(replies, protcl.NewBulkStringReply(false, e.Member))
+		if withScores {
+			replies = append(replies, protcl.NewBulkStringReply(false, formatScore(e.Score)))
+		}
+	}
+
+	return replies
+}
+
+// zaddOptions are the flags of ZADD preceding the scores and members
+type zaddOptions struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// parseZAddOptions parses the flags at the start of args and returns the position of the first score
+func parseZAddOptions(args []string) (*zaddOptions, int, error) {
+	opts := &zaddOptions{}
+	i := 0
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			opts.nx = true
+		case "xx":
+			opts.xx = true
+		case "gt":
+			opts.gt = true
+		case "lt":
+			opts.lt = true
+		case "ch":
+			opts.ch = true
+		case "incr":
+			opts.incr = true
+		default:
+			if (opts.nx && (opts.xx || opts.gt || opts.lt)) || (opts.gt && opts.lt) {
+				return nil, 0, &protcl.ErrGeneric{Err: ErrZAddFlags}
+			}
+
+			return opts, i, nil
+		}
+	}
+
+	return nil, 0, &protcl.ErrWrongNumberOfArgs{Cmd: "zadd"}
+}
+
+// ZAdd adds members with their score, NX only adds new members, XX only updates existing ones, GT and LT
+// only update a score if the new one is greater or less. CH replies the number of members added or
+// updated and INCR increments the score of a single member like ZINCRBY
+func ZAdd(d *db.DB, args []string) *protcl.Message {
+	opts, first, err := parseZAddOptions(args[1:])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	pairs := args[1+first:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+	}
+
+	if opts.incr && len(pairs) != 2 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrIncrPair})
+	}
+
+	// every score is parsed before anything is added
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		if scores[i], err = parseScore(pairs[2*i]); err != nil {
+			return protcl.NewMessage(nil, err)
+		}
+	}
+
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	added, changed := 0, 0
+	for i, score := range scores {
+		member := pairs[2*i+1]
+
+		var current float64
+		exists := false
+		if z != nil {
+			current, exists = z.Score(member)
+		}
+
+		if (opts.nx && exists) || (opts.xx && !exists) {
+			if opts.incr {
+				return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+			}
+			continue
+		}
+
+		if opts.incr {
+			score += current
+			if math.IsNaN(score) {
+				return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: zset.ErrNaN})
+			}
+		}
+
+		if exists && ((opts.gt && score <= current) || (opts.lt && score >= current)) {
+			if opts.incr {
+				return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+			}
+			continue
+		}
+
+		if z == nil {
+			z, _ = getOrCreateZSet(d, args[0])
+		}
+
+		if z.Add(member, score) == 1 {
+			added++
+		} else if score != current {
+			changed++
+		}
+		d.SignalReady(args[0])
+
+		if opts.incr {
+			return protcl.NewMessage(protcl.NewBulkStringReply(false, formatScore(score)), nil)
+		}
+	}
+
+	if opts.ch {
+		return protcl.NewMessage(protcl.NewIntegerReply(added+changed), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(added), nil)
+}
+
+// ZIncrBy increments the score of a member and replies with the new score
+func ZIncrBy(d *db.DB, args []string) *protcl.Message {
+	delta, err := parseScore(args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	z, err := getOrCreateZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	score, err := z.IncrBy(args[2], delta)
+	if err != nil {
+		delIfEmptyZSet(d, args[0], z)
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+	d.SignalReady(args[0])
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, formatScore(score)), nil)
+}
+
+func ZRem(d *db.DB, args []string) *protcl.Message {
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	removed := z.Remove(args[1:])
+	delIfEmptyZSet(d, args[0], z)
+
+	return protcl.NewMessage(protcl.NewIntegerReply(removed), nil)
+}
+
+func ZCard(d *db.DB, args []string) *protcl.Message {
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(z.Card()), nil)
+}
+
+// scoreReply replies with the score of member, nil if it is not a member
+func scoreReply(z *zset.ZSet, member string) protcl.Reply {
+	if z == nil {
+		return protcl.NewBulkStringReply(true, "")
+	}
+
+	score, ok := z.Score(member)
+	if !ok {
+		return protcl.NewBulkStringReply(true, "")
+	}
+
+	return protcl.NewBulkStringReply(false, formatScore(score))
+}
+
+func ZScore(d *db.DB, args []string) *protcl.Message {
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return protcl.NewMessage(scoreReply(z, args[1]), nil)
+}
+
+func ZMScore(d *db.DB, args []string) *protcl.Message {
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	replies := make([]protcl.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		replies[i] = scoreReply(z, member)
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, replies), nil)
+}
+
+func zrank(d *db.DB, args []string, reverse bool) *protcl.Message {
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	rank, ok := z.Rank(args[1], reverse)
+	if !ok {
+		return protcl.NewMessage(protcl.NewBulkStringReply(true, ""), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(rank), nil)
+}
+
+// ZRank replies with the position of a member ordered by ascending scores
+func ZRank(d *db.DB, args []string) *protcl.Message {
+	return zrank(d, args, false)
+}
+
+// ZRevRank replies with the position of a member ordered by descending scores
+func ZRevRank(d *db.DB, args []string) *protcl.Message {
+	return zrank(d, args, true)
+}
+
+// parseScoreRange parses the bounds of a score range, a bound prefixed by ( is exclusive
+func parseScoreRange(min, max string) (zset.ScoreRange, error) {
+	var r zset.ScoreRange
+	var err error
+
+	if r.Min, r.MinExclusive, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+
+	r.Max, r.MaxExclusive, err = parseScoreBound(max)
+	return r, err
+}
+
+func parseScoreBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, &protcl.ErrGeneric{Err: ErrInvalidMinMax}
+	}
+
+	return score, exclusive, nil
+}
+
+// parseLexRange parses the bounds of a lexicographical range, a bound is prefixed by ( when it is
+// exclusive and by [ when it is inclusive. - and + are the lowest and the highest bounds, false is
+// returned when nothing can be in the range
+func parseLexRange(min, max string) (zset.LexRange, bool, error) {
+	var r zset.LexRange
+	if !validLexBound(min) || !validLexBound(max) {
+		return r, false, &protcl.ErrGeneric{Err: ErrInvalidLexRange}
+	}
+
+	if min == "+" || max == "-" {
+		return r, false, nil
+	}
+
+	r.MinUnbounded, r.MaxUnbounded = min == "-", max == "+"
+	r.Min, r.MinExclusive = min[1:], min[0] == '('
+	r.Max, r.MaxExclusive = max[1:], max[0] == '('
+
+	return r, true, nil
+}
+
+func validLexBound(s string) bool {
+	return s == "-" || s == "+" || strings.HasPrefix(s, "(") || strings.HasPrefix(s, "[")
+}
+
+// rangeSpec describes the members selected by ZRANGE and its variants
+type rangeSpec struct {
+	by         string // rank, score or lex
+	rev        bool
+	limit      bool
+	offset     int
+	count      int
+	withScores bool
+}
+
+// parseRangeOptions parses the options following the bounds of a range, BYSCORE, BYLEX and REV are
+// accepted only if withBy is set
+func parseRangeOptions(args []string, spec *rangeSpec, withBy bool) error {
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "byscore" && withBy:
+			spec.by = "score"
+		case opt == "bylex" && withBy:
+			spec.by = "lex"
+		case opt == "rev" && withBy:
+			spec.rev = true
+		case opt == "withscores":
+			spec.withScores = true
+		case opt == "limit" && i+2 < len(args):
+			offset, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return &protcl.ErrCastFailedToInt{Val: args[i+1]}
+			}
+
+			count, err := strconv.Atoi(args[i+2])
+			if err != nil {
+				return &protcl.ErrCastFailedToInt{Val: args[i+2]}
+			}
+
+			spec.limit, spec.offset, spec.count = true, offset, count
+			i += 2
+		default:
+			return &protcl.ErrSyntax{}
+		}
+	}
+
+	// LIMIT only applies to score and lex ranges, lex ranges have no scores
+	if (spec.limit && spec.by == "rank") || (spec.withScores && spec.by == "lex") {
+		return &protcl.ErrSyntax{}
+	}
+
+	return nil
+}
+
+// zrange replies with the members of the sorted set at key between start and stop as described by
+// spec. For reversed score and lex ranges start is the maximum and stop the minimum
+func zrange(d *db.DB, key, start, stop string, spec *rangeSpec) *protcl.Message {
+	z, err := getZSet(d, key)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if !spec.limit {
+		spec.count = -1
+	}
+
+	var entries []zset.Entry
+	switch spec.by {
+	case "rank":
+		first, err := strconv.Atoi(start)
+		if err != nil {
+			return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: start})
+		}
+
+		last, err := strconv.Atoi(stop)
+		if err != nil {
+			return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: stop})
+		}
+
+		if z != nil {
+			entries = z.RangeByRank(first, last, spec.rev)
+		}
+	case "score":
+		if spec.rev {
+			start, stop = stop, start
+		}
+
+		r, err := parseScoreRange(start, stop)
+		if err != nil {
+			return protcl.NewMessage(nil, err)
+		}
+
+		if z != nil {
+			entries = z.Range(r, spec.rev, spec.offset, spec.count)
+		}
+	case "lex":
+		if spec.rev {
+			start, stop = stop, start
+		}
+
+		r, ok, err := parseLexRange(start, stop)
+		if err != nil {
+			return protcl.NewMessage(nil, err)
+		}
+
+		if z != nil && ok {
+			entries = z.Range(r, spec.rev, spec.offset, spec.count)
+		}
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, entriesToReplies(entries, spec.withScores)), nil)
+}
+
+// ZRange replies with the members of a range of ranks, scores with BYSCORE or members with BYLEX.
+// REV reverses the order and LIMIT pages score and lex ranges
+func ZRange(d *db.DB, args []string) *protcl.Message {
+	spec := &rangeSpec{by: "rank"}
+	if err := parseRangeOptions(args[3:], spec, true); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return zrange(d, args[0], args[1], args[2], spec)
+}
+
+func ZRevRange(d *db.DB, args []string) *protcl.Message {
+	spec := &rangeSpec{by: "rank", rev: true}
+	if err := parseRangeOptions(args[3:], spec, false); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return zrange(d, args[0], args[1], args[2], spec)
+}
+
+func ZRangeByScore(d *db.DB, args []string) *protcl.Message {
+	spec := &rangeSpec{by: "score"}
+	if err := parseRangeOptions(args[3:], spec, false); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return zrange(d, args[0], args[1], args[2], spec)
+}
+
+func ZRevRangeByScore(d *db.DB, args []string) *protcl.Message {
+	spec := &rangeSpec{by: "score", rev: true}
+	if err := parseRangeOptions(args[3:], spec, false); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return zrange(d, args[0], args[1], args[2], spec)
+}
+
+func ZRangeByLex(d *db.DB, args []string) *protcl.Message {
+	spec := &rangeSpec{by: "lex"}
+	if err := parseRangeOptions(args[3:], spec, false); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return zrange(d, args[0], args[1], args[2], spec)
+}
+
+func ZRevRangeByLex(d *db.DB, args []string) *protcl.Message {
+	spec := &rangeSpec{by: "lex", rev: true}
+	if err := parseRangeOptions(args[3:], spec, false); err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return zrange(d, args[0], args[1], args[2], spec)
+}
+
+// ZCount replies with the number of members with a score between min and max
+func ZCount(d *db.DB, args []string) *protcl.Message {
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(z.Count(r)), nil)
+}
+
+// ZLexCount replies with the number of members between min and max in lexicographical order
+func ZLexCount(d *db.DB, args []string) *protcl.Message {
+	r, ok, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil || !ok {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(z.Count(r)), nil)
+}
+
+func ZRemRangeByRank(d *db.DB, args []string) *protcl.Message {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[1]})
+	}
+
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[2]})
+	}
+
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	removed := z.RemoveRangeByRank(start, stop)
+	delIfEmptyZSet(d, args[0], z)
+
+	return protcl.NewMessage(protcl.NewIntegerReply(removed), nil)
+}
+
+func ZRemRangeByScore(d *db.DB, args []string) *protcl.Message {
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	return zremRange(d, args[0], r)
+}
+
+func ZRemRangeByLex(d *db.DB, args []string) *protcl.Message {
+	r, ok, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if !ok {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	return zremRange(d, args[0], r)
+}
+
+// zremRange removes the members of the sorted set at key selected by r
+func zremRange(d *db.DB, key string, r zset.Range) *protcl.Message {
+	z, err := getZSet(d, key)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	removed := z.RemoveRange(r)
+	delIfEmptyZSet(d, key, z)
+
+	return protcl.NewMessage(protcl.NewIntegerReply(removed), nil)
+}
+
+// zpop removes and replies with the members with the lowest scores, or the highest ones if max is set
+func zpop(d *db.DB, args []string, max bool) *protcl.Message {
+	count := 1
+	if len(args) > 1 {
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil {
+			return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[1]})
+		}
+
+		if count < 0 {
+			return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrNotPositive})
+		}
+	}
+
+	z, err := getZSet(d, args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	if z == nil {
+		return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{}), nil)
+	}
+
+	var entries []zset.Entry
+	if max {
+		entries = z.PopMax(count)
+	} else {
+		entries = z.PopMin(count)
+	}
+	delIfEmptyZSet(d, args[0], z)
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, entriesToReplies(entries, true)), nil)
+}
+
+func ZPopMin(d *db.DB, args []string) *protcl.Message {
+	return zpop(d, args, false)
+}
+
+func ZPopMax(d *db.DB, args []string) *protcl.Message {
+	return zpop(d, args, true)
+}
+
+// ZStoreKeys returns the keys of ZUNIONSTORE and ZINTERSTORE, the destination and the numkeys keys
+// following numkeys
+func ZStoreKeys(args []string) []string {
+	if len(args) < 2 {
+		return args
+	}
+
+	keys := []string{args[0]}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 {
+		return keys
+	}
+
+	if 2+numKeys > len(args) {
+		numKeys = len(args) - 2
+	}
+
+	return append(keys, args[2:2+numKeys]...)
+}
+
+// zstoreOptions are the arguments of ZUNIONSTORE and ZINTERSTORE following the keys
+type zstoreOptions struct {
+	weights   []float64
+	aggregate func(a, b float64) float64
+}
+
+func aggregateSum(a, b float64) float64 {
+	sum := a + b
+	// the sum of opposite infinities is 0
+	if math.IsNaN(sum) {
+		return 0
+	}
+
+	return sum
+}
+
+func parseZStoreOptions(args []string, numKeys int) (*zstoreOptions, error) {
+	opts := &zstoreOptions{weights: make([]float64, numKeys), aggregate: aggregateSum}
+	for i := range opts.weights {
+		opts.weights[i] = 1
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "weights":
+			if i+numKeys >= len(args) {
+				return nil, &protcl.ErrSyntax{}
+			}
+
+			for j := range opts.weights {
+				w, err := strconv.ParseFloat(args[i+1+j], 64)
+				if err != nil || math.IsNaN(w) {
+					return nil, &protcl.ErrGeneric{Err: ErrWeightNotFloat}
+				}
+				opts.weights[j] = w
+			}
+			i += numKeys
+		case "aggregate":
+			if i+1 >= len(args) {
+				return nil, &protcl.ErrSyntax{}
+			}
+
+			switch strings.ToLower(args[i+1]) {
+			case "sum":
+				opts.aggregate = aggregateSum
+			case "min":
+				opts.aggregate = math.Min
+			case "max":
+				opts.aggregate = math.Max
+			default:
+				return nil, &protcl.ErrSyntax{}
+			}
+			i++
+		default:
+			return nil, &protcl.ErrSyntax{}
+		}
+	}
+
+	return opts, nil
+}
+
+// getScoredEntries returns the members of the sorted set or the set at key, members of sets have a
+// score of 1. A missing key is treated as an empty sorted set
+func getScoredEntries(d *db.DB, key string) ([]zset.Entry, error) {
+	val, err := d.Get(key)
+	if err != nil {
+		return nil, nil
+	}
+
+	switch v := val.Value.(type) {
+	case *zset.ZSet:
+		return v.Entries(), nil
+	case *set.Set:
+		elems := v.Elems()
+		entries := make([]zset.Entry, len(elems))
+		for i, elem := range elems {
+			entries[i] = zset.Entry{Member: elem, Score: 1}
+		}
+		return entries, nil
+	}
+
+	return nil, &protcl.ErrWrongType{}
+}
+
+// weightedScore multiplies score by weight, the product of an infinity and 0 is 0
+func weightedScore(score, weight float64) float64 {
+	res := score * weight
+	if math.IsNaN(res) {
+		return 0
+	}
+
+	return res
+}
+
+// zstore stores the union of the sorted sets in args in the destination, or their intersection if
+// inter is set
+func zstore(d *db.DB, args []string, inter bool) *protcl.Message {
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[1]})
+	}
+
+	if numKeys < 1 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrNoInputKeys})
+	}
+
+	if 2+numKeys > len(args) {
+		return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+	}
+
+	opts, err := parseZStoreOptions(args[2+numKeys:], numKeys)
+	if err != nil {
+		return protcl.NewMessage(nil, err)
+	}
+
+	sources := make([][]zset.Entry, numKeys)
+	for i, key := range args[2 : 2+numKeys] {
+		if sources[i], err = getScoredEntries(d, key); err != nil {
+			return protcl.NewMessage(nil, err)
+		}
+	}
+
+	scores := make(map[string]float64)
+	counts := make(map[string]int)
+	for i, entries := range sources {
+		for _, e := range entries {
+			score := weightedScore(e.Score, opts.weights[i])
+			if current, ok := scores[e.Member]; ok {
+				score = opts.aggregate(current, score)
+			}
+			scores[e.Member] = score
+			counts[e.Member]++
+		}
+	}
+
+	res := zset.New()
+	for member, score := range scores {
+		if !inter || counts[member] == numKeys {
+			res.Add(member, score)
+		}
+	}
+
+	if res.Card() == 0 {
+		d.Del([]string{args[0]})
+		return protcl.NewMessage(protcl.NewIntegerReply(0), nil)
+	}
+
+	d.Set(args[0], db.NewDataNode(db.TypeZSet, -1, res))
+
+	return protcl.NewMessage(protcl.NewIntegerReply(res.Card()), nil)
+}
+
+// ZUnionStore stores the union of sorted sets, the scores of a member are multiplied by the WEIGHTS of
+// their sorted set and combined with the AGGREGATE function, SUM by default
+func ZUnionStore(d *db.DB, args []string) *protcl.Message {
+	return zstore(d, args, false)
+}
+
+// ZInterStore stores the intersection of sorted sets, scores are combined like ZUNIONSTORE
+func ZInterStore(d *db.DB, args []string) *protcl.Message {
+	return zstore(d, args, true)
+}
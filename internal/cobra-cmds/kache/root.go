@@ -27,13 +27,19 @@ package kache
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/kasvith/kache/internal/arch"
 	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persist"
 	"github.com/kasvith/kache/internal/srv"
+	"github.com/kasvith/kache/pkg/util"
 )
 
 var verbose bool
@@ -60,14 +66,44 @@ func init() {
 
 	RootCmd.Flags().StringP("host", "", "127.0.0.1", "host for running application")
 	RootCmd.Flags().IntP("port", "p", 7088, "port for running application")
-	RootCmd.Flags().IntP("maxClients", "", 10000, "max connections can be handled")
-	RootCmd.Flags().IntP("maxTimeout", "", 120, "max timeout for clients(in seconds)")
+	RootCmd.Flags().IntP("maxClients", "", 10000, "max connections can be handled, 0 means no limit")
+	RootCmd.Flags().IntP("maxTimeout", "", 120, "seconds after which an idle client is disconnected, 0 means never")
+	RootCmd.Flags().Int("databases", 16, "number of databases which can be selected with SELECT")
+	RootCmd.Flags().String("dir", ".", "directory of the persistence files")
+	RootCmd.Flags().String("dbfilename", "dump.kdb", "name of the snapshot file")
+	RootCmd.Flags().Bool("appendonly", false, "log write commands to the append only file")
+	RootCmd.Flags().String("appendfilename", "appendonly.aof", "name of the append only file")
+	RootCmd.Flags().String("appendfsync", persist.FsyncEverySec, "fsync policy of the append only file: always, everysec or no")
+	RootCmd.Flags().String("replicaof", "", "replicate the primary given as \"<host> <port>\"")
+	RootCmd.Flags().Bool("replicaReadOnly", true, "reject writes from clients while replicating")
+	RootCmd.Flags().Int("replBacklogSize", 1024*1024, "bytes of the replication stream kept for partial resynchronizations")
+	RootCmd.Flags().Bool("clusterEnabled", false, "partition the key space between the nodes of a cluster")
+	RootCmd.Flags().String("clusterConfigFile", "nodes.conf", "file the view of the cluster is saved to")
+	RootCmd.Flags().Int("clusterNodeTimeout", 15000, "milliseconds after which a node which does not answer is flagged as failed")
+	RootCmd.Flags().String("maxmemory", "0", "memory limit of the keys such as 100mb, 0 means no limit")
+	RootCmd.Flags().String("maxmemoryPolicy", db.NoEviction, "eviction policy when the memory limit is reached: noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-random or volatile-ttl")
+	RootCmd.Flags().Int("maxmemorySamples", db.DefaultMemorySamples, "keys sampled to pick the one to evict")
 
 	// Bind the flags to config
 	viper.BindPFlag("port", RootCmd.Flags().Lookup("port"))
 	viper.BindPFlag("host", RootCmd.Flags().Lookup("host"))
 	viper.BindPFlag("maxClients", RootCmd.Flags().Lookup("maxClients"))
 	viper.BindPFlag("maxTimeout", RootCmd.Flags().Lookup("maxTimeout"))
+	viper.BindPFlag("databases", RootCmd.Flags().Lookup("databases"))
+	viper.BindPFlag("dir", RootCmd.Flags().Lookup("dir"))
+	viper.BindPFlag("dbfilename", RootCmd.Flags().Lookup("dbfilename"))
+	viper.BindPFlag("appendonly", RootCmd.Flags().Lookup("appendonly"))
+	viper.BindPFlag("appendfilename", RootCmd.Flags().Lookup("appendfilename"))
+	viper.BindPFlag("appendfsync", RootCmd.Flags().Lookup("appendfsync"))
+	viper.BindPFlag("replicaof", RootCmd.Flags().Lookup("replicaof"))
+	viper.BindPFlag("replicaReadOnly", RootCmd.Flags().Lookup("replicaReadOnly"))
+	viper.BindPFlag("replBacklogSize", RootCmd.Flags().Lookup("replBacklogSize"))
+	viper.BindPFlag("clusterEnabled", RootCmd.Flags().Lookup("clusterEnabled"))
+	viper.BindPFlag("clusterConfigFile", RootCmd.Flags().Lookup("clusterConfigFile"))
+	viper.BindPFlag("clusterNodeTimeout", RootCmd.Flags().Lookup("clusterNodeTimeout"))
+	viper.BindPFlag("maxmemory", RootCmd.Flags().Lookup("maxmemory"))
+	viper.BindPFlag("maxmemoryPolicy", RootCmd.Flags().Lookup("maxmemoryPolicy"))
+	viper.BindPFlag("maxmemorySamples", RootCmd.Flags().Lookup("maxmemorySamples"))
 	viper.BindPFlag("verbose", RootCmd.PersistentFlags().Lookup("verbose"))
 	viper.BindPFlag("logging", RootCmd.PersistentFlags().Lookup("logging"))
 	viper.BindPFlag("logfile", RootCmd.PersistentFlags().Lookup("logfile"))
@@ -111,6 +147,7 @@ func initConfig() {
 func Execute() {
 	// Commands
 	RootCmd.AddCommand(VersionCmd)
+	RootCmd.AddCommand(SentinelCmd)
 
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -124,8 +161,77 @@ func runApp(cmd *cobra.Command, args []string) {
 		klogs.PrintErrorAndExit(err, 2)
 	}
 
-	appConfig.MaxMultiBlkLength = 512 * 1024 * 1024
-	config.AppConf = appConfig
+	saveRules, err := persist.ParseSaveRules(appConfig.Save)
+	if err != nil {
+		klogs.PrintErrorAndExit(err, 2)
+	}
+
+	if err := persist.ValidFsyncPolicy(appConfig.AppendFsync); err != nil {
+		klogs.PrintErrorAndExit(err, 2)
+	}
+
+	maxMemory, err := util.ParseMemory(appConfig.MaxMemory)
+	if err != nil {
+		klogs.PrintErrorAndExit(err, 2)
+	}
+
+	if err := db.ValidEvictionPolicy(appConfig.MaxMemoryPolicy); err != nil {
+		klogs.PrintErrorAndExit(err, 2)
+	}
+
+	if appConfig.MaxMultiBlkLength == 0 {
+		appConfig.MaxMultiBlkLength = 512 * 1024 * 1024
+	}
+	config.Set(appConfig)
+	config.File = viper.ConfigFileUsed()
 	klogs.InitLoggers(appConfig)
+	srv.InitDatabases(appConfig.Databases)
+
+	// restore the data before accepting any connection, the append only file is preferred since it is more up to date
+	if appConfig.AppendOnly {
+		loadAppendOnly()
+	} else {
+		loadSnapshot()
+	}
+
+	// the limit is applied once the data is loaded, so that no key is evicted while loading
+	srv.DB.SetMaxMemory(maxMemory, appConfig.MaxMemoryPolicy, appConfig.MaxMemorySamples)
+
+	go persist.RunSaveRules(srv.DB, saveRules)
+	srv.SetBuildInfo(APPVER, BuildTime, GitHash)
 	srv.Start(appConfig)
 }
+
+func loadSnapshot() {
+	start := time.Now()
+	loaded, err := persist.Load(srv.DB, persist.SnapshotPath())
+	if err != nil {
+		klogs.Logger.Fatalf("error loading snapshot from %s: %s", persist.SnapshotPath(), err)
+	}
+	klogs.Logger.Infof("loaded %d keys from %s in %s", loaded, persist.SnapshotPath(), time.Since(start))
+}
+
+func loadAppendOnly() {
+	path := persist.AppendOnlyPath()
+	start := time.Now()
+	replay := arch.NewReplay(srv.DB)
+	replayed, truncated, err := persist.LoadAppendOnly(path, func(cmd string, args []string) error {
+		return replay.Apply(strings.ToLower(cmd), args).Err
+	})
+	if err != nil {
+		klogs.Logger.Fatalf("error loading append only file from %s: %s", path, err)
+	}
+	if truncated > 0 {
+		klogs.Logger.Warnf("append only file %s was truncated, discarded the last %d bytes", path, truncated)
+	}
+	klogs.Logger.Infof("replayed %d commands from %s in %s", replayed, path, time.Since(start))
+
+	aof, err := persist.OpenAOF(path, config.Get().AppendFsync)
+	if err != nil {
+		klogs.Logger.Fatalf("error opening append only file %s: %s", path, err)
+	}
+
+	persist.AppendOnly = aof
+	srv.DB.AddFeed(aof)
+	go aof.RunFsync()
+}
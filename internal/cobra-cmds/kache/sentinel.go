@@ -0,0 +1,109 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package kache
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/sentinel"
+)
+
+var sentinelFlags struct {
+	host            string
+	port            int
+	name            string
+	monitor         string
+	quorum          int
+	sentinels       []string
+	downAfter       int
+	failoverTimeout int
+}
+
+var SentinelCmd = &cobra.Command{
+	Use:   "sentinel",
+	Short: "Monitor a primary and fail over to one of its replicas",
+	Long: `Monitor a primary and its replicas. When enough sentinels agree that the primary is down,
+the most up to date replica is promoted and the other replicas are reconfigured to replicate from it`,
+	Run: runSentinel,
+}
+
+func init() {
+	SentinelCmd.Flags().StringVar(&sentinelFlags.host, "host", "127.0.0.1", "host for running the sentinel")
+	SentinelCmd.Flags().IntVarP(&sentinelFlags.port, "port", "p", 27088, "port for running the sentinel")
+	SentinelCmd.Flags().StringVar(&sentinelFlags.name, "name", "kache", "name the primary is known by to clients")
+	SentinelCmd.Flags().StringVar(&sentinelFlags.monitor, "monitor", "127.0.0.1 7088", "primary to monitor given as \"<host> <port>\"")
+	SentinelCmd.Flags().IntVar(&sentinelFlags.quorum, "quorum", 2, "number of sentinels which must agree that the primary is down")
+	SentinelCmd.Flags().StringSliceVar(&sentinelFlags.sentinels, "sentinels", nil, "addresses of the other sentinels as host:port")
+	SentinelCmd.Flags().IntVar(&sentinelFlags.downAfter, "downAfter", 30000, "milliseconds after which an instance which does not answer is down")
+	SentinelCmd.Flags().IntVar(&sentinelFlags.failoverTimeout, "failoverTimeout", 180000, "milliseconds to wait before a failover is attempted again")
+}
+
+func runSentinel(cmd *cobra.Command, args []string) {
+	var appConfig config.AppConfig
+	if err := viper.Unmarshal(&appConfig); err != nil {
+		klogs.PrintErrorAndExit(err, 2)
+	}
+
+	appConfig.MaxMultiBlkLength = 1024 * 1024
+	config.Set(appConfig)
+	klogs.InitLoggers(appConfig)
+
+	fields := strings.Fields(sentinelFlags.monitor)
+	if len(fields) != 2 {
+		klogs.Logger.Fatalf("invalid monitor %q, expected <host> <port>", sentinelFlags.monitor)
+	}
+
+	if sentinelFlags.quorum > len(sentinelFlags.sentinels)+1 {
+		klogs.Logger.Warnf("quorum of %d can't be reached by %d sentinels, failovers are disabled",
+			sentinelFlags.quorum, len(sentinelFlags.sentinels)+1)
+	}
+
+	s := sentinel.New(sentinel.Config{
+		Name:            sentinelFlags.name,
+		Host:            fields[0],
+		Port:            fields[1],
+		Quorum:          sentinelFlags.quorum,
+		DownAfter:       time.Duration(sentinelFlags.downAfter) * time.Millisecond,
+		FailoverTimeout: time.Duration(sentinelFlags.failoverTimeout) * time.Millisecond,
+		Sentinels:       sentinelFlags.sentinels,
+	})
+
+	addr := net.JoinHostPort(sentinelFlags.host, strconv.Itoa(sentinelFlags.port))
+	if err := s.Listen(addr); err != nil {
+		klogs.Logger.Fatalf("error binding the sentinel to %s: %s", addr, err)
+	}
+
+	klogs.Logger.Infof("sentinel %s monitoring %s at %s, listening on %s", s.ID(), sentinelFlags.name,
+		net.JoinHostPort(fields[0], fields[1]), addr)
+	s.Run()
+}
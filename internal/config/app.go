@@ -24,17 +24,59 @@
 
 package config
 
+import "sync"
+
 type AppConfig struct {
-	Port              int
-	Host              string
-	Verbose           bool
-	MaxClients        int
-	MaxTimeout        int
-	Logging           bool
-	Logfile           string
-	Debug             bool
-	MaxMultiBlkLength int // in bytes
-	LogType           string
+	Port               int
+	Host               string
+	Verbose            bool
+	MaxClients         int
+	MaxTimeout         int
+	Logging            bool
+	Logfile            string
+	Debug              bool
+	MaxMultiBlkLength  int // in bytes
+	LogType            string
+	Dir                string   // directory of the persistence files
+	DbFilename         string   // name of the snapshot file
+	Save               []string // snapshot rules as "<seconds> <changes>"
+	AppendOnly         bool     // log write commands to the append only file
+	AppendFilename     string   // name of the append only file
+	AppendFsync        string   // fsync policy of the append only file, always, everysec or no
+	ReplicaOf          string   // primary to replicate as "<host> <port>", empty for a primary
+	ReplicaReadOnly    bool     // reject writes from clients while replicating
+	ReplBacklogSize    int      // bytes of the replication stream kept for partial resynchronizations
+	ClusterEnabled     bool     // partition the key space between the nodes of a cluster
+	ClusterConfigFile  string   // file the view of the cluster is saved to
+	ClusterNodeTimeout int      // milliseconds after which a node which does not answer is flagged as failed
+	MaxMemory          string   // memory limit of the keys such as 100mb, 0 means no limit
+	MaxMemoryPolicy    string   // how keys are evicted when the memory limit is reached
+	MaxMemorySamples   int      // keys sampled to pick the one to evict
+	Databases          int      // number of databases which can be selected with SELECT
 }
 
-var AppConf AppConfig
+// appConf is the live config, it is only accessed with Get and Set as some settings can be changed
+// with CONFIG SET while the server runs
+var appConf AppConfig
+
+// File is the path of the config file the config was loaded from, empty when there is none
+var File string
+
+// mux guards appConf
+var mux sync.RWMutex
+
+// Get returns the live config
+func Get() AppConfig {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	return appConf
+}
+
+// Set replaces the live config
+func Set(c AppConfig) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	appConf = c
+}
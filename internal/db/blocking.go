@@ -0,0 +1,126 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package db
+
+import "container/list"
+
+// Blocker is a client blocked until one of its keys receives data, such as a client blocked by BLPOP
+type Blocker struct {
+	db    *DB
+	keys  []string
+	elems map[string]*list.Element // position of the blocker in the queue of each key
+	serve func(d *DB, key string) bool
+}
+
+// readyKey is a key with blocked clients which received data
+type readyKey struct {
+	db  *DB
+	key string
+}
+
+// Block blocks a client on keys, serve is called with the lock held when one of the keys receives data
+// and reports whether the client was served. Clients blocked on a key are served in the order they
+// were blocked. The caller must hold the lock of db
+func (db *DB) Block(keys []string, serve func(d *DB, key string) bool) *Blocker {
+	b := &Blocker{db: db, keys: keys, elems: make(map[string]*list.Element), serve: serve}
+	for _, key := range keys {
+		if _, ok := b.elems[key]; ok {
+			continue
+		}
+
+		q, ok := db.blocked[key]
+		if !ok {
+			q = list.New()
+			db.blocked[key] = q
+		}
+		b.elems[key] = q.PushBack(b)
+	}
+
+	return b
+}
+
+// Unblock removes b from the queues of its keys, false is returned if b was already served or
+// unblocked. The caller must hold the lock of db
+func (db *DB) Unblock(b *Blocker) bool {
+	if b.elems == nil {
+		return false
+	}
+
+	for key, elem := range b.elems {
+		q := b.db.blocked[key]
+		q.Remove(elem)
+		if q.Len() == 0 {
+			delete(b.db.blocked, key)
+		}
+	}
+	b.elems = nil
+
+	return true
+}
+
+// SignalReady flags key as ready if clients are blocked on it, so that they are served by
+// ServeBlocked once the running command completes
+func (db *DB) SignalReady(key string) {
+	if _, ok := db.blocked[key]; !ok {
+		return
+	}
+
+	rk := readyKey{db: db, key: key}
+	if _, ok := db.readySet[rk]; ok {
+		return
+	}
+
+	db.readySet[rk] = struct{}{}
+	db.ready = append(db.ready, rk)
+}
+
+// ServeBlocked serves the clients blocked on the keys which received data, until no client can be
+// served anymore. Serving a client may make another key ready, such as the destination of BLMOVE.
+// The caller must hold the lock of db
+func (db *DB) ServeBlocked() {
+	for len(db.ready) > 0 {
+		rk := db.ready[0]
+		db.ready = db.ready[1:]
+		delete(db.readySet, rk)
+
+		for q := rk.db.blocked[rk.key]; q != nil && q.Len() > 0; q = rk.db.blocked[rk.key] {
+			b := q.Front().Value.(*Blocker)
+			if !b.serve(rk.db, rk.key) {
+				break
+			}
+			db.Unblock(b)
+		}
+	}
+}
+
+// BlockedKeys returns the number of keys clients are blocked on
+func (db *DB) BlockedKeys() int {
+	n := 0
+	for _, d := range db.dbs {
+		n += len(d.blocked)
+	}
+
+	return n
+}
@@ -25,13 +25,71 @@
 package db
 
 import (
+	"container/list"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/kasvith/kache/pkg/types/scan"
+)
+
+const (
+	// number of keys with an expiration sampled by each round of the active expiry cycle
+	activeExpireSampleSize = 20
+	// another round is done when more than this percentage of the sampled keys were expired
+	activeExpireRepeatPercentage = 25
+	// maximum amount of time a single active expiry cycle can take
+	activeExpireTimeLimit = 25 * time.Millisecond
 )
 
+var ErrInvalidDBIndex = errors.New("DB index is out of range")
+
+// DB is a numbered key space of kache. Its methods do not lock by themselves, callers
+// must hold the lock with Lock and Unlock so that a whole command is applied
+// atomically against the key space
 type DB struct {
-	file map[string]*DataNode
-	mux  sync.Mutex
+	id      int
+	file    map[string]*DataNode
+	index   *scan.Index            // keys of file, iterated by Scan
+	expires map[string]struct{}    // keys which have an expiration
+	watched map[string]*watchedKey // keys watched by clients for optimistic locking
+	blocked map[string]*list.List  // clients blocked on keys, in the order they were blocked
+
+	*shared
+}
+
+// shared is the state of the databases of a server. A single lock guards all of them, so that
+// commands which access more than one database are atomic
+type shared struct {
+	dbs   []*DB
+	dirty int64  // number of modifications since the last snapshot
+	feeds []Feed // receivers of the commands which modified the key space
+	mux   sync.Mutex
+
+	usedMemory      int64  // approximate bytes used by the keys and their values
+	peakMemory      int64  // highest value reached by usedMemory
+	maxMemory       int64  // memory limit in bytes, 0 means no limit
+	maxMemoryPolicy string // how keys are evicted when the limit is reached
+	memorySamples   int    // keys sampled for eviction and elements sampled to measure containers
+	evicted         int64  // number of keys evicted because of the limit
+
+	ready    []readyKey            // keys with blocked clients which received data, in order
+	readySet map[readyKey]struct{} // keys of ready
+
+	hits, misses int64 // lookups of keys by read commands which found the key or not
+}
+
+// Feed receives the commands which modified the key space in the order they were applied along
+// with the index of the database they were applied to, it is called while the lock is held
+type Feed interface {
+	Feed(db int, cmd string, args []string)
+}
+
+// watchedKey tracks modifications of a key watched by at least one client
+type watchedKey struct {
+	version uint64
+	refs    int
 }
 
 type KeyNotFoundError struct {
@@ -42,58 +100,508 @@ func (e *KeyNotFoundError) Error() string {
 	return fmt.Sprintf("%s not found", e.key)
 }
 
+// NewDB returns a server with a single database
 func NewDB() *DB {
-	return &DB{file: make(map[string]*DataNode)}
+	return NewDBs(1)[0]
 }
 
-func (db *DB) Get(key string) (*DataNode, error) {
+// NewDBs returns the n databases of a server, which share their lock, feeds and memory limit
+func NewDBs(n int) []*DB {
+	if n < 1 {
+		n = 1
+	}
+
+	s := &shared{maxMemoryPolicy: NoEviction, memorySamples: DefaultMemorySamples, readySet: make(map[readyKey]struct{})}
+	for i := 0; i < n; i++ {
+		s.dbs = append(s.dbs, &DB{
+			id:      i,
+			file:    make(map[string]*DataNode),
+			index:   scan.New(),
+			expires: make(map[string]struct{}),
+			watched: make(map[string]*watchedKey),
+			blocked: make(map[string]*list.List),
+			shared:  s,
+		})
+	}
+
+	return append([]*DB(nil), s.dbs...)
+}
+
+// ID returns the index of the database
+func (db *DB) ID() int {
+	return db.id
+}
+
+// Databases returns the number of databases of the server
+func (db *DB) Databases() int {
+	return len(db.dbs)
+}
+
+// Select returns the database of the server at index
+func (db *DB) Select(index int) (*DB, error) {
+	if index < 0 || index >= len(db.dbs) {
+		return nil, ErrInvalidDBIndex
+	}
+
+	return db.dbs[index], nil
+}
+
+// SwapDB swaps the keys of the databases at i and j, clients using one of them see the keys of the
+// other one right away. Every watched key of both databases is touched
+func (db *DB) SwapDB(i, j int) error {
+	a, err := db.Select(i)
+	if err != nil {
+		return err
+	}
+
+	b, err := db.Select(j)
+	if err != nil {
+		return err
+	}
+
+	a.file, b.file = b.file, a.file
+	a.index, b.index = b.index, a.index
+	a.expires, b.expires = b.expires, a.expires
+
+	for _, d := range []*DB{a, b} {
+		for _, w := range d.watched {
+			w.version++
+		}
+
+		// the clients blocked on the databases may be served by the keys they see now
+		for key := range d.blocked {
+			d.SignalReady(key)
+		}
+	}
+
+	return nil
+}
+
+// Move moves key to the target database along with its expiration, false is returned if key does not
+// exist or target already holds it
+func (db *DB) Move(key string, target *DB) bool {
+	node, ok := db.lookup(key)
+	if !ok || target.Exists(key) == 1 {
+		return false
+	}
+
+	db.remove(key)
+	target.Set(key, node)
+	target.Touch([]string{key})
+
+	return true
+}
+
+// Now returns the current unix time in milliseconds, which is the unit of DataNode.ExpiresAt
+func Now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Lock acquires the exclusive lock of the key space
+func (db *DB) Lock() {
 	db.mux.Lock()
-	defer db.mux.Unlock()
-	if v, ok := db.file[key]; ok {
+}
+
+// Unlock releases the lock acquired by Lock
+func (db *DB) Unlock() {
+	db.mux.Unlock()
+}
+
+// lookup finds the node of key, an expired node is removed lazily and reported as not found
+func (db *DB) lookup(key string) (*DataNode, bool) {
+	v, ok := db.file[key]
+	if !ok {
+		return nil, false
+	}
+
+	now := Now()
+	if v.Expired(now) {
+		db.remove(key)
+		return nil, false
+	}
+
+	v.access(now)
+
+	return v, true
+}
+
+func (db *DB) remove(key string) {
+	if node, ok := db.file[key]; ok {
+		db.usedMemory -= node.size
+		db.index.Remove(key)
+	}
+
+	delete(db.file, key)
+	delete(db.expires, key)
+	db.Touch([]string{key})
+}
+
+func (db *DB) Get(key string) (*DataNode, error) {
+	if v, ok := db.lookup(key); ok {
 		return v, nil
 	}
 
 	return nil, &KeyNotFoundError{key: key}
 }
 
+// Set stores val at key, the expiration of key is taken from val
 func (db *DB) Set(key string, val *DataNode) {
-	db.mux.Lock()
+	if old, ok := db.file[key]; ok {
+		db.usedMemory -= old.size
+	} else {
+		db.index.Add(key)
+	}
+
+	// a new node starts with an initial access counter, so that it is not evicted right away
+	if now := Now(); val.accessedAt == 0 {
+		val.accessedAt, val.freq = now, lfuInitFreq
+	} else {
+		val.access(now)
+	}
+	val.size = 0
 	db.file[key] = val
-	db.mux.Unlock()
+	db.resize(key, val)
+
+	if val.Type == TypeList || val.Type == TypeZSet {
+		db.SignalReady(key)
+	}
+
+	if val.ExpiresAt == -1 {
+		delete(db.expires, key)
+	} else {
+		db.expires[key] = struct{}{}
+	}
 }
 
 func (db *DB) GetIfNotSet(key string, val *DataNode) (value *DataNode, found bool) {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-
-	if v, found := db.file[key]; found {
+	if v, found := db.lookup(key); found {
 		return v, true
 	}
-	db.file[key] = val
+	db.Set(key, val)
 
 	return val, false
 }
 
 func (db *DB) Del(keys []string) int {
-	db.mux.Lock()
 	del := 0
 	for _, k := range keys {
-		if _, ok := db.file[k]; ok {
-			delete(db.file, k)
+		if _, ok := db.lookup(k); ok {
+			db.remove(k)
 			del++
 		}
 	}
-	db.mux.Unlock()
 
 	return del
 }
 
+// Size returns the number of keys, including the expired keys which were not removed yet
+func (db *DB) Size() int {
+	return len(db.file)
+}
+
+// Expires returns the number of keys with an expiration
+func (db *DB) Expires() int {
+	return len(db.expires)
+}
+
+// CountLookups counts the keys looked up by a read command as keyspace hits or misses
+func (db *DB) CountLookups(keys []string) {
+	for _, key := range keys {
+		if _, ok := db.lookup(key); ok {
+			db.hits++
+		} else {
+			db.misses++
+		}
+	}
+}
+
+// KeyspaceStats returns the number of keys found and not found by read commands
+func (db *DB) KeyspaceStats() (hits, misses int64) {
+	return db.hits, db.misses
+}
+
+// Rename moves the value of key to newKey, overwriting newKey if it exists. The expiration of key is
+// kept. False is returned if key does not exist
+func (db *DB) Rename(key, newKey string) bool {
+	node, ok := db.lookup(key)
+	if !ok {
+		return false
+	}
+
+	if key == newKey {
+		return true
+	}
+
+	db.remove(key)
+	db.remove(newKey)
+	db.Set(newKey, node)
+
+	return true
+}
+
+// Keys returns the keys which are not expired
+func (db *DB) Keys() []string {
+	now := Now()
+	keys := make([]string, 0, len(db.file))
+
+	for key, node := range db.file {
+		if !node.Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Scan returns the keys found from cursor on, which are not expired and hold values of type t, along
+// with the cursor to continue the iteration. A type of 0 matches any type. Iteration starts and ends
+// with a cursor of 0, every key which exists for the whole iteration is returned at least once
+func (db *DB) Scan(cursor uint64, count int, t DataType) ([]string, uint64) {
+	var keys, expired []string
+	now := Now()
+
+	cursor = db.index.Scan(cursor, count, func(key string) {
+		node := db.file[key]
+		switch {
+		case node.Expired(now):
+			expired = append(expired, key)
+		case t == 0 || node.Type == t:
+			keys = append(keys, key)
+		}
+	})
+
+	// the index can not be modified while it is scanned
+	for _, key := range expired {
+		db.remove(key)
+	}
+
+	return keys, cursor
+}
+
+// RandomKey returns a random key which is not expired, false is returned if there are no keys
+func (db *DB) RandomKey() (string, bool) {
+	now := Now()
+	for {
+		key, ok := db.index.Random()
+		if !ok {
+			return "", false
+		}
+
+		if !db.file[key].Expired(now) {
+			return key, true
+		}
+
+		db.remove(key)
+	}
+}
+
+// Flush removes all the keys
+func (db *DB) Flush() {
+	for key := range db.file {
+		db.remove(key)
+	}
+}
+
 func (db *DB) Exists(key string) int {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-	if _, ok := db.file[key]; ok {
+	if _, ok := db.lookup(key); ok {
 		return 1
 	}
 
 	return 0
 }
+
+// Expire sets the expiration of key to the given unix time in milliseconds,
+// returns 0 if the key does not exist
+func (db *DB) Expire(key string, at int64) int {
+	v, ok := db.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	v.ExpiresAt = at
+	db.expires[key] = struct{}{}
+
+	return 1
+}
+
+// Persist removes the expiration of key, returns 0 if the key does not exist or has no expiration
+func (db *DB) Persist(key string) int {
+	v, ok := db.lookup(key)
+	if !ok || v.ExpiresAt == -1 {
+		return 0
+	}
+
+	v.ExpiresAt = -1
+	delete(db.expires, key)
+
+	return 1
+}
+
+// TTL returns the remaining time to live of key in milliseconds,
+// -2 is returned if the key does not exist and -1 if the key has no expiration
+func (db *DB) TTL(key string) int64 {
+	v, ok := db.lookup(key)
+	if !ok {
+		return -2
+	}
+
+	if v.ExpiresAt == -1 {
+		return -1
+	}
+
+	return v.ExpiresAt - Now()
+}
+
+// Watch registers interest on modifications of key and returns its current version,
+// every call must be paired with a call to Unwatch
+func (db *DB) Watch(key string) uint64 {
+	w, ok := db.watched[key]
+	if !ok {
+		w = &watchedKey{}
+		db.watched[key] = w
+	}
+	w.refs++
+
+	return w.version
+}
+
+// Unwatch removes the interest registered with Watch
+func (db *DB) Unwatch(key string) {
+	w, ok := db.watched[key]
+	if !ok {
+		return
+	}
+
+	w.refs--
+	if w.refs <= 0 {
+		delete(db.watched, key)
+	}
+}
+
+// Version returns the current version of a watched key, versions change whenever the key is touched
+func (db *DB) Version(key string) uint64 {
+	if w, ok := db.watched[key]; ok {
+		return w.version
+	}
+
+	return 0
+}
+
+// Touch signals that keys were modified, versions are tracked only for watched keys. The memory
+// used by the modified keys is measured again
+func (db *DB) Touch(keys []string) {
+	db.dirty += int64(len(keys))
+
+	for _, key := range keys {
+		if w, ok := db.watched[key]; ok {
+			w.version++
+		}
+
+		if node, ok := db.file[key]; ok {
+			db.resize(key, node)
+		}
+	}
+}
+
+// AddFeed registers f to receive the commands which modify the key space
+func (db *DB) AddFeed(f Feed) {
+	db.feeds = append(db.feeds, f)
+}
+
+// RemoveFeed stops propagating commands to f
+func (db *DB) RemoveFeed(f Feed) {
+	for i, feed := range db.feeds {
+		if feed == f {
+			db.feeds = append(db.feeds[:i], db.feeds[i+1:]...)
+			return
+		}
+	}
+}
+
+// Propagate sends a command which modified the key space of the database to the registered feeds
+func (db *DB) Propagate(cmd string, args []string) {
+	for _, feed := range db.feeds {
+		feed.Feed(db.id, cmd, args)
+	}
+}
+
+// Dirty returns the number of modifications since the last snapshot
+func (db *DB) Dirty() int64 {
+	return db.dirty
+}
+
+// ClearDirty forgets n modifications, which were persisted by a snapshot
+func (db *DB) ClearDirty(n int64) {
+	db.dirty -= n
+	if db.dirty < 0 {
+		db.dirty = 0
+	}
+}
+
+// Snapshot returns a deep copy of the keys which are not expired, the copy is not affected by
+// later modifications so it can be persisted while the database is in use
+func (db *DB) Snapshot() map[string]*DataNode {
+	now := Now()
+	nodes := make(map[string]*DataNode, len(db.file))
+
+	for key, node := range db.file {
+		if !node.Expired(now) {
+			nodes[key] = node.Copy()
+		}
+	}
+
+	return nodes
+}
+
+// SnapshotAll returns the snapshots of all the databases of the server, ordered by their index
+func (db *DB) SnapshotAll() []map[string]*DataNode {
+	snapshots := make([]map[string]*DataNode, len(db.dbs))
+	for i, d := range db.dbs {
+		snapshots[i] = d.Snapshot()
+	}
+
+	return snapshots
+}
+
+// ActiveExpireCycle samples keys with an expiration in every database and removes the expired ones,
+// so that keys which are never accessed again are reclaimed as well. Sampling of a database is
+// repeated while a large portion of the sample was expired and time limit is not reached
+func (db *DB) ActiveExpireCycle() {
+	start := time.Now()
+
+	for _, d := range db.dbs {
+		for {
+			d.Lock()
+			sampled, expired := d.expireSample(activeExpireSampleSize)
+			d.Unlock()
+
+			if time.Since(start) > activeExpireTimeLimit {
+				return
+			}
+
+			if sampled == 0 || expired*100 <= sampled*activeExpireRepeatPercentage {
+				break
+			}
+		}
+	}
+}
+
+// expireSample checks at most n keys with an expiration and removes the expired ones
+func (db *DB) expireSample(n int) (sampled, expired int) {
+	now := Now()
+
+	// map iteration order is randomized, which makes this a random sample
+	for key := range db.expires {
+		if sampled >= n {
+			break
+		}
+		sampled++
+
+		if v, ok := db.file[key]; !ok || v.Expired(now) {
+			db.remove(key)
+			expired++
+		}
+	}
+
+	return sampled, expired
+}
@@ -0,0 +1,255 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package db
+
+import (
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestDB_LazyExpire(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	db.Set("expired", NewDataNode(TypeString, Now()-1, "v"))
+	db.Set("alive", NewDataNode(TypeString, Now()+60000, "v"))
+
+	assert.Equal(0, db.Exists("expired"))
+	assert.Equal(1, db.Exists("alive"))
+	assert.Len(db.file, 1)
+	assert.Len(db.expires, 1)
+
+	_, err := db.Get("expired")
+	assert.NotNil(err)
+
+	val, found := db.GetIfNotSet("alive", NewDataNode(TypeString, -1, "new"))
+	assert.True(found)
+	assert.Equal("v", val.Value)
+}
+
+func TestDB_ExpirePersistTTL(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	assert.Equal(int64(-2), db.TTL("key"))
+	assert.Equal(0, db.Expire("key", Now()+1000))
+
+	db.Set("key", NewDataNode(TypeString, -1, "v"))
+	assert.Equal(int64(-1), db.TTL("key"))
+	assert.Equal(1, db.Expire("key", Now()+1000))
+	assert.True(db.TTL("key") > 0)
+
+	assert.Equal(1, db.Persist("key"))
+	assert.Equal(0, db.Persist("key"))
+	assert.Equal(int64(-1), db.TTL("key"))
+	assert.Len(db.expires, 0)
+
+	// setting a new value without an expiration clears it
+	db.Expire("key", Now()+1000)
+	db.Set("key", NewDataNode(TypeString, -1, "v"))
+	assert.Len(db.expires, 0)
+}
+
+func TestDB_ActiveExpireCycle(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	for i := 0; i < 100; i++ {
+		db.Set("expired"+strconv.Itoa(i), NewDataNode(TypeString, Now()-1, "v"))
+	}
+	db.Set("alive", NewDataNode(TypeString, Now()+60000, "v"))
+	db.Set("persistent", NewDataNode(TypeString, -1, "v"))
+
+	db.ActiveExpireCycle()
+
+	assert.Len(db.file, 2)
+	assert.Len(db.expires, 1)
+}
+
+func TestDB_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	for i := 0; i < 50; i++ {
+		db.Set(strconv.Itoa(i), NewDataNode(TypeString, -1, "v"))
+	}
+	db.Set("list", NewDataNode(TypeList, -1, nil))
+	db.Set("expired", NewDataNode(TypeString, 1, "v"))
+
+	var keys []string
+	for cursor := uint64(0); ; {
+		var found []string
+		found, cursor = db.Scan(cursor, 5, 0)
+		keys = append(keys, found...)
+		if cursor == 0 {
+			break
+		}
+	}
+	assert.Len(keys, 51)
+	assert.NotContains(keys, "expired")
+	assert.Equal(51, db.Size())
+
+	keys, cursor := db.Scan(0, 1000, TypeList)
+	assert.Equal([]string{"list"}, keys)
+	assert.Equal(uint64(0), cursor)
+}
+
+func TestDB_RandomKey(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	_, ok := db.RandomKey()
+	assert.False(ok)
+
+	db.Set("expired", NewDataNode(TypeString, 1, "v"))
+	db.Set("alive", NewDataNode(TypeString, -1, "v"))
+	for i := 0; i < 10; i++ {
+		key, ok := db.RandomKey()
+		assert.True(ok)
+		assert.Equal("alive", key)
+	}
+
+	db.Flush()
+	_, ok = db.RandomKey()
+	assert.False(ok)
+}
+
+func TestDB_Rename(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	assert.False(db.Rename("missing", "b"))
+
+	db.Set("a", NewDataNode(TypeString, Now()+60000, "1"))
+	db.Set("b", NewDataNode(TypeString, -1, "2"))
+	assert.True(db.Rename("a", "b"))
+	assert.Equal(0, db.Exists("a"))
+	assert.True(db.TTL("b") > 0)
+	assert.Equal(1, db.Size())
+	assert.Equal(db.file["b"].size, db.UsedMemory())
+
+	assert.True(db.Rename("b", "b"))
+	assert.Equal(1, db.Exists("b"))
+}
+
+func TestDB_Select(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := NewDBs(2)
+
+	selected, err := dbs[0].Select(1)
+	assert.Nil(err)
+	assert.Equal(dbs[1], selected)
+	assert.Equal(1, selected.ID())
+	assert.Equal(2, selected.Databases())
+
+	_, err = dbs[0].Select(2)
+	assert.Equal(ErrInvalidDBIndex, err)
+	_, err = dbs[0].Select(-1)
+	assert.Equal(ErrInvalidDBIndex, err)
+
+	// the databases share their memory usage but not their keys
+	dbs[1].Set("k", NewDataNode(TypeString, -1, "v"))
+	assert.Equal(0, dbs[0].Exists("k"))
+	assert.Equal(dbs[1].UsedMemory(), dbs[0].UsedMemory())
+	assert.Len(NewDBs(0), 1)
+}
+
+func TestDB_SwapDB(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := NewDBs(2)
+
+	dbs[0].Set("a", NewDataNode(TypeString, Now()+60000, "1"))
+	dbs[1].Set("b", NewDataNode(TypeString, -1, "2"))
+	version := dbs[0].Watch("b")
+
+	assert.Equal(ErrInvalidDBIndex, dbs[0].SwapDB(0, 2))
+	assert.Nil(dbs[0].SwapDB(0, 1))
+	assert.Equal(1, dbs[0].Exists("b"))
+	assert.Equal(0, dbs[0].Exists("a"))
+	assert.True(dbs[1].TTL("a") > 0)
+	assert.NotEqual(version, dbs[0].Version("b"))
+
+	keys, _ := dbs[1].Scan(0, 10, TypeString)
+	assert.Equal([]string{"a"}, keys)
+}
+
+func TestDB_Move(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := NewDBs(2)
+
+	assert.False(dbs[0].Move("missing", dbs[1]))
+
+	dbs[0].Set("a", NewDataNode(TypeString, Now()+60000, "1"))
+	dbs[1].Set("b", NewDataNode(TypeString, -1, "2"))
+	dbs[0].Set("b", NewDataNode(TypeString, -1, "3"))
+	version := dbs[1].Watch("a")
+
+	assert.True(dbs[0].Move("a", dbs[1]))
+	assert.Equal(0, dbs[0].Exists("a"))
+	assert.True(dbs[1].TTL("a") > 0)
+	assert.NotEqual(version, dbs[1].Version("a"))
+
+	// keys held by the target are not overwritten
+	assert.False(dbs[0].Move("b", dbs[1]))
+	assert.Equal(1, dbs[0].Exists("b"))
+}
+
+func TestDB_Block(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	var served []string
+	serve := func(name string, accept bool) func(d *DB, key string) bool {
+		return func(d *DB, key string) bool {
+			if accept {
+				served = append(served, name+":"+key)
+			}
+			return accept
+		}
+	}
+
+	first := db.Block([]string{"a", "b", "a"}, serve("first", true))
+	db.Block([]string{"a"}, serve("second", true))
+	cancelled := db.Block([]string{"b"}, serve("cancelled", true))
+	assert.Equal(2, db.BlockedKeys())
+
+	// keys without blocked clients are never ready
+	db.SignalReady("c")
+	db.SignalReady("a")
+	db.SignalReady("a")
+	db.ServeBlocked()
+	assert.Equal([]string{"first:a", "second:a"}, served)
+	assert.False(db.Unblock(first))
+
+	// a client which can't be served stays blocked
+	db.Block([]string{"b"}, serve("refused", false))
+	assert.True(db.Unblock(cancelled))
+	db.SignalReady("b")
+	db.ServeBlocked()
+	assert.Len(served, 2)
+	assert.Equal(1, db.BlockedKeys())
+}
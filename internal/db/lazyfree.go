@@ -0,0 +1,119 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package db
+
+import (
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/scan"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+// values with more elements than this are released on a background goroutine by Unlink and FlushAsync
+const lazyFreeThreshold = 64
+
+// elements returns the number of elements of the value of node, strings count as a single element
+func (node *DataNode) elements() int {
+	switch v := node.Value.(type) {
+	case *list.TList:
+		return v.Len()
+	case *hashmap.HashMap:
+		return v.Len()
+	case *set.Set:
+		return v.Card()
+	case *zset.ZSet:
+		return v.Card()
+	}
+
+	return 1
+}
+
+// release drops the elements of the containers in nodes, so that the work of tearing down large
+// values is not done while the lock is held. nodes must not be reachable from the key space anymore
+func release(nodes []*DataNode) {
+	for _, node := range nodes {
+		switch v := node.Value.(type) {
+		case *list.TList:
+			v.Clear()
+		case *hashmap.HashMap:
+			v.Clear()
+		case *set.Set:
+			v.Clear()
+		case *zset.ZSet:
+			v.Clear()
+		}
+	}
+}
+
+// Unlink removes keys like Del, the values of large keys are released on a background goroutine
+func (db *DB) Unlink(keys []string) int {
+	var large []*DataNode
+	unlinked := 0
+
+	for _, key := range keys {
+		node, ok := db.lookup(key)
+		if !ok {
+			continue
+		}
+
+		if node.elements() > lazyFreeThreshold {
+			large = append(large, node)
+		}
+		db.remove(key)
+		unlinked++
+	}
+
+	if len(large) > 0 {
+		go release(large)
+	}
+
+	return unlinked
+}
+
+// FlushAsync removes all the keys like Flush, but the key space is replaced at once and the previous
+// one is released on a background goroutine
+func (db *DB) FlushAsync() {
+	file := db.file
+
+	for key, w := range db.watched {
+		if _, ok := file[key]; ok {
+			w.version++
+		}
+	}
+
+	db.dirty += int64(len(file))
+	db.file = make(map[string]*DataNode)
+	db.index = scan.New()
+	db.expires = make(map[string]struct{})
+	db.usedMemory = 0
+
+	go func() {
+		nodes := make([]*DataNode, 0, len(file))
+		for _, node := range file {
+			nodes = append(nodes, node)
+		}
+		release(nodes)
+	}()
+}
@@ -0,0 +1,69 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package db
+
+import (
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/pkg/types/set"
+)
+
+func TestDB_Unlink(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	elems := make([]string, lazyFreeThreshold+1)
+	for i := range elems {
+		elems[i] = strconv.Itoa(i)
+	}
+	db.Set("large", NewDataNode(TypeSet, -1, set.NewFromSlice(elems)))
+	db.Set("small", NewDataNode(TypeString, -1, "v"))
+
+	assert.Equal(2, db.Unlink([]string{"large", "small", "missing"}))
+	assert.Equal(0, db.Size())
+	assert.Equal(int64(0), db.UsedMemory())
+}
+
+func TestDB_FlushAsync(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	db.Set("a", NewDataNode(TypeString, Now()+60000, "v"))
+	db.Set("b", NewDataNode(TypeSet, -1, set.NewFromSlice([]string{"x"})))
+	version := db.Watch("a")
+
+	db.FlushAsync()
+	assert.Equal(0, db.Size())
+	assert.Empty(db.expires)
+	assert.Equal(int64(0), db.UsedMemory())
+	assert.NotEqual(version, db.Version("a"))
+
+	_, ok := db.RandomKey()
+	assert.False(ok)
+	db.Set("a", NewDataNode(TypeString, -1, "v"))
+	assert.Equal([]string{"a"}, db.Keys())
+}
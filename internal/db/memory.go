@@ -0,0 +1,356 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+// eviction policies applied when the memory limit is reached
+const (
+	NoEviction     = "noeviction"     // writes are rejected
+	AllKeysLRU     = "allkeys-lru"    // the least recently used keys are evicted
+	AllKeysLFU     = "allkeys-lfu"    // the least frequently used keys are evicted
+	AllKeysRandom  = "allkeys-random" // random keys are evicted
+	VolatileLRU    = "volatile-lru"   // the least recently used keys with an expiration are evicted
+	VolatileLFU    = "volatile-lfu"   // the least frequently used keys with an expiration are evicted
+	VolatileRandom = "volatile-random"
+	VolatileTTL    = "volatile-ttl" // the keys with an expiration closest to now are evicted
+)
+
+// DefaultMemorySamples is the number of keys sampled to pick one to evict and the number of elements
+// sampled to estimate the memory used by containers
+const DefaultMemorySamples = 5
+
+// approximate bytes used by the structures holding the data besides the strings themselves
+const (
+	keyOverhead       = 96 // entry of the key space, the data node and the key
+	stringOverhead    = 16
+	listElemOverhead  = 64 // element of the linked list and the string
+	hashFieldOverhead = 64 // map entry and the strings of the field and the value
+	setElemOverhead   = 48 // map entry and the string of the element
+	zsetElemOverhead  = 96 // map entry, skip list node and the string of the member
+)
+
+const (
+	// access counter of new nodes, so that they are not evicted before they have a chance to be accessed
+	lfuInitFreq = 5
+	// the higher the factor, the more accesses are needed to increment the counter
+	lfuLogFactor = 10
+	// milliseconds after which the access counter of a node which is not accessed is decremented
+	lfuDecayTime = 60 * 1000
+)
+
+// ValidEvictionPolicy checks whether policy is one of the eviction policies
+func ValidEvictionPolicy(policy string) error {
+	switch policy {
+	case NoEviction, AllKeysLRU, AllKeysLFU, AllKeysRandom, VolatileLRU, VolatileLFU, VolatileRandom, VolatileTTL:
+		return nil
+	}
+
+	return fmt.Errorf("invalid maxmemory policy %q", policy)
+}
+
+// access records an access to the node at now. The access counter is incremented with a probability
+// which decreases as the counter grows, so that it approximates the logarithm of the accesses
+func (node *DataNode) access(now int64) {
+	freq := node.decayedFreq(now)
+	if freq < math.MaxUint8 {
+		p := 1.0
+		if freq > lfuInitFreq {
+			p = 1.0 / float64((int(freq)-lfuInitFreq)*lfuLogFactor+1)
+		}
+
+		if rand.Float64() < p {
+			freq++
+		}
+	}
+
+	node.freq = freq
+	node.accessedAt = now
+}
+
+// decayedFreq returns the access counter decremented once for every decay period without an access
+func (node *DataNode) decayedFreq(now int64) uint8 {
+	periods := (now - node.accessedAt) / lfuDecayTime
+	if periods >= int64(node.freq) {
+		return 0
+	}
+
+	return node.freq - uint8(periods)
+}
+
+// Idle returns the milliseconds since the last access of the node
+func (node *DataNode) Idle(now int64) int64 {
+	return now - node.accessedAt
+}
+
+// Freq returns the logarithmic access counter of the node
+func (node *DataNode) Freq(now int64) int {
+	return int(node.decayedFreq(now))
+}
+
+// MemoryUsage estimates the bytes used by the value of the node. The size of containers is extrapolated
+// from at most samples elements, all of them are measured if samples is 0
+func (node *DataNode) MemoryUsage(samples int) int64 {
+	switch v := node.Value.(type) {
+	case string:
+		return stringOverhead + int64(len(v))
+	case *list.TList:
+		n := v.Len()
+		if samples > 0 && samples < n {
+			return extrapolate(v.Range(0, samples-1), 1, n, listElemOverhead)
+		}
+
+		return extrapolate(v.Range(0, -1), 1, n, listElemOverhead)
+	case *hashmap.HashMap:
+		n := v.Len()
+		if samples > 0 && samples < n {
+			return extrapolate(v.Sample(samples), 2, n, hashFieldOverhead)
+		}
+
+		return extrapolate(v.Fields(), 2, n, hashFieldOverhead)
+	case *set.Set:
+		n := v.Card()
+		if samples > 0 && samples < n {
+			return extrapolate(v.Sample(samples), 1, n, setElemOverhead)
+		}
+
+		return extrapolate(v.Elems(), 1, n, setElemOverhead)
+	case *zset.ZSet:
+		n := v.Card()
+		if samples > 0 && samples < n {
+			return extrapolate(v.Sample(samples), 1, n, zsetElemOverhead)
+		}
+
+		return extrapolate(v.Sample(n), 1, n, zsetElemOverhead)
+	}
+
+	return 0
+}
+
+// extrapolate estimates the bytes used by n elements from the sampled strings, each element is made of
+// group strings and uses overhead bytes besides them
+func extrapolate(sampled []string, group, n int, overhead int64) int64 {
+	if len(sampled) < group {
+		return 0
+	}
+
+	var sum int64
+	for _, s := range sampled {
+		sum += int64(len(s))
+	}
+
+	return int64(n) * (overhead + sum*int64(group)/int64(len(sampled)))
+}
+
+// resize measures the memory used by key and its node again
+func (db *DB) resize(key string, node *DataNode) {
+	size := keyOverhead + int64(len(key)) + node.MemoryUsage(db.memorySamples)
+	db.usedMemory += size - node.size
+	node.size = size
+
+	if db.usedMemory > db.peakMemory {
+		db.peakMemory = db.usedMemory
+	}
+}
+
+// MemoryUsage returns the bytes used by key and its value, elements of containers are sampled as in
+// DataNode.MemoryUsage. False is returned if the key does not exist
+func (db *DB) MemoryUsage(key string, samples int) (int64, bool) {
+	node, ok := db.file[key]
+	if !ok || node.Expired(Now()) {
+		return 0, false
+	}
+
+	return keyOverhead + int64(len(key)) + node.MemoryUsage(samples), true
+}
+
+// UsedMemory returns the approximate bytes used by the keys and their values
+func (db *DB) UsedMemory() int64 {
+	return db.usedMemory
+}
+
+// PeakMemory returns the highest amount of memory used by the keys since the database was created
+func (db *DB) PeakMemory() int64 {
+	return db.peakMemory
+}
+
+// KeyUsage is the memory used by a key
+type KeyUsage struct {
+	Key   string
+	Type  DataType
+	Bytes int64
+}
+
+// BigKeys returns the n keys using the most memory, largest first. Every key is visited, the sizes
+// measured when the keys were last modified are compared so that values are not measured again
+func (db *DB) BigKeys(n int) []KeyUsage {
+	now := Now()
+	keys := make([]KeyUsage, 0, len(db.file))
+	for key, node := range db.file {
+		if !node.Expired(now) {
+			keys = append(keys, KeyUsage{Key: key, Type: node.Type, Bytes: node.size})
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Bytes != keys[j].Bytes {
+			return keys[i].Bytes > keys[j].Bytes
+		}
+
+		return keys[i].Key < keys[j].Key
+	})
+
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+
+	return keys
+}
+
+// Evicted returns the number of keys evicted to keep the memory used below the limit
+func (db *DB) Evicted() int64 {
+	return db.evicted
+}
+
+// MaxMemory returns the memory limit and the eviction policy
+func (db *DB) MaxMemory() (int64, string) {
+	return db.maxMemory, db.maxMemoryPolicy
+}
+
+// SetMaxMemory limits the memory used by the keys to maxMemory bytes, keys are evicted with policy
+// once the limit is reached. 0 means no limit. samples keys are compared to pick the one to evict
+func (db *DB) SetMaxMemory(maxMemory int64, policy string, samples int) error {
+	if err := ValidEvictionPolicy(policy); err != nil {
+		return err
+	}
+
+	if samples <= 0 {
+		samples = DefaultMemorySamples
+	}
+
+	db.maxMemory, db.maxMemoryPolicy, db.memorySamples = maxMemory, policy, samples
+
+	return nil
+}
+
+// FreeMemory evicts keys of any database until the memory used is within the limit and reports whether
+// it is. The evicted keys are propagated as deletions
+func (db *DB) FreeMemory() bool {
+	if db.maxMemory <= 0 {
+		return true
+	}
+
+	for db.usedMemory > db.maxMemory {
+		d, key, ok := db.evictionCandidate()
+		if !ok {
+			return false
+		}
+
+		d.remove(key)
+		db.evicted++
+		d.Propagate("del", []string{key})
+	}
+
+	return true
+}
+
+// evictionCandidate picks the best key to evict among a sample of the keys of every database which are
+// considered by the policy. Expired keys are always picked first. False is returned if there is no key
+// to evict
+func (db *DB) evictionCandidate() (*DB, string, bool) {
+	if db.maxMemoryPolicy == NoEviction {
+		return nil, "", false
+	}
+
+	now := Now()
+	var best *DB
+	var bestKey string
+	bestScore := int64(math.MinInt64)
+
+	for _, d := range db.dbs {
+		for _, key := range d.evictionSample() {
+			node, ok := d.file[key]
+			if !ok {
+				continue
+			}
+
+			// the higher the score, the better the candidate
+			var score int64
+			switch {
+			case node.Expired(now):
+				return d, key, true
+			case db.maxMemoryPolicy == AllKeysLRU || db.maxMemoryPolicy == VolatileLRU:
+				score = node.Idle(now)
+			case db.maxMemoryPolicy == AllKeysLFU || db.maxMemoryPolicy == VolatileLFU:
+				score = -int64(node.decayedFreq(now))
+			case db.maxMemoryPolicy == VolatileTTL:
+				score = -node.ExpiresAt
+			}
+
+			if best == nil || score > bestScore {
+				best, bestKey, bestScore = d, key, score
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, "", false
+	}
+
+	return best, bestKey, true
+}
+
+// evictionSample returns a random sample of the keys of the database which are considered by the policy
+func (db *DB) evictionSample() []string {
+	var keys []string
+	if strings.HasPrefix(db.maxMemoryPolicy, "volatile") {
+		for key := range db.expires {
+			if len(keys) >= db.memorySamples {
+				break
+			}
+			keys = append(keys, key)
+		}
+	} else {
+		for key := range db.file {
+			if len(keys) >= db.memorySamples {
+				break
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,185 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package db
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+type recordingFeed struct {
+	commands [][]string
+}
+
+func (f *recordingFeed) Feed(db int, cmd string, args []string) {
+	f.commands = append(f.commands, append([]string{cmd}, args...))
+}
+
+func TestDB_MemoryUsage(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	db.Set("k", NewDataNode(TypeString, -1, "hello"))
+	assert.Equal(int64(keyOverhead+1+stringOverhead+5), db.UsedMemory())
+	db.Set("k", NewDataNode(TypeString, -1, "hello world"))
+	assert.Equal(int64(keyOverhead+1+stringOverhead+11), db.UsedMemory())
+
+	l := list.New()
+	l.TPush([]string{"abc", "abc", "abc", "abc", "abc", "abc", "abc", "abc", "abc", "abc"})
+	db.Set("l", NewDataNode(TypeList, -1, l))
+	usage, ok := db.MemoryUsage("l", 0)
+	assert.True(ok)
+	assert.Equal(int64(keyOverhead+1+10*(listElemOverhead+3)), usage)
+	assert.Equal(usage, db.file["l"].size)
+
+	// containers modified in place are measured again when they are touched
+	l.TPush([]string{"abc", "abc"})
+	db.Touch([]string{"l"})
+	assert.Equal(usage+2*(listElemOverhead+3), db.file["l"].size)
+
+	peak := db.UsedMemory()
+	db.Del([]string{"k", "l"})
+	assert.Equal(int64(0), db.UsedMemory())
+	assert.Equal(peak, db.PeakMemory())
+
+	_, ok = db.MemoryUsage("k", 0)
+	assert.False(ok)
+
+	z := zset.NewFromEntries([]zset.Entry{{Member: "ab", Score: 1}, {Member: "cd", Score: 2}})
+	db.Set("z", NewDataNode(TypeZSet, -1, z))
+	usage, _ = db.MemoryUsage("z", 0)
+	assert.Equal(int64(keyOverhead+1+2*(zsetElemOverhead+2)), usage)
+}
+
+func TestDB_BigKeys(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	db.Set("a", NewDataNode(TypeString, -1, "x"))
+	db.Set("b", NewDataNode(TypeString, -1, "xxxxxxxx"))
+	db.Set("c", NewDataNode(TypeString, -1, "xxxx"))
+	db.Set("expired", NewDataNode(TypeString, 1, "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"))
+
+	keys := db.BigKeys(2)
+	assert.Equal([]KeyUsage{
+		{Key: "b", Type: TypeString, Bytes: keyOverhead + 1 + stringOverhead + 8},
+		{Key: "c", Type: TypeString, Bytes: keyOverhead + 1 + stringOverhead + 4},
+	}, keys)
+	assert.Len(db.BigKeys(10), 3)
+	assert.Equal("string", keys[0].Type.String())
+}
+
+func TestDataNode_Access(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	db.Set("k", NewDataNode(TypeString, -1, "v"))
+	node := db.file["k"]
+	assert.Equal(lfuInitFreq, node.Freq(Now()))
+
+	for i := 0; i < 100; i++ {
+		db.Get("k")
+	}
+	freq := node.Freq(Now())
+	assert.True(freq > lfuInitFreq && freq < 100)
+
+	// the counter decays while the node is not accessed
+	assert.Equal(freq-2, node.Freq(Now()+2*lfuDecayTime))
+	assert.Equal(0, node.Freq(Now()+1000*lfuDecayTime))
+	assert.True(node.Idle(Now()+1000) >= 1000)
+}
+
+func TestDB_Eviction(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+	feed := &recordingFeed{}
+	db.AddFeed(feed)
+
+	assert.NotNil(db.SetMaxMemory(0, "foo", 0))
+	for _, key := range []string{"a", "b", "c"} {
+		db.Set(key, NewDataNode(TypeString, -1, "v"))
+	}
+	keySize := db.UsedMemory() / 3
+
+	// nothing is evicted with noeviction
+	assert.Nil(db.SetMaxMemory(2*keySize, NoEviction, 10))
+	assert.False(db.FreeMemory())
+	assert.Equal(int64(0), db.Evicted())
+
+	// the least recently used key is evicted
+	db.file["b"].accessedAt = Now() - 10000
+	assert.Nil(db.SetMaxMemory(2*keySize, AllKeysLRU, 10))
+	assert.True(db.FreeMemory())
+	assert.Equal(0, db.Exists("b"))
+	assert.Equal([][]string{{"del", "b"}}, feed.commands)
+
+	// the least frequently used key is evicted
+	db.file["a"].freq = 100
+	db.file["c"].freq = 1
+	assert.Nil(db.SetMaxMemory(keySize, AllKeysLFU, 10))
+	assert.True(db.FreeMemory())
+	assert.Equal(1, db.Exists("a"))
+	assert.Equal(int64(2), db.Evicted())
+
+	// only keys with an expiration are evicted by volatile policies, the closest one first
+	db.Set("soon", NewDataNode(TypeString, Now()+1000, "v"))
+	db.Set("later", NewDataNode(TypeString, Now()+60000, "v"))
+	assert.Nil(db.SetMaxMemory(db.UsedMemory()-1, VolatileTTL, 10))
+	assert.True(db.FreeMemory())
+	assert.Equal(0, db.Exists("soon"))
+	assert.Equal(1, db.Exists("later"))
+
+	assert.Nil(db.SetMaxMemory(keySize-1, VolatileLRU, 10))
+	assert.False(db.FreeMemory())
+	assert.Equal(1, db.Exists("a"))
+	assert.Equal(0, db.Exists("later"))
+
+	assert.Nil(db.SetMaxMemory(keySize-1, AllKeysRandom, 10))
+	assert.True(db.FreeMemory())
+	assert.Equal(int64(0), db.UsedMemory())
+	assert.Equal(int64(5), db.Evicted())
+}
+
+func TestDB_EvictionDatabases(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := NewDBs(2)
+	feed := &recordingFeed{}
+	dbs[0].AddFeed(feed)
+
+	dbs[0].Set("a", NewDataNode(TypeString, -1, "v"))
+	dbs[1].Set("b", NewDataNode(TypeString, -1, "v"))
+	dbs[1].file["b"].accessedAt = Now() - 10000
+
+	// keys of every database are candidates for eviction
+	assert.Nil(dbs[0].SetMaxMemory(dbs[0].UsedMemory()-1, AllKeysLRU, 10))
+	assert.True(dbs[0].FreeMemory())
+	assert.Equal(1, dbs[0].Exists("a"))
+	assert.Equal(0, dbs[1].Exists("b"))
+	assert.Equal([][]string{{"del", "b"}}, feed.commands)
+}
@@ -24,6 +24,13 @@
 
 package db
 
+import (
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
 type DataType int
 
 const (
@@ -31,14 +38,75 @@ const (
 	TypeList    DataType = 2
 	TypeHashMap DataType = 3
 	TypeSet     DataType = 4
+	TypeZSet    DataType = 5
 )
 
+// String returns the name of the type as reported to clients
+func (t DataType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeHashMap:
+		return "hash"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	}
+
+	return "none"
+}
+
+// ParseDataType returns the type named name by String, false is returned for unknown names
+func ParseDataType(name string) (DataType, bool) {
+	for _, t := range []DataType{TypeString, TypeList, TypeHashMap, TypeSet, TypeZSet} {
+		if t.String() == name {
+			return t, true
+		}
+	}
+
+	return 0, false
+}
+
 type DataNode struct {
 	Type      DataType
-	ExpiresAt int
+	ExpiresAt int64 // unix time in milliseconds, -1 means the node never expires
 	Value     interface{}
+
+	accessedAt int64 // unix time in milliseconds of the last access
+	freq       uint8 // logarithmic access counter which decays while the node is not accessed
+	size       int64 // memory used by the key and the node when it was last measured
+}
+
+func NewDataNode(t DataType, exp int64, val interface{}) *DataNode {
+	return &DataNode{Type: t, ExpiresAt: exp, Value: val}
 }
 
-func NewDataNode(t DataType, exp int, val interface{}) *DataNode {
-	return &DataNode{t, exp, val}
+// Expired reports whether the node has passed its expiration time at now
+func (node *DataNode) Expired(now int64) bool {
+	return node.ExpiresAt != -1 && node.ExpiresAt <= now
+}
+
+// Copy returns a deep copy of the node, containers are copied so the copy is not affected by
+// modifications of the original node
+func (node *DataNode) Copy() *DataNode {
+	var val interface{}
+
+	switch v := node.Value.(type) {
+	case *list.TList:
+		val = v.Copy()
+	case *hashmap.HashMap:
+		val = v.Copy()
+	case *set.Set:
+		val = v.Copy()
+	case *zset.ZSet:
+		val = v.Copy()
+	default:
+		// strings are immutable
+		val = v
+	}
+
+	return NewDataNode(node.Type, node.ExpiresAt, val)
 }
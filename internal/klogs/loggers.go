@@ -44,13 +44,7 @@ var Logger *logrus.Entry
 func InitLoggers(config config.AppConfig) {
 	var logrusLogger = logrus.New()
 
-	if config.Debug {
-		logrusLogger.SetLevel(logrus.DebugLevel)
-	} else if config.Verbose {
-		logrusLogger.SetLevel(logrus.InfoLevel)
-	} else {
-		logrusLogger.SetLevel(logrus.WarnLevel)
-	}
+	logrusLogger.SetLevel(level(config.Debug, config.Verbose))
 
 	fields := logrus.Fields{"pid": os.Getpid()}
 
@@ -111,6 +105,22 @@ func InitLoggers(config config.AppConfig) {
 	}
 }
 
+// level returns the level of the logs, debug logs are shown in debug mode and info logs in verbose mode
+func level(debug, verbose bool) logrus.Level {
+	if debug {
+		return logrus.DebugLevel
+	} else if verbose {
+		return logrus.InfoLevel
+	}
+
+	return logrus.WarnLevel
+}
+
+// SetLevel changes the level of the logs at runtime
+func SetLevel(debug, verbose bool) {
+	Logger.Logger.SetLevel(level(debug, verbose))
+}
+
 type kacheFormatter struct {
 }
 
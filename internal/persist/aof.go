@@ -0,0 +1,411 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+// fsync policies of the append only file
+const (
+	FsyncAlways   = "always"   // fsync after every command
+	FsyncEverySec = "everysec" // fsync once per second
+	FsyncNo       = "no"       // leave flushing to the operating system
+)
+
+// maximum number of elements written by a single command when the append only file is rewritten
+const rewriteItemsPerCommand = 64
+
+var (
+	ErrRewriteInProgress  = errors.New("background append only file rewriting already in progress")
+	ErrAppendOnlyDisabled = errors.New("append only file is disabled")
+)
+
+// AppendOnly is the append only file of the server, it is nil when the append only file is disabled
+var AppendOnly *AOF
+
+// AOF logs the commands which modified the key space in RESP format, so that the key space can be
+// restored by replaying them
+type AOF struct {
+	path       string
+	fsync      string
+	file       *os.File
+	w          *bufio.Writer
+	rewriteBuf *bytes.Buffer // commands fed while a rewrite is in progress, nil otherwise
+	db         int           // database selected by the last command, -1 selects it before the next one
+	done       chan struct{}
+	mux        sync.Mutex
+}
+
+// AppendOnlyPath returns the path of the append only file from the application config
+func AppendOnlyPath() string {
+	conf := config.Get()
+	return filepath.Join(conf.Dir, conf.AppendFilename)
+}
+
+// ValidFsyncPolicy checks whether policy is one of the fsync policies
+func ValidFsyncPolicy(policy string) error {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return nil
+	}
+
+	return fmt.Errorf("invalid appendfsync policy %q, excepted %s, %s or %s", policy, FsyncAlways, FsyncEverySec, FsyncNo)
+}
+
+// OpenAOF opens the append only file at path for appending, the file is created if it does not exist
+func OpenAOF(path, fsync string) (*AOF, error) {
+	if err := ValidFsyncPolicy(fsync); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AOF{path: path, fsync: fsync, file: file, w: bufio.NewWriter(file), db: -1, done: make(chan struct{})}, nil
+}
+
+// Feed appends a command applied to database db to the file, preceded by SELECT when db is not
+// the selected database, and syncs it according to the fsync policy. A SELECT fed by itself is
+// written as is
+func (aof *AOF) Feed(db int, cmd string, args []string) {
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	if db != aof.db && cmd != "select" {
+		aof.write("select", []string{strconv.Itoa(db)})
+	}
+	aof.db = db
+	aof.write(cmd, args)
+
+	if err := aof.w.Flush(); err != nil {
+		klogs.Logger.Errorf("error writing to the append only file: %s", err)
+		return
+	}
+
+	if aof.fsync == FsyncAlways {
+		if err := aof.file.Sync(); err != nil {
+			klogs.Logger.Errorf("error syncing the append only file: %s", err)
+		}
+	}
+}
+
+// write writes a command to the file and to the rewrite buffer
+func (aof *AOF) write(cmd string, args []string) {
+	protcl.WriteCommand(aof.w, cmd, args)
+	if aof.rewriteBuf != nil {
+		protcl.WriteCommand(aof.rewriteBuf, cmd, args)
+	}
+}
+
+// Sync commits the written commands to stable storage
+func (aof *AOF) Sync() error {
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	return aof.file.Sync()
+}
+
+// RunFsync syncs the file every second when the policy is everysec, until the file is closed
+func (aof *AOF) RunFsync() {
+	if aof.fsync != FsyncEverySec {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-aof.done:
+			return
+		case <-ticker.C:
+			if err := aof.Sync(); err != nil {
+				klogs.Logger.Errorf("error syncing the append only file: %s", err)
+			}
+		}
+	}
+}
+
+// Close flushes and closes the file
+func (aof *AOF) Close() error {
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	close(aof.done)
+	if err := aof.w.Flush(); err != nil {
+		aof.file.Close()
+		return err
+	}
+
+	if err := aof.file.Sync(); err != nil {
+		aof.file.Close()
+		return err
+	}
+
+	return aof.file.Close()
+}
+
+// Rewriting reports whether a background rewrite is in progress
+func (aof *AOF) Rewriting() bool {
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	return aof.rewriteBuf != nil
+}
+
+// Rewrite compacts the file in the background by writing the shortest sequence of commands which
+// rebuilds the current key space. Commands fed meanwhile are appended once the rewrite is done.
+// The caller must hold the lock of d
+func (aof *AOF) Rewrite(d *db.DB) error {
+	aof.mux.Lock()
+	if aof.rewriteBuf != nil {
+		aof.mux.Unlock()
+		return ErrRewriteInProgress
+	}
+	aof.rewriteBuf = &bytes.Buffer{}
+	// the commands fed meanwhile follow the rewritten databases, so they must select their database
+	aof.db = -1
+	aof.mux.Unlock()
+
+	dbs := d.SnapshotAll()
+
+	go func() {
+		start := time.Now()
+		if err := aof.rewrite(dbs); err != nil {
+			klogs.Logger.Errorf("background append only file rewriting failed: %s", err)
+			return
+		}
+
+		klogs.Logger.Infof("background append only file rewriting of %d keys finished in %s", countKeys(dbs), time.Since(start))
+	}()
+
+	return nil
+}
+
+// rewrite writes the databases to a temporary file, appends the commands fed since the rewrite started
+// and replaces the append only file with it
+func (aof *AOF) rewrite(dbs []map[string]*db.DataNode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(aof.path), "temp-rewriteaof-")
+	if err != nil {
+		aof.abortRewrite()
+		return err
+	}
+
+	if err := writeDatabases(tmp, dbs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		aof.abortRewrite()
+		return err
+	}
+
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	buf := aof.rewriteBuf
+	aof.rewriteBuf = nil
+
+	if _, err := buf.WriteTo(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// everything fed so far is in the new file, so the old one can be dropped
+	aof.w.Flush()
+	if err := os.Rename(tmp.Name(), aof.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	file, err := os.OpenFile(aof.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	aof.file.Close()
+	aof.file = file
+	aof.w.Reset(file)
+
+	return nil
+}
+
+func (aof *AOF) abortRewrite() {
+	aof.mux.Lock()
+	aof.rewriteBuf = nil
+	aof.mux.Unlock()
+}
+
+// writeDatabases writes the commands which rebuild the databases to w, the nodes of each database which
+// is not empty are preceded by SELECT
+func writeDatabases(w io.Writer, dbs []map[string]*db.DataNode) error {
+	bw := bufio.NewWriter(w)
+
+	for i, nodes := range dbs {
+		if len(nodes) == 0 {
+			continue
+		}
+
+		protcl.WriteCommand(bw, "select", []string{strconv.Itoa(i)})
+		if err := writeNodes(bw, nodes); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeNodes writes the commands which rebuild nodes to w
+func writeNodes(w io.Writer, nodes map[string]*db.DataNode) error {
+	bw := bufio.NewWriter(w)
+
+	for key, node := range nodes {
+		switch v := node.Value.(type) {
+		case string:
+			protcl.WriteCommand(bw, "set", []string{key, v})
+		case *list.TList:
+			writeItems(bw, "rpush", key, v.Range(0, -1), 1)
+		case *hashmap.HashMap:
+			writeItems(bw, "hset", key, v.Fields(), 2)
+		case *set.Set:
+			writeItems(bw, "sadd", key, v.Elems(), 1)
+		case *zset.ZSet:
+			writeItems(bw, "zadd", key, zaddArgs(v), 2)
+		default:
+			return fmt.Errorf("unknown value of type %T in %s", v, key)
+		}
+
+		if node.ExpiresAt != -1 {
+			protcl.WriteCommand(bw, "pexpireat", []string{key, strconv.FormatInt(node.ExpiresAt, 10)})
+		}
+	}
+
+	return bw.Flush()
+}
+
+// zaddArgs returns the scores and members of z as expected by ZADD
+func zaddArgs(z *zset.ZSet) []string {
+	entries := z.Entries()
+	args := make([]string, 0, 2*len(entries))
+	for _, e := range entries {
+		args = append(args, strconv.FormatFloat(e.Score, 'g', -1, 64), e.Member)
+	}
+
+	return args
+}
+
+// writeItems writes cmd for key with at most rewriteItemsPerCommand items of size strings each
+func writeItems(w io.Writer, cmd, key string, strs []string, size int) {
+	batch := rewriteItemsPerCommand * size
+	for len(strs) > 0 {
+		n := batch
+		if n > len(strs) {
+			n = len(strs)
+		}
+
+		protcl.WriteCommand(w, cmd, append([]string{key}, strs[:n]...))
+		strs = strs[n:]
+	}
+}
+
+// RewriteAppendOnly starts a background rewrite of the append only file of the server,
+// the caller must hold the lock of d
+func RewriteAppendOnly(d *db.DB) error {
+	if AppendOnly == nil {
+		return ErrAppendOnlyDisabled
+	}
+
+	return AppendOnly.Rewrite(d)
+}
+
+// LoadAppendOnly replays the commands of the append only file at path with exec and returns the
+// number of commands replayed, a missing file is not an error. A truncated last command, which is
+// left behind when the server stops while writing it, is removed from the file and the number of
+// bytes removed is returned
+func LoadAppendOnly(path string, exec func(cmd string, args []string) error) (replayed int, truncated int64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	r := protcl.NewStreamReader(file)
+	for {
+		start := r.Offset()
+		strs, err := r.ReadCommand()
+		if err == io.EOF {
+			return replayed, 0, nil
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			return replayed, r.Pending(), os.Truncate(path, r.Offset())
+		}
+
+		if err != nil {
+			return replayed, 0, fmt.Errorf("%s at offset %d", err, start)
+		}
+
+		if err := exec(strs[0], strs[1:]); err != nil {
+			return replayed, 0, fmt.Errorf("error replaying %s at offset %d: %s", strs[0], start, err)
+		}
+		replayed++
+	}
+}
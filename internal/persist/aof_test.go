@@ -0,0 +1,159 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package persist
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+type command struct {
+	name string
+	args []string
+}
+
+func replay(path string) ([]command, int64, error) {
+	var commands []command
+	_, truncated, err := LoadAppendOnly(path, func(cmd string, args []string) error {
+		commands = append(commands, command{cmd, args})
+		return nil
+	})
+
+	return commands, truncated, err
+}
+
+func TestAOF_FeedAndLoad(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appendonly.aof")
+
+	aof, err := OpenAOF(path, FsyncAlways)
+	assert.Nil(err)
+	aof.Feed(0, "set", []string{"key", "multi\r\nline"})
+	aof.Feed(0, "del", []string{"key"})
+	aof.Feed(1, "del", []string{"other"})
+	assert.Nil(aof.Close())
+
+	// the database is selected whenever it changes
+	commands, truncated, err := replay(path)
+	assert.Nil(err)
+	assert.Equal(int64(0), truncated)
+	assert.Equal([]command{
+		{"select", []string{"0"}}, {"set", []string{"key", "multi\r\nline"}}, {"del", []string{"key"}},
+		{"select", []string{"1"}}, {"del", []string{"other"}},
+	}, commands)
+
+	// a missing file is an empty one
+	commands, _, err = replay(filepath.Join(dir, "nonexistent"))
+	assert.Nil(err)
+	assert.Len(commands, 0)
+
+	_, err = OpenAOF(path, "sometimes")
+	assert.NotNil(err)
+}
+
+func TestLoadAppendOnly_Truncated(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appendonly.aof")
+
+	complete := "*2\r\n$3\r\ndel\r\n$3\r\nfoo\r\n"
+	ioutil.WriteFile(path, []byte(complete+"*3\r\n$3\r\nset\r\n$3\r\nba"), 0644)
+
+	commands, truncated, err := replay(path)
+	assert.Nil(err)
+	assert.Equal(int64(19), truncated)
+	assert.Equal([]command{{"del", []string{"foo"}}}, commands)
+
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(complete, string(data))
+
+	// garbage is not mistaken for a truncated command
+	ioutil.WriteFile(path, []byte(complete+"foo\r\n"), 0644)
+	_, _, err = replay(path)
+	assert.NotNil(err)
+
+	// corrupted lengths are rejected before anything is allocated
+	for _, corrupted := range []string{"*2\r\n$3\r\ndel\r\n$9999999999\r\n", "*9999999999\r\n$3\r\ndel\r\n"} {
+		ioutil.WriteFile(path, []byte(complete+corrupted), 0644)
+		_, _, err = replay(path)
+		assert.Contains(err.Error(), protcl.ErrBufferExceeded.Error())
+	}
+}
+
+func TestAOF_Rewrite(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appendonly.aof")
+
+	aof, err := OpenAOF(path, FsyncNo)
+	assert.Nil(err)
+	for i := 0; i < 10; i++ {
+		aof.Feed(0, "incr", []string{"counter"})
+	}
+
+	// commands fed while rewriting are kept after the rewritten state
+	aof.rewriteBuf = &bytes.Buffer{}
+	aof.db = -1
+	aof.Feed(0, "del", []string{"counter"})
+	assert.True(aof.Rewriting())
+	assert.Nil(aof.rewrite([]map[string]*db.DataNode{{}, testNodes()}))
+	assert.False(aof.Rewriting())
+	aof.Feed(0, "incr", []string{"counter"})
+	assert.Nil(aof.Close())
+
+	commands, _, err := replay(path)
+	assert.Nil(err)
+	assert.Equal(command{"select", []string{"1"}}, commands[0])
+
+	keys := map[string]string{}
+	for _, cmd := range commands[1 : len(commands)-3] {
+		keys[cmd.args[0]] = cmd.name
+	}
+	assert.Equal(map[string]string{"str": "set", "ttl": "pexpireat", "list": "rpush", "hash": "hset", "set": "sadd",
+		"zset": "zadd"}, keys)
+	assert.Equal([]command{{"select", []string{"0"}}, {"del", []string{"counter"}}, {"incr", []string{"counter"}}},
+		commands[len(commands)-3:])
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal([]string{path}, files)
+}
@@ -0,0 +1,81 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+
+	"github.com/kasvith/kache/internal/db"
+)
+
+var ErrInvalidDump = errors.New("DUMP payload version or checksum are wrong")
+
+// Dump serializes the value of node as in snapshots, followed by the snapshot version and the CRC-64
+// of the payload. The expiration is not part of the payload. Payloads of older versions are restored
+// as values are encoded the same way in all of them
+func Dump(node *db.DataNode) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	e := &encoder{w: bufio.NewWriter(buf)}
+
+	e.w.WriteByte(byte(node.Type))
+	if err := e.writeValue("", node); err != nil {
+		return nil, err
+	}
+	e.writeUvarint(snapshotVersion)
+	e.w.Flush()
+
+	binary.Write(buf, binary.LittleEndian, crc64.Checksum(buf.Bytes(), crcTable))
+
+	return buf.Bytes(), nil
+}
+
+// Restore returns a node with the value serialized by Dump in data, which expires at expiresAt
+func Restore(data []byte, expiresAt int64) (*db.DataNode, error) {
+	if len(data) < 3+8 {
+		return nil, ErrInvalidDump
+	}
+
+	body := data[:len(data)-8]
+	if crc64.Checksum(body, crcTable) != binary.LittleEndian.Uint64(data[len(data)-8:]) {
+		return nil, ErrInvalidDump
+	}
+
+	d := &decoder{r: bytes.NewReader(body[1:])}
+	t := db.DataType(body[0])
+	val, err := d.readValue(t)
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+
+	if version, err := binary.ReadUvarint(d.r); err != nil || version == 0 || version > snapshotVersion || d.r.Len() != 0 {
+		return nil, ErrInvalidDump
+	}
+
+	return db.NewDataNode(t, expiresAt, val), nil
+}
@@ -0,0 +1,278 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+)
+
+var ErrSaveInProgress = errors.New("background save already in progress")
+
+// saveRetryDelay is how long the save rules wait before retrying a failed background save
+const saveRetryDelay = 5 * time.Second
+
+var (
+	mux        sync.Mutex
+	lastSave   = time.Now().Unix() // unix time of the last successful save
+	saving     bool                // a background save is in progress
+	lastFailed time.Time           // when the last background save failed, zero when it succeeded
+)
+
+// SaveRule triggers a background save after Seconds if at least Changes modifications were done
+type SaveRule struct {
+	Seconds int64
+	Changes int64
+}
+
+// ParseSaveRules parses rules given as "<seconds> <changes>"
+func ParseSaveRules(rules []string) ([]SaveRule, error) {
+	parsed := make([]SaveRule, len(rules))
+
+	for i, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid save rule %q", rule)
+		}
+
+		seconds, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid seconds in save rule %q", rule)
+		}
+
+		changes, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || changes <= 0 {
+			return nil, fmt.Errorf("invalid changes in save rule %q", rule)
+		}
+
+		parsed[i] = SaveRule{Seconds: seconds, Changes: changes}
+	}
+
+	return parsed, nil
+}
+
+// SnapshotPath returns the path of the snapshot file from the application config
+func SnapshotPath() string {
+	conf := config.Get()
+	return filepath.Join(conf.Dir, conf.DbFilename)
+}
+
+// LastSave returns the unix time of the last successful save
+func LastSave() int64 {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return lastSave
+}
+
+// Saving reports whether a background save is in progress
+func Saving() bool {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return saving
+}
+
+// Save writes a snapshot of the databases of d in the foreground, the caller must hold the lock of d
+func Save(d *db.DB) error {
+	if Saving() {
+		return ErrSaveInProgress
+	}
+
+	dirty := d.Dirty()
+	if err := WriteFile(SnapshotPath(), d.SnapshotAll()); err != nil {
+		return err
+	}
+
+	d.ClearDirty(dirty)
+
+	mux.Lock()
+	lastSave = time.Now().Unix()
+	lastFailed = time.Time{}
+	mux.Unlock()
+
+	return nil
+}
+
+// lastFailure returns when the last background save failed, zero when it succeeded
+func lastFailure() time.Time {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return lastFailed
+}
+
+// BackgroundSave copies the databases of d and writes the snapshot in the background, clients are
+// only blocked while the copy is taken. The caller must hold the lock of d
+func BackgroundSave(d *db.DB) error {
+	mux.Lock()
+	if saving {
+		mux.Unlock()
+		return ErrSaveInProgress
+	}
+	saving = true
+	mux.Unlock()
+
+	dirty := d.Dirty()
+	dbs := d.SnapshotAll()
+	path := SnapshotPath()
+
+	go func() {
+		start := time.Now()
+		err := WriteFile(path, dbs)
+
+		if err == nil {
+			d.Lock()
+			d.ClearDirty(dirty)
+			d.Unlock()
+		}
+
+		mux.Lock()
+		saving = false
+		failing := !lastFailed.IsZero()
+		if err == nil {
+			lastSave = time.Now().Unix()
+			lastFailed = time.Time{}
+		} else {
+			lastFailed = time.Now()
+		}
+		mux.Unlock()
+
+		// a failure is logged once until a save succeeds again
+		if err != nil {
+			if failing {
+				klogs.Logger.Debugf("background saving failed again: %s", err)
+			} else {
+				klogs.Logger.Errorf("background saving failed: %s, retrying every %s", err, saveRetryDelay)
+			}
+			return
+		}
+
+		klogs.Logger.Infof("background saving of %d keys to %s finished in %s", countKeys(dbs), path, time.Since(start))
+	}()
+
+	return nil
+}
+
+// Load restores the keys of the snapshot file at path into the databases of d and returns the number
+// of keys loaded, a missing file is not an error. Expired keys are skipped
+func Load(d *db.DB, path string) (int, error) {
+	dbs, err := ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	return LoadDatabases(d, dbs)
+}
+
+// LoadDatabases sets the keys of dbs in the databases of d with the same index and returns the number of
+// keys set, expired keys are skipped. The caller must hold the lock of d
+func LoadDatabases(d *db.DB, dbs []map[string]*db.DataNode) (int, error) {
+	now := db.Now()
+	loaded := 0
+	for i, nodes := range dbs {
+		if len(nodes) == 0 {
+			continue
+		}
+
+		target, err := d.Select(i)
+		if err != nil {
+			return loaded, fmt.Errorf("snapshot has keys in database %d but there are only %d databases", i, d.Databases())
+		}
+
+		for key, node := range nodes {
+			if node.Expired(now) {
+				continue
+			}
+
+			target.Set(key, node)
+			loaded++
+		}
+	}
+
+	return loaded, nil
+}
+
+// countKeys returns the number of keys in dbs
+func countKeys(dbs []map[string]*db.DataNode) int {
+	n := 0
+	for _, nodes := range dbs {
+		n += len(nodes)
+	}
+
+	return n
+}
+
+// RunSaveRules starts a background save whenever one of the rules is satisfied
+func RunSaveRules(d *db.DB, rules []SaveRule) {
+	if len(rules) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		// a failed save is retried after a delay instead of on every tick
+		failed := lastFailure()
+		if !failed.IsZero() && now.Sub(failed) < saveRetryDelay {
+			continue
+		}
+
+		d.Lock()
+		dirty := d.Dirty()
+		elapsed := time.Now().Unix() - LastSave()
+
+		for _, rule := range rules {
+			if dirty >= rule.Changes && elapsed >= rule.Seconds {
+				if failed.IsZero() {
+					klogs.Logger.Infof("%d changes in %d seconds, saving", rule.Changes, rule.Seconds)
+				}
+				if err := BackgroundSave(d); err != nil && err != ErrSaveInProgress {
+					klogs.Logger.Errorf("background saving failed: %s", err)
+				}
+				break
+			}
+		}
+		d.Unlock()
+	}
+}
@@ -0,0 +1,373 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+// A snapshot file starts with the magic and the version, followed by the entries and the EOF op.
+// The entries of each database which is not empty are preceded by the select op and the index of
+// the database. Each entry is the type, the expiration, the key and the value. Integers are encoded
+// as varints, strings are prefixed by their length and containers are prefixed by the number of
+// strings they hold. The file ends with the CRC-64 of everything before it as 8 little endian bytes.
+// Version 1 files have no select op, their entries belong to the first database
+const (
+	snapshotMagic   = "KACHE"
+	snapshotVersion = 2
+
+	opSelect byte = 0xfe
+	opEOF    byte = 0xff
+)
+
+// highest database index accepted in a snapshot, so that a corrupted index does not allocate all the memory
+const maxDatabases = 1 << 16
+
+var (
+	ErrInvalidSnapshot  = errors.New("invalid snapshot file")
+	ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+	crcTable            = crc64.MakeTable(crc64.ECMA)
+)
+
+type ErrUnsupportedVersion struct {
+	Version uint64
+}
+
+func (e *ErrUnsupportedVersion) Error() string {
+	return fmt.Sprintf("unsupported snapshot version %d", e.Version)
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) writeUvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *encoder) writeVarint(v int64) {
+	n := binary.PutVarint(e.buf[:], v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *encoder) writeStrings(strs []string) {
+	e.writeUvarint(uint64(len(strs)))
+	for _, s := range strs {
+		e.writeString(s)
+	}
+}
+
+func (e *encoder) writeNode(key string, node *db.DataNode) error {
+	e.w.WriteByte(byte(node.Type))
+	e.writeVarint(node.ExpiresAt)
+	e.writeString(key)
+
+	return e.writeValue(key, node)
+}
+
+func (e *encoder) writeValue(key string, node *db.DataNode) error {
+	switch v := node.Value.(type) {
+	case string:
+		e.writeString(v)
+	case *list.TList:
+		e.writeStrings(v.Range(0, -1))
+	case *hashmap.HashMap:
+		e.writeStrings(v.Fields())
+	case *set.Set:
+		e.writeStrings(v.Elems())
+	case *zset.ZSet:
+		e.writeStrings(zsetStrings(v))
+	default:
+		return fmt.Errorf("unknown value of type %T in %s", v, key)
+	}
+
+	return nil
+}
+
+// Encode writes the nodes of the databases to w in the snapshot format, dbs is indexed by the index
+// of the databases
+func Encode(w io.Writer, dbs []map[string]*db.DataNode) error {
+	hash := crc64.New(crcTable)
+	e := &encoder{w: bufio.NewWriter(io.MultiWriter(w, hash))}
+
+	e.w.WriteString(snapshotMagic)
+	e.writeUvarint(snapshotVersion)
+
+	for i, nodes := range dbs {
+		if len(nodes) == 0 {
+			continue
+		}
+
+		e.w.WriteByte(opSelect)
+		e.writeUvarint(uint64(i))
+
+		for key, node := range nodes {
+			if err := e.writeNode(key, node); err != nil {
+				return err
+			}
+		}
+	}
+
+	e.w.WriteByte(opEOF)
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, hash.Sum64())
+}
+
+// zsetStrings returns the members of z followed by their score
+func zsetStrings(z *zset.ZSet) []string {
+	entries := z.Entries()
+	strs := make([]string, 0, 2*len(entries))
+	for _, e := range entries {
+		strs = append(strs, e.Member, strconv.FormatFloat(e.Score, 'g', -1, 64))
+	}
+
+	return strs
+}
+
+// zsetFromStrings returns the sorted set of the members and scores written by zsetStrings
+func zsetFromStrings(strs []string) (*zset.ZSet, error) {
+	if len(strs)%2 != 0 {
+		return nil, ErrInvalidSnapshot
+	}
+
+	entries := make([]zset.Entry, len(strs)/2)
+	for i := range entries {
+		score, err := strconv.ParseFloat(strs[2*i+1], 64)
+		if err != nil || math.IsNaN(score) {
+			return nil, ErrInvalidSnapshot
+		}
+		entries[i] = zset.Entry{Member: strs[2*i], Score: score}
+	}
+
+	return zset.NewFromEntries(entries), nil
+}
+
+type decoder struct {
+	r *bytes.Reader
+}
+
+func (d *decoder) readString() (string, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", ErrInvalidSnapshot
+	}
+
+	if n > uint64(d.r.Len()) {
+		return "", ErrInvalidSnapshot
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", ErrInvalidSnapshot
+	}
+
+	return string(buf), nil
+}
+
+func (d *decoder) readStrings() ([]string, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil || n > uint64(d.r.Len()) {
+		return nil, ErrInvalidSnapshot
+	}
+
+	strs := make([]string, n)
+	for i := range strs {
+		if strs[i], err = d.readString(); err != nil {
+			return nil, err
+		}
+	}
+
+	return strs, nil
+}
+
+func (d *decoder) readValue(t db.DataType) (interface{}, error) {
+	if t == db.TypeString {
+		return d.readString()
+	}
+
+	strs, err := d.readStrings()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case db.TypeList:
+		l := list.New()
+		if len(strs) > 0 {
+			l.TPush(strs)
+		}
+		return l, nil
+	case db.TypeHashMap:
+		m := hashmap.New()
+		if len(strs) > 0 {
+			if _, err := m.SetBulk(strs); err != nil {
+				return nil, ErrInvalidSnapshot
+			}
+		}
+		return m, nil
+	case db.TypeSet:
+		return set.NewFromSlice(strs), nil
+	case db.TypeZSet:
+		return zsetFromStrings(strs)
+	}
+
+	return nil, ErrInvalidSnapshot
+}
+
+// Decode reads the databases written by Encode, the checksum is verified before anything is decoded.
+// The databases up to the last one which holds keys are returned
+func Decode(data []byte) ([]map[string]*db.DataNode, error) {
+	if len(data) < len(snapshotMagic)+1+8 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+
+	body := data[:len(data)-8]
+	if crc64.Checksum(body, crcTable) != binary.LittleEndian.Uint64(data[len(data)-8:]) {
+		return nil, ErrChecksumMismatch
+	}
+
+	d := &decoder{r: bytes.NewReader(body[len(snapshotMagic):])}
+	version, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+
+	if version == 0 || version > snapshotVersion {
+		return nil, &ErrUnsupportedVersion{Version: version}
+	}
+
+	nodes := make(map[string]*db.DataNode)
+	dbs := []map[string]*db.DataNode{nodes}
+	for {
+		op, err := d.r.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		if op == opEOF {
+			break
+		}
+
+		if op == opSelect {
+			index, err := binary.ReadUvarint(d.r)
+			if err != nil || index > maxDatabases {
+				return nil, ErrInvalidSnapshot
+			}
+
+			for uint64(len(dbs)) <= index {
+				dbs = append(dbs, make(map[string]*db.DataNode))
+			}
+			nodes = dbs[index]
+			continue
+		}
+
+		expiresAt, err := binary.ReadVarint(d.r)
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := d.readValue(db.DataType(op))
+		if err != nil {
+			return nil, err
+		}
+
+		nodes[key] = db.NewDataNode(db.DataType(op), expiresAt, val)
+	}
+
+	if d.r.Len() != 0 {
+		return nil, ErrInvalidSnapshot
+	}
+
+	return dbs, nil
+}
+
+// WriteFile writes the databases to a snapshot file at path, the file is written to a temporary file
+// first and renamed so that the previous snapshot stays intact if anything goes wrong
+func WriteFile(path string, dbs []map[string]*db.DataNode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err := Encode(tmp, dbs); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadFile reads the databases of the snapshot file at path
+func ReadFile(path string) ([]map[string]*db.DataNode, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(data)
+}
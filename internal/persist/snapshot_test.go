@@ -0,0 +1,255 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+func testNodes() map[string]*db.DataNode {
+	l := list.New()
+	l.TPush([]string{"a", "b", "c"})
+
+	m := hashmap.New()
+	m.SetBulk([]string{"f1", "v1", "f2", ""})
+
+	return map[string]*db.DataNode{
+		"str":  db.NewDataNode(db.TypeString, -1, "value"),
+		"ttl":  db.NewDataNode(db.TypeString, db.Now()+60000, ""),
+		"list": db.NewDataNode(db.TypeList, -1, l),
+		"hash": db.NewDataNode(db.TypeHashMap, -1, m),
+		"set":  db.NewDataNode(db.TypeSet, -1, set.NewFromSlice([]string{"x", "y"})),
+		"zset": db.NewDataNode(db.TypeZSet, -1, zset.NewFromEntries([]zset.Entry{{Member: "m1", Score: 1.5}, {Member: "m2", Score: math.Inf(-1)}})),
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	assert := testifyAssert.New(t)
+	nodes := testNodes()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(Encode(buf, []map[string]*db.DataNode{nodes}))
+
+	dbs, err := Decode(buf.Bytes())
+	assert.Nil(err)
+	assert.Len(dbs, 1)
+	decoded := dbs[0]
+	assert.Len(decoded, len(nodes))
+
+	assert.Equal(nodes["str"], decoded["str"])
+	assert.Equal(nodes["ttl"], decoded["ttl"])
+	assert.Equal([]string{"a", "b", "c"}, decoded["list"].Value.(*list.TList).Range(0, -1))
+	assert.ElementsMatch(nodes["hash"].Value.(*hashmap.HashMap).Fields(), decoded["hash"].Value.(*hashmap.HashMap).Fields())
+	assert.ElementsMatch([]string{"x", "y"}, decoded["set"].Value.(*set.Set).Elems())
+	assert.Equal([]zset.Entry{{Member: "m2", Score: math.Inf(-1)}, {Member: "m1", Score: 1.5}}, decoded["zset"].Value.(*zset.ZSet).Entries())
+}
+
+func TestDecodeCorrupted(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	buf := &bytes.Buffer{}
+	Encode(buf, []map[string]*db.DataNode{testNodes()})
+	data := buf.Bytes()
+
+	corrupted := append([]byte{}, data...)
+	corrupted[10] ^= 0xff
+	_, err := Decode(corrupted)
+	assert.Equal(ErrChecksumMismatch, err)
+
+	_, err = Decode(data[:len(data)-1])
+	assert.NotNil(err)
+
+	_, err = Decode([]byte("foo"))
+	assert.Equal(ErrInvalidSnapshot, err)
+}
+
+func TestEncodeDecode_Databases(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(Encode(buf, []map[string]*db.DataNode{{}, testNodes(), {}}))
+
+	dbs, err := Decode(buf.Bytes())
+	assert.Nil(err)
+	assert.Len(dbs, 2)
+	assert.Empty(dbs[0])
+	assert.Len(dbs[1], len(testNodes()))
+
+	d := db.NewDB()
+	_, err = LoadDatabases(d, dbs)
+	assert.NotNil(err)
+
+	d = db.NewDBs(2)[0]
+	n, err := LoadDatabases(d, dbs)
+	assert.Nil(err)
+	assert.Equal(len(testNodes()), n)
+	second, _ := d.Select(1)
+	assert.Equal(1, second.Exists("list"))
+	assert.Equal(0, d.Size())
+}
+
+func TestDecode_Version1(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	// version 1 files have no select op
+	buf := &bytes.Buffer{}
+	hash := crc64.New(crcTable)
+	e := &encoder{w: bufio.NewWriter(io.MultiWriter(buf, hash))}
+	e.w.WriteString(snapshotMagic)
+	e.writeUvarint(1)
+	e.writeNode("str", db.NewDataNode(db.TypeString, -1, "value"))
+	e.w.WriteByte(opEOF)
+	e.w.Flush()
+	binary.Write(buf, binary.LittleEndian, hash.Sum64())
+
+	dbs, err := Decode(buf.Bytes())
+	assert.Nil(err)
+	assert.Len(dbs, 1)
+	assert.Equal("value", dbs[0]["str"].Value)
+}
+
+func TestSaveLoad(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	conf := config.Get()
+	conf.Dir, conf.DbFilename = dir, "dump.kdb"
+	config.Set(conf)
+
+	d := db.NewDB()
+	for key, node := range testNodes() {
+		d.Set(key, node)
+	}
+	d.Set("expired", db.NewDataNode(db.TypeString, db.Now()-1, "v"))
+	d.Touch([]string{"str"})
+
+	assert.Nil(Save(d))
+	assert.Equal(int64(0), d.Dirty())
+
+	// no temporary files are left behind
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal([]string{SnapshotPath()}, files)
+
+	restored := db.NewDB()
+	n, err := Load(restored, SnapshotPath())
+	assert.Nil(err)
+	assert.Equal(6, n)
+	assert.Equal(1, restored.Exists("list"))
+	assert.Equal(0, restored.Exists("expired"))
+
+	n, err = Load(db.NewDB(), filepath.Join(dir, "nonexistent"))
+	assert.Nil(err)
+	assert.Equal(0, n)
+}
+
+func TestBackgroundSave_Failure(t *testing.T) {
+	assert := testifyAssert.New(t)
+	klogs.InitLoggers(config.AppConfig{LogType: "default"})
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	conf := config.Get()
+	conf.Dir, conf.DbFilename = filepath.Join(dir, "missing"), "dump.kdb"
+	config.Set(conf)
+
+	d := db.NewDB()
+	d.Lock()
+	assert.Nil(BackgroundSave(d))
+	d.Unlock()
+	for Saving() {
+		time.Sleep(time.Millisecond)
+	}
+	assert.False(lastFailure().IsZero())
+
+	// a successful save clears the failure
+	conf.Dir = dir
+	config.Set(conf)
+	d.Lock()
+	assert.Nil(Save(d))
+	d.Unlock()
+	assert.True(lastFailure().IsZero())
+}
+
+func TestParseSaveRules(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	rules, err := ParseSaveRules([]string{"900 1", " 60  10000 "})
+	assert.Nil(err)
+	assert.Equal([]SaveRule{{900, 1}, {60, 10000}}, rules)
+
+	for _, invalid := range []string{"900", "a 1", "900 b", "0 1", "1 2 3"} {
+		_, err = ParseSaveRules([]string{invalid})
+		assert.NotNil(err, invalid)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	for key, node := range testNodes() {
+		payload, err := Dump(node)
+		assert.Nil(err)
+
+		restored, err := Restore(payload, 42)
+		assert.Nil(err, key)
+		assert.Equal(node.Type, restored.Type)
+		assert.Equal(int64(42), restored.ExpiresAt)
+		if key == "str" {
+			assert.Equal("value", restored.Value)
+		}
+
+		payload[0]++
+		_, err = Restore(payload, -1)
+		assert.Equal(ErrInvalidDump, err)
+	}
+
+	_, err := Restore([]byte("short"), -1)
+	assert.Equal(ErrInvalidDump, err)
+}
@@ -34,6 +34,14 @@ func (e *ErrCastFailedToInt) Error() string {
 	return fmt.Sprintf("%s: error casting %v to int", ERR, e.Val)
 }
 
+type ErrCastFailedToFloat struct {
+	Val interface{}
+}
+
+func (e *ErrCastFailedToFloat) Error() string {
+	return fmt.Sprintf("%s: error casting %v to float", ERR, e.Val)
+}
+
 type ErrWrongType struct {
 }
 
@@ -57,6 +65,21 @@ func (e *ErrWrongNumberOfArgs) Error() string {
 	return fmt.Sprintf("%s: %s has wrong number of arguments", WRONGTYP, e.Cmd)
 }
 
+type ErrSyntax struct {
+}
+
+func (ErrSyntax) Error() string {
+	return fmt.Sprintf("%s: syntax error", ERR)
+}
+
+type ErrInvalidExpireTime struct {
+	Cmd string
+}
+
+func (e *ErrInvalidExpireTime) Error() string {
+	return fmt.Sprintf("%s: invalid expire time in %s", ERR, e.Cmd)
+}
+
 type ErrUnknownCommand struct {
 	Cmd string
 }
@@ -64,3 +87,108 @@ type ErrUnknownCommand struct {
 func (e *ErrUnknownCommand) Error() string {
 	return fmt.Sprintf("%s: unknown command %s", ERR, e.Cmd)
 }
+
+type ErrNestedMulti struct {
+}
+
+func (ErrNestedMulti) Error() string {
+	return fmt.Sprintf("%s: multi calls can not be nested", ERR)
+}
+
+type ErrWithoutMulti struct {
+	Cmd string
+}
+
+func (e *ErrWithoutMulti) Error() string {
+	return fmt.Sprintf("%s: %s without multi", ERR, e.Cmd)
+}
+
+type ErrInsideMulti struct {
+	Cmd string
+}
+
+func (e *ErrInsideMulti) Error() string {
+	return fmt.Sprintf("%s: %s inside multi is not allowed", ERR, e.Cmd)
+}
+
+type ErrExecAbort struct {
+}
+
+func (ErrExecAbort) Error() string {
+	return fmt.Sprintf("%s: transaction discarded because of previous errors", EXECABORT)
+}
+
+type ErrPubSubContext struct {
+	Cmd string
+}
+
+func (e *ErrPubSubContext) Error() string {
+	return fmt.Sprintf("%s: can't execute %s, only (p)subscribe / (p)unsubscribe / ping allowed in this context", ERR, e.Cmd)
+}
+
+type ErrReadOnlyReplica struct {
+}
+
+func (ErrReadOnlyReplica) Error() string {
+	return fmt.Sprintf("%s: you can't write against a read only replica", READONLY)
+}
+
+type ErrOOM struct {
+}
+
+func (ErrOOM) Error() string {
+	return fmt.Sprintf("%s: command not allowed when used memory > 'maxmemory'", OOM)
+}
+
+type ErrMoved struct {
+	Slot int
+	Addr string
+}
+
+func (e *ErrMoved) Error() string {
+	return fmt.Sprintf("%s %d %s", MOVED, e.Slot, e.Addr)
+}
+
+type ErrAsk struct {
+	Slot int
+	Addr string
+}
+
+func (e *ErrAsk) Error() string {
+	return fmt.Sprintf("%s %d %s", ASK, e.Slot, e.Addr)
+}
+
+type ErrCrossSlot struct {
+}
+
+func (ErrCrossSlot) Error() string {
+	return fmt.Sprintf("%s Keys in request don't hash to the same slot", CROSSSLOT)
+}
+
+type ErrClusterDown struct {
+}
+
+func (ErrClusterDown) Error() string {
+	return fmt.Sprintf("%s Hash slot not served", CLUSTERDOWN)
+}
+
+type ErrTryAgain struct {
+}
+
+func (ErrTryAgain) Error() string {
+	return fmt.Sprintf("%s Multiple keys request during rehashing of slot", TRYAGAIN)
+}
+
+type ErrBusyKey struct {
+}
+
+func (ErrBusyKey) Error() string {
+	return fmt.Sprintf("%s Target key name already exists.", BUSYKEY)
+}
+
+type ErrMaxClients struct {
+}
+
+func (ErrMaxClients) Error() string {
+	return fmt.Sprintf("%s max number of clients reached", ERR)
+}
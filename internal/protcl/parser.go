@@ -173,34 +173,33 @@ func parseBlkString(r *bufio.Reader) (string, error) {
 		return "", err
 	}
 
-	if llen > config.AppConf.MaxMultiBlkLength {
-		return "", ErrBufferExceeded
+	if llen < 0 {
+		return "", ErrParse
 	}
 
-	// we need to read exactly llen bytes from the stream
-	buf, err = r.ReadBytes('\n')
+	if llen > config.Get().MaxMultiBlkLength {
+		return "", ErrBufferExceeded
+	}
 
-	if err != nil {
+	// we need to read exactly llen bytes and the trailing CRLF, the string itself may hold CRLF
+	buf = make([]byte, llen+2)
+	if n, err := io.ReadFull(r, buf); err != nil {
 		if err == io.EOF {
 			return "", err
 		}
 
+		if err == io.ErrUnexpectedEOF {
+			return "", &ErrInvalidBlkStringLength{Excepted: llen, Given: n}
+		}
+
 		return "", ErrParse
 	}
 
-	// error is not EOF
-	err = hasCRLF(buf)
-	if err != nil {
+	if err = hasCRLF(buf); err != nil {
 		return "", err
 	}
 
-	str := buf[:len(buf)-2]
-
-	if len(str) != llen {
-		return "", &ErrInvalidBlkStringLength{Excepted: llen, Given: len(str)}
-	}
-
-	return string(str), nil
+	return string(buf[:llen]), nil
 }
 
 // does not return EOF as error
@@ -38,10 +38,23 @@ const (
 )
 
 const (
-	WRONGTYP = "WRONGTYP"
-	ERR      = "ERR"
+	WRONGTYP  = "WRONGTYP"
+	ERR       = "ERR"
+	EXECABORT = "EXECABORT"
+	READONLY  = "READONLY"
+	OOM       = "OOM"
+
+	// cluster errors are replied without a separator, cluster aware clients parse them
+	MOVED       = "MOVED"
+	ASK         = "ASK"
+	CROSSSLOT   = "CROSSSLOT"
+	CLUSTERDOWN = "CLUSTERDOWN"
+	TRYAGAIN    = "TRYAGAIN"
+	BUSYKEY     = "BUSYKEY"
 )
 
+var respPrefixes = []string{WRONGTYP, ERR, EXECABORT, READONLY, OOM, MOVED, ASK, CROSSSLOT, CLUSTERDOWN, TRYAGAIN, BUSYKEY}
+
 type Reply interface {
 	Reply() string
 }
@@ -56,8 +69,10 @@ func NewMessage(rep Reply, err error) *Message {
 }
 
 func hasRespPrefix(str string) bool {
-	if strings.HasPrefix(str, WRONGTYP) || strings.HasPrefix(str, ERR) {
-		return true
+	for _, prefix := range respPrefixes {
+		if strings.HasPrefix(str, prefix) {
+			return true
+		}
 	}
 
 	return false
@@ -130,6 +145,19 @@ func (rep *BulkStringReply) Reply() string {
 	return fmt.Sprintf("$%d\r\n%s\r\n", len(rep.Value), rep.Value)
 }
 
+func NewErrorReply(err error) *ErrorReply {
+	return &ErrorReply{Err: err}
+}
+
+// ErrorReply represents an error as a reply, used for errors nested inside arrays
+type ErrorReply struct {
+	Err error
+}
+
+func (rep *ErrorReply) Reply() string {
+	return RespError(rep.Err)
+}
+
 type ArrayReply struct {
 	Elems []Reply
 	Nil   bool
@@ -155,3 +183,22 @@ func (rep *ArrayReply) Reply() string {
 
 	return builder.String()
 }
+
+func NewMultiReply(replies []Reply) *MultiReply {
+	return &MultiReply{Replies: replies}
+}
+
+// MultiReply represents several replies sent back to back for a single command
+type MultiReply struct {
+	Replies []Reply
+}
+
+func (rep *MultiReply) Reply() string {
+	builder := strings.Builder{}
+
+	for _, re := range rep.Replies {
+		builder.WriteString(re.Reply())
+	}
+
+	return builder.String()
+}
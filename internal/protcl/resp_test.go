@@ -77,3 +77,20 @@ func TestArrayReply_Reply(t *testing.T) {
 	targetRep := "*2\r\n*2\r\n$3\r\nfoo\r\n:1\r\n*1\r\n+bar\r\n"
 	assert.Equal(targetRep, arrOfArrs.Reply())
 }
+
+func TestErrorReply_Reply(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	rep := NewErrorReply(&ErrUnknownCommand{Cmd: "foo"})
+	assert.Equal("-ERR: unknown command foo\r\n", rep.Reply())
+
+	arr := NewArrayReply(false, []Reply{NewIntegerReply(1), rep})
+	assert.Equal("*2\r\n:1\r\n-ERR: unknown command foo\r\n", arr.Reply())
+}
+
+func TestMultiReply_Reply(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	rep := NewMultiReply([]Reply{NewIntegerReply(1), NewSimpleStringReply("foo")})
+	assert.Equal(":1\r\n+foo\r\n", rep.Reply())
+}
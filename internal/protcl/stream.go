@@ -0,0 +1,235 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package protcl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteCommand writes cmd and args to w as a RESP array of bulk strings
+func WriteCommand(w io.Writer, cmd string, args []string) {
+	fmt.Fprintf(w, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd)
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+const (
+	// maxStreamBulkLength is the largest bulk string read by StreamReader, the default limit of clients
+	maxStreamBulkLength = 512 * 1024 * 1024
+	// maxStreamArrayLength is the largest number of elements of an array read by StreamReader
+	maxStreamArrayLength = 1024 * 1024
+)
+
+// StreamReader reads a stream of commands written by WriteCommand, such as the append only file or
+// the replication stream. Unlike Reader, bulk strings are binary safe. Lengths are read from files and
+// peers so they are limited to maxStreamBulkLength and maxStreamArrayLength, ErrBufferExceeded is
+// returned for larger ones
+type StreamReader struct {
+	r      *bufio.Reader
+	offset int64 // bytes of the complete commands read
+	read   int64 // bytes read so far
+}
+
+// NewStreamReader returns a StreamReader reading from r, a *bufio.Reader is used as is
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{r: bufio.NewReader(r)}
+}
+
+// Offset returns the number of bytes of the complete commands read
+func (r *StreamReader) Offset() int64 {
+	return r.offset
+}
+
+// Pending returns the number of bytes read after the last complete command
+func (r *StreamReader) Pending() int64 {
+	return r.read - r.offset
+}
+
+func (r *StreamReader) readLine() (string, error) {
+	line, err := r.r.ReadString('\n')
+	r.read += int64(len(line))
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", ErrParse
+	}
+
+	return line[:len(line)-2], nil
+}
+
+func (r *StreamReader) readLength(prefix byte) (int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(line) < 2 || line[0] != prefix {
+		return 0, ErrParse
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return 0, ErrParse
+	}
+
+	return n, nil
+}
+
+// ReadCommand reads the next command as its name followed by the arguments. io.EOF is returned
+// at the end of the stream and io.ErrUnexpectedEOF if the stream ends in the middle of a command
+func (r *StreamReader) ReadCommand() ([]string, error) {
+	start := r.read
+
+	n, err := r.readLength(REP_ARR)
+	if err == io.EOF && r.read == start {
+		return nil, io.EOF
+	}
+	if err == nil && n == 0 {
+		return nil, ErrInvalidCommand
+	}
+	if n > maxStreamArrayLength {
+		return nil, ErrBufferExceeded
+	}
+
+	strs := make([]string, n)
+	for i := 0; i < n && err == nil; i++ {
+		var size int
+		if size, err = r.readLength(REP_BULKSTRING); err != nil {
+			break
+		}
+		if size > maxStreamBulkLength {
+			return nil, ErrBufferExceeded
+		}
+
+		buf := make([]byte, size+2)
+		read, rerr := io.ReadFull(r.r, buf)
+		r.read += int64(read)
+		if rerr != nil {
+			err = rerr
+			break
+		}
+
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, ErrParse
+		}
+		strs[i] = string(buf[:size])
+	}
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.offset = r.read
+	return strs, nil
+}
+
+// ReplyError is an error replied by a server
+type ReplyError struct {
+	Msg string
+}
+
+func (e *ReplyError) Error() string {
+	return e.Msg
+}
+
+// ReadReply reads the next reply of a server. Strings are returned as string, integers as int64, arrays
+// as []interface{} and nil bulk strings or arrays as nil. An error reply is returned as a *ReplyError
+func (r *StreamReader) ReadReply() (interface{}, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 {
+		return nil, ErrParse
+	}
+
+	switch line[0] {
+	case REP_SIMPLE_STRING:
+		return line[1:], nil
+	case REP_ERROR:
+		return nil, &ReplyError{Msg: line[1:]}
+	case REP_INTEGER:
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrParse
+		}
+
+		return n, nil
+	case REP_BULKSTRING:
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < -1 {
+			return nil, ErrParse
+		}
+
+		if size == -1 {
+			return nil, nil
+		}
+		if size > maxStreamBulkLength {
+			return nil, ErrBufferExceeded
+		}
+
+		buf := make([]byte, size+2)
+		read, err := io.ReadFull(r.r, buf)
+		r.read += int64(read)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(buf[:size]), nil
+	case REP_ARR:
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, ErrParse
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+		if n > maxStreamArrayLength {
+			return nil, ErrBufferExceeded
+		}
+
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = r.ReadReply(); err != nil {
+				return nil, err
+			}
+		}
+
+		return elems, nil
+	}
+
+	return nil, ErrParse
+}
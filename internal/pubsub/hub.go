@@ -0,0 +1,220 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package pubsub
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+// Subscriber receives the messages published to channels it is subscribed to,
+// Push must not block as messages are published while the database is locked
+type Subscriber interface {
+	Push(reply protcl.Reply)
+}
+
+// Hub keeps track of channel and pattern subscriptions and delivers published messages
+type Hub struct {
+	channels map[string]map[Subscriber]struct{}
+	patterns map[string]map[Subscriber]struct{}
+	mux      sync.RWMutex
+}
+
+// Default is the hub shared by all connections of the server
+var Default = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{
+		channels: make(map[string]map[Subscriber]struct{}),
+		patterns: make(map[string]map[Subscriber]struct{}),
+	}
+}
+
+func add(m map[string]map[Subscriber]struct{}, key string, sub Subscriber) bool {
+	subs, ok := m[key]
+	if !ok {
+		subs = make(map[Subscriber]struct{})
+		m[key] = subs
+	}
+
+	if _, ok := subs[sub]; ok {
+		return false
+	}
+
+	subs[sub] = struct{}{}
+	return true
+}
+
+func remove(m map[string]map[Subscriber]struct{}, key string, sub Subscriber) bool {
+	subs, ok := m[key]
+	if !ok {
+		return false
+	}
+
+	if _, ok := subs[sub]; !ok {
+		return false
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(m, key)
+	}
+
+	return true
+}
+
+// Subscribe subscribes sub to channel, returns false if it was already subscribed
+func (h *Hub) Subscribe(sub Subscriber, channel string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	return add(h.channels, channel, sub)
+}
+
+// Unsubscribe unsubscribes sub from channel, returns false if it was not subscribed
+func (h *Hub) Unsubscribe(sub Subscriber, channel string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	return remove(h.channels, channel, sub)
+}
+
+// PSubscribe subscribes sub to every channel matching the glob style pattern
+func (h *Hub) PSubscribe(sub Subscriber, pattern string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	return add(h.patterns, pattern, sub)
+}
+
+// PUnsubscribe removes a subscription made with PSubscribe
+func (h *Hub) PUnsubscribe(sub Subscriber, pattern string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	return remove(h.patterns, pattern, sub)
+}
+
+// Publish delivers message to the subscribers of channel and to the subscribers
+// of patterns matching the channel, returns the number of receivers
+func (h *Hub) Publish(channel, message string) int {
+	type delivery struct {
+		sub   Subscriber
+		reply protcl.Reply
+	}
+
+	var deliveries []delivery
+
+	h.mux.RLock()
+	if subs, ok := h.channels[channel]; ok {
+		reply := NewMessageReply(channel, message)
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{sub, reply})
+		}
+	}
+
+	for pattern, subs := range h.patterns {
+		if !util.GlobMatch(pattern, channel) {
+			continue
+		}
+
+		reply := NewPMessageReply(pattern, channel, message)
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{sub, reply})
+		}
+	}
+	h.mux.RUnlock()
+
+	// push outside of the lock so subscribing is not held up by deliveries
+	for _, d := range deliveries {
+		d.sub.Push(d.reply)
+	}
+
+	return len(deliveries)
+}
+
+// Channels returns the active channels matching the pattern, every channel is returned for an empty pattern
+func (h *Hub) Channels(pattern string) []string {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	channels := make([]string, 0)
+	for channel := range h.channels {
+		if pattern == "" || util.GlobMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+// NumSub returns the number of subscribers of channel, pattern subscribers are not counted
+func (h *Hub) NumSub(channel string) int {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	return len(h.channels[channel])
+}
+
+// NumPat returns the number of unique patterns subscribed
+func (h *Hub) NumPat() int {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	return len(h.patterns)
+}
+
+// NewMessageReply creates the reply pushed to subscribers of a channel
+func NewMessageReply(channel, message string) protcl.Reply {
+	return protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewBulkStringReply(false, "message"),
+		protcl.NewBulkStringReply(false, channel),
+		protcl.NewBulkStringReply(false, message),
+	})
+}
+
+// NewPMessageReply creates the reply pushed to subscribers of a pattern
+func NewPMessageReply(pattern, channel, message string) protcl.Reply {
+	return protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewBulkStringReply(false, "pmessage"),
+		protcl.NewBulkStringReply(false, pattern),
+		protcl.NewBulkStringReply(false, channel),
+		protcl.NewBulkStringReply(false, message),
+	})
+}
+
+// NewSubscriptionReply creates the reply for (un)subscribe commands, count is the number of
+// subscriptions the client has after the command. An empty name is replied as nil
+func NewSubscriptionReply(kind, name string, count int) protcl.Reply {
+	return protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewBulkStringReply(false, kind),
+		protcl.NewBulkStringReply(name == "", name),
+		protcl.NewIntegerReply(count),
+	})
+}
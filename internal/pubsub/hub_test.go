@@ -0,0 +1,84 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package pubsub
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+type recorder struct {
+	replies []string
+}
+
+func (r *recorder) Push(reply protcl.Reply) {
+	r.replies = append(r.replies, reply.Reply())
+}
+
+func TestHub_Publish(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hub := NewHub()
+	a, b := &recorder{}, &recorder{}
+
+	assert.True(hub.Subscribe(a, "news.tech"))
+	assert.False(hub.Subscribe(a, "news.tech"))
+	assert.True(hub.PSubscribe(b, "news.*"))
+
+	assert.Equal(2, hub.Publish("news.tech", "hi"))
+	assert.Equal(1, hub.Publish("news.art", "hello"))
+	assert.Equal(0, hub.Publish("weather", "sunny"))
+
+	assert.Equal([]string{"*3\r\n$7\r\nmessage\r\n$9\r\nnews.tech\r\n$2\r\nhi\r\n"}, a.replies)
+	assert.Equal([]string{
+		"*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$9\r\nnews.tech\r\n$2\r\nhi\r\n",
+		"*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$8\r\nnews.art\r\n$5\r\nhello\r\n",
+	}, b.replies)
+
+	assert.True(hub.Unsubscribe(a, "news.tech"))
+	assert.False(hub.Unsubscribe(a, "news.tech"))
+	assert.True(hub.PUnsubscribe(b, "news.*"))
+	assert.Equal(0, hub.Publish("news.tech", "hi"))
+}
+
+func TestHub_Introspection(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hub := NewHub()
+	a, b := &recorder{}, &recorder{}
+
+	hub.Subscribe(a, "news.tech")
+	hub.Subscribe(b, "news.tech")
+	hub.Subscribe(b, "weather")
+	hub.PSubscribe(a, "news.*")
+	hub.PSubscribe(b, "news.*")
+
+	assert.Equal([]string{"news.tech", "weather"}, hub.Channels(""))
+	assert.Equal([]string{"news.tech"}, hub.Channels("news.*"))
+	assert.Equal(2, hub.NumSub("news.tech"))
+	assert.Equal(0, hub.NumSub("nonexistent"))
+	assert.Equal(1, hub.NumPat())
+}
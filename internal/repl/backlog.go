@@ -0,0 +1,83 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repl
+
+// Backlog keeps the most recent bytes of the replication stream in a circular buffer, so that
+// replicas which were disconnected for a short time can continue from their offset
+type Backlog struct {
+	buf    []byte
+	next   int   // index where the next byte is written
+	size   int   // number of bytes held
+	offset int64 // replication offset of the next byte
+}
+
+// NewBacklog returns a backlog holding at most capacity bytes, starting at offset
+func NewBacklog(capacity int, offset int64) *Backlog {
+	return &Backlog{buf: make([]byte, capacity), offset: offset}
+}
+
+// Offset returns the replication offset after the last written byte
+func (b *Backlog) Offset() int64 {
+	return b.offset
+}
+
+// Write appends p to the backlog, overwriting the oldest bytes when it is full
+func (b *Backlog) Write(p []byte) (int, error) {
+	n := len(p)
+	b.offset += int64(n)
+
+	capacity := len(b.buf)
+	if n > capacity {
+		p = p[n-capacity:]
+	}
+
+	for len(p) > 0 {
+		copied := copy(b.buf[b.next:], p)
+		p = p[copied:]
+		b.next = (b.next + copied) % capacity
+		b.size += copied
+	}
+
+	if b.size > capacity {
+		b.size = capacity
+	}
+
+	return n, nil
+}
+
+// ReadFrom returns the bytes written after offset, false is returned if they are not held anymore
+func (b *Backlog) ReadFrom(offset int64) ([]byte, bool) {
+	if offset > b.offset || offset < b.offset-int64(b.size) {
+		return nil, false
+	}
+
+	n := int(b.offset - offset)
+	data := make([]byte, n)
+	start := (b.next - n + len(b.buf)) % len(b.buf)
+	copied := copy(data, b.buf[start:])
+	copy(data[copied:], b.buf[:n-copied])
+
+	return data, true
+}
@@ -0,0 +1,62 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repl
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestBacklog(t *testing.T) {
+	assert := testifyAssert.New(t)
+	b := NewBacklog(8, 100)
+
+	data, ok := b.ReadFrom(100)
+	assert.True(ok)
+	assert.Len(data, 0)
+
+	b.Write([]byte("abcde"))
+	assert.Equal(int64(105), b.Offset())
+	data, ok = b.ReadFrom(102)
+	assert.True(ok)
+	assert.Equal("cde", string(data))
+
+	// older bytes are overwritten once the backlog is full
+	b.Write([]byte("fghij"))
+	_, ok = b.ReadFrom(101)
+	assert.False(ok)
+	data, ok = b.ReadFrom(102)
+	assert.True(ok)
+	assert.Equal("cdefghij", string(data))
+
+	b.Write([]byte("0123456789"))
+	data, ok = b.ReadFrom(112)
+	assert.True(ok)
+	assert.Equal("23456789", string(data))
+
+	_, ok = b.ReadFrom(121)
+	assert.False(ok)
+}
@@ -0,0 +1,331 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/persist"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// a replica which does not keep up is disconnected once this many bytes are waiting to be sent to it
+const maxLinkPending = 256 * 1024 * 1024
+
+// states of a replica connected to the primary
+const (
+	LinkSync   = "sync"   // the snapshot of the full resynchronization is being sent
+	LinkOnline = "online" // commands are streamed to the replica
+)
+
+// NewReplicationID returns a random replication id of 40 hex characters
+func NewReplicationID() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+// Primary streams the commands which modified the key space to the connected replicas. It is registered
+// as a feed of the database, every byte streamed advances the replication offset
+type Primary struct {
+	id         string
+	prevID     string // id before the last promotion, accepted up to prevOffset
+	prevOffset int64
+	backlog    *Backlog
+	links      map[*Link]struct{}
+	db         int // database selected by the last command, -1 selects it before the next one
+	mux        sync.Mutex
+}
+
+// NewPrimary returns a primary with a new replication id and a backlog of backlogSize bytes
+func NewPrimary(backlogSize int) *Primary {
+	if backlogSize < 1 {
+		backlogSize = 1
+	}
+
+	return &Primary{
+		id:      NewReplicationID(),
+		backlog: NewBacklog(backlogSize, 0),
+		links:   make(map[*Link]struct{}),
+		db:      -1,
+	}
+}
+
+// ID returns the replication id
+func (p *Primary) ID() string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.id
+}
+
+// Offset returns the replication offset
+func (p *Primary) Offset() int64 {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.backlog.Offset()
+}
+
+// Feed writes a command applied to database db to the backlog and to the connected replicas, preceded
+// by SELECT when db is not the selected database. A SELECT fed by itself, which is how a replica passes
+// on the SELECT of its primary, is written as is. Implements db.Feed
+func (p *Primary) Feed(db int, cmd string, args []string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	buf := &bytes.Buffer{}
+	if db != p.db && cmd != "select" {
+		protcl.WriteCommand(buf, "select", []string{strconv.Itoa(db)})
+	}
+	p.db = db
+	protcl.WriteCommand(buf, cmd, args)
+	data := buf.Bytes()
+
+	p.backlog.Write(data)
+	for link := range p.links {
+		if !link.send(data) {
+			delete(p.links, link)
+		}
+	}
+}
+
+// Sync attaches a replica which asked to continue the replication stream of id from offset. The replica
+// continues from its offset when the bytes after it are in the backlog, otherwise it is sent a snapshot of
+// the databases of d followed by the commands applied after the snapshot was taken, which start with
+// SELECT. Replies are written to w, which is closed when the link is detached
+func (p *Primary) Sync(d *db.DB, w io.WriteCloser, addr, id string, offset int64) *Link {
+	d.Lock()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer d.Unlock()
+
+	link := newLink(w, addr, offset)
+	p.links[link] = struct{}{}
+
+	if id == p.id || (id == p.prevID && offset <= p.prevOffset) {
+		if data, ok := p.backlog.ReadFrom(offset); ok {
+			link.state = LinkOnline
+			go link.run(p, []byte("+CONTINUE\r\n"), data)
+			return link
+		}
+	}
+
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n", p.id, p.backlog.Offset())
+	link.ack = p.backlog.Offset()
+	dbs := d.SnapshotAll()
+	p.db = -1
+
+	go func() {
+		buf := &bytes.Buffer{}
+		if err := persist.Encode(buf, dbs); err != nil {
+			p.Detach(link)
+			link.w.Close()
+			return
+		}
+
+		link.run(p, []byte(header), []byte(fmt.Sprintf("$%d\r\n", buf.Len())), buf.Bytes())
+	}()
+
+	return link
+}
+
+// Detach stops streaming to link
+func (p *Primary) Detach(link *Link) {
+	p.mux.Lock()
+	delete(p.links, link)
+	p.mux.Unlock()
+
+	link.close()
+}
+
+// Reset adopts the replication id and the offset of a new primary after a full resynchronization,
+// replicas attached to p are disconnected since their data does not match anymore
+func (p *Primary) Reset(id string, offset int64) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.id = id
+	p.prevID, p.prevOffset = "", 0
+	p.backlog = NewBacklog(len(p.backlog.buf), offset)
+	p.db = -1
+
+	for link := range p.links {
+		link.close()
+		delete(p.links, link)
+	}
+}
+
+// Promote starts a new replication history when a replica becomes a primary. Replicas of the old
+// primary can still continue from the backlog as long as they did not go beyond the promotion
+func (p *Primary) Promote() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.prevID, p.prevOffset = p.id, p.backlog.Offset()
+	p.id = NewReplicationID()
+}
+
+// Links returns the state of the attached replicas
+func (p *Primary) Links() []LinkInfo {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	infos := make([]LinkInfo, 0, len(p.links))
+	for link := range p.links {
+		infos = append(infos, link.info())
+	}
+
+	return infos
+}
+
+// LinkInfo describes a replica attached to the primary
+type LinkInfo struct {
+	Addr    string
+	Port    string
+	State   string
+	Offset  int64         // offset acknowledged by the replica
+	LastAck time.Duration // time since the last acknowledgement
+}
+
+// Link is the connection of the primary to a replica
+type Link struct {
+	w       io.WriteCloser
+	addr    string
+	port    string
+	state   string
+	ack     int64
+	lastAck time.Time
+	pending [][]byte
+	size    int // bytes pending
+	closed  bool
+	cond    *sync.Cond
+	mux     sync.Mutex
+}
+
+func newLink(w io.WriteCloser, addr string, offset int64) *Link {
+	link := &Link{w: w, addr: addr, state: LinkSync, ack: offset, lastAck: time.Now()}
+	link.cond = sync.NewCond(&link.mux)
+
+	return link
+}
+
+// SetPort records the port the replica listens to, as told by REPLCONF listening-port
+func (l *Link) SetPort(port string) {
+	l.mux.Lock()
+	l.port = port
+	l.mux.Unlock()
+}
+
+// Ack records the offset processed by the replica
+func (l *Link) Ack(offset int64) {
+	l.mux.Lock()
+	l.ack = offset
+	l.lastAck = time.Now()
+	l.mux.Unlock()
+}
+
+func (l *Link) info() LinkInfo {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return LinkInfo{Addr: l.addr, Port: l.port, State: l.state, Offset: l.ack, LastAck: time.Since(l.lastAck)}
+}
+
+// send queues data to be written to the replica, false is returned if the link is closed
+func (l *Link) send(data []byte) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.closed {
+		return false
+	}
+
+	if l.size+len(data) > maxLinkPending {
+		l.closed = true
+		l.cond.Signal()
+		return false
+	}
+
+	l.pending = append(l.pending, data)
+	l.size += len(data)
+	l.cond.Signal()
+
+	return true
+}
+
+func (l *Link) close() {
+	l.mux.Lock()
+	l.closed = true
+	l.cond.Signal()
+	l.mux.Unlock()
+}
+
+// run writes first and then the queued data to the replica until the link is closed
+func (l *Link) run(p *Primary, first ...[]byte) {
+	defer l.w.Close()
+
+	for _, data := range first {
+		if _, err := l.w.Write(data); err != nil {
+			p.Detach(l)
+			return
+		}
+	}
+
+	l.mux.Lock()
+	l.state = LinkOnline
+	for {
+		for len(l.pending) == 0 && !l.closed {
+			l.cond.Wait()
+		}
+
+		if l.closed {
+			l.mux.Unlock()
+			return
+		}
+
+		pending := l.pending
+		l.pending, l.size = nil, 0
+		l.mux.Unlock()
+
+		for _, data := range pending {
+			if _, err := l.w.Write(data); err != nil {
+				p.Detach(l)
+				return
+			}
+		}
+
+		l.mux.Lock()
+	}
+}
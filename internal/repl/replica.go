@@ -0,0 +1,325 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persist"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// states of the connection of a replica to its primary
+const (
+	ReplicaConnect    = "connect"    // waiting to connect to the primary
+	ReplicaConnecting = "connecting" // connected, the handshake is in progress
+	ReplicaSync       = "sync"       // receiving the snapshot of a full resynchronization
+	ReplicaConnected  = "connected"  // applying the commands streamed by the primary
+)
+
+const (
+	// how often the processed offset is acknowledged to the primary
+	replicaAckInterval = time.Second
+	// time to wait before connecting again after the connection to the primary was lost
+	replicaRetryInterval = time.Second
+	// maximum time to establish the connection to the primary
+	replicaDialTimeout = 5 * time.Second
+)
+
+// Replica replicates the key space of a primary. The snapshot sent by the primary replaces the key
+// space and the commands streamed afterwards are applied with exec. Since the applied commands are
+// propagated again, the replica keeps the replication id and offset of its primary in p, which lets
+// replicas of the replica continue from it
+type Replica struct {
+	Host          string
+	Port          string
+	listeningPort int
+	d             *db.DB
+	primary       *Primary
+	exec          func(cmd string, args []string)
+	state         string
+	offset        int64 // replication offset processed
+	conn          net.Conn
+	stopped       bool
+	mux           sync.Mutex
+}
+
+// NewReplica returns a replica of the primary at host and port, listeningPort is the port of this server
+func NewReplica(host, port string, listeningPort int, d *db.DB, p *Primary, exec func(cmd string, args []string)) *Replica {
+	return &Replica{
+		Host:          host,
+		Port:          port,
+		listeningPort: listeningPort,
+		d:             d,
+		primary:       p,
+		exec:          exec,
+		state:         ReplicaConnect,
+	}
+}
+
+// Start connects to the primary in the background, the connection is retried until Stop is called
+func (r *Replica) Start() {
+	go r.run()
+}
+
+// Stop disconnects from the primary
+func (r *Replica) Stop() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.stopped = true
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+// State returns the state of the connection and the replication offset processed
+func (r *Replica) State() (string, int64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.state, r.offset
+}
+
+func (r *Replica) setState(state string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.state = state
+	return !r.stopped
+}
+
+func (r *Replica) run() {
+	addr := net.JoinHostPort(r.Host, r.Port)
+
+	for r.setState(ReplicaConnecting) {
+		err := r.replicate(addr)
+		if !r.setState(ReplicaConnect) {
+			return
+		}
+
+		klogs.Logger.Warnf("replication from %s stopped: %s, retrying in %s", addr, err, replicaRetryInterval)
+		time.Sleep(replicaRetryInterval)
+	}
+}
+
+// replicate synchronizes with the primary at addr and applies the streamed commands until the connection is lost
+func (r *Replica) replicate(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, replicaDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.mux.Lock()
+	if r.stopped {
+		r.mux.Unlock()
+		return nil
+	}
+	r.conn = conn
+	r.mux.Unlock()
+
+	br := bufio.NewReader(conn)
+	if err := r.handshake(conn, br); err != nil {
+		return err
+	}
+
+	if !r.setState(ReplicaConnected) {
+		return nil
+	}
+	klogs.Logger.Infof("replicating from %s", addr)
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.ack(conn, done)
+
+	stream := protcl.NewStreamReader(br)
+	r.mux.Lock()
+	base := r.offset
+	r.mux.Unlock()
+
+	for {
+		strs, err := stream.ReadCommand()
+		if err != nil {
+			return err
+		}
+
+		r.exec(strings.ToLower(strs[0]), strs[1:])
+
+		r.mux.Lock()
+		r.offset = base + stream.Offset()
+		r.mux.Unlock()
+	}
+}
+
+// handshake asks the primary to continue the replication stream from the offset of the replica,
+// a snapshot is loaded if the primary decides to do a full resynchronization
+func (r *Replica) handshake(conn net.Conn, br *bufio.Reader) error {
+	if _, err := request(conn, br, "ping"); err != nil {
+		return err
+	}
+
+	if _, err := request(conn, br, "replconf", "listening-port", strconv.Itoa(r.listeningPort)); err != nil {
+		return err
+	}
+
+	id, offset := r.primary.ID(), r.primary.Offset()
+	reply, err := request(conn, br, "psync", id, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 1 && fields[0] == "CONTINUE":
+		r.mux.Lock()
+		r.offset = offset
+		r.mux.Unlock()
+
+		return nil
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset in %q", reply)
+		}
+
+		return r.fullSync(br, fields[1], offset)
+	}
+
+	return fmt.Errorf("unexpected reply to psync %q", reply)
+}
+
+// fullSync replaces the key space with the snapshot sent by the primary
+func (r *Replica) fullSync(br *bufio.Reader, id string, offset int64) error {
+	if !r.setState(ReplicaSync) {
+		return nil
+	}
+
+	line, err := readLine(br)
+	if err != nil {
+		return err
+	}
+
+	size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+	if err != nil || !strings.HasPrefix(line, "$") || size < 0 {
+		return fmt.Errorf("invalid snapshot length %q", line)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return err
+	}
+
+	dbs, err := persist.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	r.d.Lock()
+	defer r.d.Unlock()
+
+	for i := 0; i < r.d.Databases(); i++ {
+		d, _ := r.d.Select(i)
+		d.Flush()
+	}
+
+	loaded, err := persist.LoadDatabases(r.d, dbs)
+	if err != nil {
+		return err
+	}
+
+	r.primary.Reset(id, offset)
+	r.mux.Lock()
+	r.offset = offset
+	r.mux.Unlock()
+
+	// the append only file does not hold the loaded keys, so it is rebuilt from them
+	if err := persist.RewriteAppendOnly(r.d); err != nil && err != persist.ErrAppendOnlyDisabled {
+		klogs.Logger.Errorf("error rewriting the append only file after a full resynchronization: %s", err)
+	}
+
+	klogs.Logger.Infof("full resynchronization of %d keys from replication id %s at offset %d", loaded, id, offset)
+
+	return nil
+}
+
+// ack acknowledges the processed offset to the primary periodically until done is closed
+func (r *Replica) ack(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(replicaAckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, offset := r.State()
+			protcl.WriteCommand(conn, "replconf", []string{"ack", strconv.FormatInt(offset, 10)})
+		}
+	}
+}
+
+// request sends a command to the primary and reads a single line reply
+func request(conn net.Conn, br *bufio.Reader, cmd string, args ...string) (string, error) {
+	protcl.WriteCommand(conn, cmd, args)
+
+	reply, err := readLine(br)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(reply, "-") {
+		return "", fmt.Errorf("%s replied to %s with %s", conn.RemoteAddr(), cmd, reply[1:])
+	}
+
+	if !strings.HasPrefix(reply, "+") {
+		return "", fmt.Errorf("unexpected reply to %s %q", cmd, reply)
+	}
+
+	return reply[1:], nil
+}
+
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("protocol error, line does not end with CRLF")
+	}
+
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
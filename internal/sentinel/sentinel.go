@@ -0,0 +1,448 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+const (
+	// how often the instances are pinged and the view is sent to the other sentinels
+	pingInterval = time.Second
+	// maximum time to exchange a command with an instance or another sentinel
+	callTimeout = time.Second
+)
+
+var ErrNoGoodReplica = errors.New("no suitable replica to promote")
+
+// Config describes the primary monitored by a sentinel
+type Config struct {
+	Name            string        // name the primary is known by to clients
+	Host            string        // host of the primary
+	Port            string        // port of the primary
+	Quorum          int           // number of sentinels which must agree the primary is down
+	DownAfter       time.Duration // time after which an instance which does not answer is down
+	FailoverTimeout time.Duration // time to wait before a failover is attempted again
+	Sentinels       []string      // addresses of the other sentinels
+}
+
+// Instance is a primary or a replica monitored by the sentinel
+type Instance struct {
+	Host       string
+	Port       string
+	Role       string    // role reported by the instance
+	Offset     int64     // replication offset reported by the instance
+	MasterHost string    // primary followed by a replica
+	MasterPort string    // port of the primary followed by a replica
+	PongAt     time.Time // last time the instance answered a ping
+	Down       bool      // the instance did not answer for the down after period
+}
+
+func (i *Instance) Addr() string {
+	return net.JoinHostPort(i.Host, i.Port)
+}
+
+// Sentinel monitors a primary and its replicas. When the primary is down for a quorum of sentinels, one of
+// them is elected with a vote in a new epoch, it promotes the replica with the highest offset and points
+// the other replicas to it. The epoch of the promotion is sent to the other sentinels, so the most recent
+// promotion wins
+type Sentinel struct {
+	id           string
+	config       Config
+	master       *Instance
+	replicas     map[string]*Instance
+	odown        bool      // the primary is down for a quorum of sentinels
+	currentEpoch uint64    // highest epoch seen
+	configEpoch  uint64    // epoch of the promotion of the current primary
+	leader       string    // sentinel voted for in leaderEpoch
+	leaderEpoch  uint64    // last epoch a vote was given in
+	failoverAt   time.Time // start of the last failover attempt or vote
+	mux          sync.Mutex
+}
+
+func newID() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+// New returns a sentinel monitoring the primary described by config
+func New(config Config) *Sentinel {
+	return &Sentinel{
+		id:       newID(),
+		config:   config,
+		master:   &Instance{Host: config.Host, Port: config.Port, PongAt: time.Now()},
+		replicas: make(map[string]*Instance),
+	}
+}
+
+// ID returns the run id of the sentinel
+func (s *Sentinel) ID() string {
+	return s.id
+}
+
+// Master returns the address of the current primary
+func (s *Sentinel) Master() (host, port string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.master.Host, s.master.Port
+}
+
+// Run monitors the instances periodically
+func (s *Sentinel) Run() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.tick()
+	}
+}
+
+// tick probes the instances, fixes the replicas following a wrong primary, sends the view to the other
+// sentinels and starts a failover when the primary is down
+func (s *Sentinel) tick() {
+	s.mux.Lock()
+	instances := []*Instance{s.master}
+	for _, r := range s.replicas {
+		instances = append(instances, r)
+	}
+	s.mux.Unlock()
+
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func(inst *Instance) {
+			defer wg.Done()
+			s.probe(inst)
+		}(inst)
+	}
+	wg.Wait()
+
+	s.reconfigure()
+	s.hello()
+	s.checkFailover()
+}
+
+// call sends a command to the instance or sentinel at addr and reads its reply
+func call(addr, cmd string, args ...string) (interface{}, error) {
+	conn, err := net.DialTimeout("tcp", addr, callTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	protcl.WriteCommand(conn, cmd, args)
+
+	return protcl.NewStreamReader(conn).ReadReply()
+}
+
+// probe pings inst and asks for its role, replicas of the primary are discovered from its role
+func (s *Sentinel) probe(inst *Instance) {
+	_, err := call(inst.Addr(), "ping")
+
+	s.mux.Lock()
+	switch {
+	case err == nil:
+		inst.PongAt = time.Now()
+		if inst.Down {
+			inst.Down = false
+			klogs.Logger.Infof("-sdown %s", inst.Addr())
+		}
+	case !inst.Down && time.Since(inst.PongAt) > s.config.DownAfter:
+		inst.Down = true
+		klogs.Logger.Warnf("+sdown %s", inst.Addr())
+	}
+	s.mux.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	reply, err := call(inst.Addr(), "role")
+	fields, ok := reply.([]interface{})
+	if err != nil || !ok || len(fields) == 0 {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch role, _ := fields[0].(string); {
+	case role == "master" && len(fields) == 3:
+		inst.Role = role
+		inst.Offset, _ = fields[1].(int64)
+		replicas, _ := fields[2].([]interface{})
+		if inst == s.master {
+			s.discover(replicas)
+		}
+	case role == "slave" && len(fields) == 5:
+		port, _ := fields[2].(int64)
+		inst.Role = role
+		inst.MasterHost, _ = fields[1].(string)
+		inst.MasterPort = strconv.FormatInt(port, 10)
+		inst.Offset, _ = fields[4].(int64)
+	}
+}
+
+// discover adds the replicas listed by the role of the primary, the lock must be held
+func (s *Sentinel) discover(replicas []interface{}) {
+	for _, r := range replicas {
+		fields, ok := r.([]interface{})
+		if !ok || len(fields) < 2 {
+			continue
+		}
+
+		host, _ := fields[0].(string)
+		port, _ := fields[1].(string)
+		inst := &Instance{Host: host, Port: port, PongAt: time.Now()}
+		if _, ok := s.replicas[inst.Addr()]; !ok && inst.Addr() != s.master.Addr() {
+			s.replicas[inst.Addr()] = inst
+			klogs.Logger.Infof("+slave %s", inst.Addr())
+		}
+	}
+}
+
+// reconfigure points the replicas which follow another primary, such as a former primary which
+// came back after a failover, to the current primary. Nothing is done while the primary is down
+func (s *Sentinel) reconfigure() {
+	s.mux.Lock()
+	master := *s.master
+	var wrong []string
+	for addr, r := range s.replicas {
+		if r.Down || r.Role == "" {
+			continue
+		}
+
+		if r.Role == "master" || r.MasterHost != master.Host || r.MasterPort != master.Port {
+			wrong = append(wrong, addr)
+		}
+	}
+	s.mux.Unlock()
+
+	if master.Down {
+		return
+	}
+
+	for _, addr := range wrong {
+		if _, err := call(addr, "replicaof", master.Host, master.Port); err != nil {
+			klogs.Logger.Warnf("error reconfiguring %s as a replica of %s: %s", addr, master.Addr(), err)
+			continue
+		}
+		klogs.Logger.Infof("+slave-reconf-sent %s", addr)
+	}
+}
+
+// hello sends the current primary and the epoch of its promotion to the other sentinels
+func (s *Sentinel) hello() {
+	s.mux.Lock()
+	args := []string{"hello", s.config.Name, s.master.Host, s.master.Port, strconv.FormatUint(s.configEpoch, 10)}
+	s.mux.Unlock()
+
+	for _, peer := range s.config.Sentinels {
+		if _, err := call(peer, "sentinel", args...); err != nil {
+			klogs.Logger.Debugf("error sending hello to sentinel %s: %s", peer, err)
+		}
+	}
+}
+
+// switchMaster makes the instance at host and port the primary promoted in epoch, the previous primary
+// is kept as a replica so that it is reconfigured when it is back. The lock must be held
+func (s *Sentinel) switchMaster(host, port string, epoch uint64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+	}
+	s.configEpoch = epoch
+
+	old := s.master
+	addr := net.JoinHostPort(host, port)
+	if addr == old.Addr() {
+		return
+	}
+
+	master, ok := s.replicas[addr]
+	if !ok {
+		master = &Instance{Host: host, Port: port, PongAt: time.Now()}
+	}
+	delete(s.replicas, addr)
+
+	s.master = master
+	s.replicas[old.Addr()] = old
+	s.odown = false
+	klogs.Logger.Warnf("+switch-master %s %s %s %s %s", s.config.Name, old.Host, old.Port, host, port)
+}
+
+// askPeers asks the other sentinels whether they consider the primary down, a vote is requested
+// when runID is not *. It returns the number of sentinels which agree and which voted for runID
+func (s *Sentinel) askPeers(host, port string, epoch uint64, runID string) (agreed, votes int) {
+	for _, peer := range s.config.Sentinels {
+		reply, err := call(peer, "sentinel", "is-master-down-by-addr", host, port, strconv.FormatUint(epoch, 10), runID)
+		fields, ok := reply.([]interface{})
+		if err != nil || !ok || len(fields) != 3 {
+			continue
+		}
+
+		if down, _ := fields[0].(int64); down == 1 {
+			agreed++
+		}
+
+		leader, _ := fields[1].(string)
+		leaderEpoch, _ := fields[2].(int64)
+		if leader == runID && uint64(leaderEpoch) == epoch {
+			votes++
+		}
+	}
+
+	return agreed, votes
+}
+
+// checkFailover starts a failover if the primary is down for a quorum of sentinels and this sentinel
+// is elected by a majority of them
+func (s *Sentinel) checkFailover() {
+	s.mux.Lock()
+	master := *s.master
+	s.mux.Unlock()
+
+	if !master.Down {
+		s.mux.Lock()
+		s.odown = false
+		s.mux.Unlock()
+		return
+	}
+
+	agreed, _ := s.askPeers(master.Host, master.Port, 0, "*")
+	if agreed+1 < s.config.Quorum {
+		return
+	}
+
+	s.mux.Lock()
+	if !s.odown {
+		s.odown = true
+		klogs.Logger.Warnf("+odown %s #quorum %d/%d", master.Addr(), agreed+1, s.config.Quorum)
+	}
+
+	// a failover is not attempted again before twice the failover timeout
+	if time.Since(s.failoverAt) < 2*s.config.FailoverTimeout {
+		s.mux.Unlock()
+		return
+	}
+
+	s.currentEpoch++
+	epoch := s.currentEpoch
+	s.leader, s.leaderEpoch = s.id, epoch
+	s.failoverAt = time.Now()
+	s.mux.Unlock()
+
+	klogs.Logger.Warnf("+try-failover %s epoch %d", master.Addr(), epoch)
+	_, votes := s.askPeers(master.Host, master.Port, epoch, s.id)
+
+	majority := (len(s.config.Sentinels)+1)/2 + 1
+	if majority < s.config.Quorum {
+		majority = s.config.Quorum
+	}
+
+	if votes+1 < majority {
+		klogs.Logger.Warnf("-failover-abort-not-elected %s epoch %d", master.Addr(), epoch)
+		return
+	}
+
+	klogs.Logger.Warnf("+elected-leader %s epoch %d", master.Addr(), epoch)
+	if err := s.failover(epoch); err != nil {
+		klogs.Logger.Errorf("failover of %s aborted: %s", master.Addr(), err)
+	}
+}
+
+// best returns the replica to promote, which is the one with the highest offset among the ones
+// which are up. The lock must be held
+func (s *Sentinel) best() *Instance {
+	var candidates []*Instance
+	for _, r := range s.replicas {
+		if !r.Down && r.Role == "slave" {
+			candidates = append(candidates, r)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Offset != candidates[j].Offset {
+			return candidates[i].Offset > candidates[j].Offset
+		}
+
+		return candidates[i].Addr() < candidates[j].Addr()
+	})
+
+	return candidates[0]
+}
+
+// failover promotes the best replica to be the primary in epoch and points the other replicas to it
+func (s *Sentinel) failover(epoch uint64) error {
+	s.mux.Lock()
+	candidate := s.best()
+	s.mux.Unlock()
+
+	if candidate == nil {
+		return ErrNoGoodReplica
+	}
+
+	if _, err := call(candidate.Addr(), "replicaof", "no", "one"); err != nil {
+		return err
+	}
+	klogs.Logger.Warnf("+promoted-slave %s", candidate.Addr())
+
+	s.mux.Lock()
+	s.switchMaster(candidate.Host, candidate.Port, epoch)
+	var others []string
+	for addr, r := range s.replicas {
+		if !r.Down {
+			others = append(others, addr)
+		}
+	}
+	s.mux.Unlock()
+
+	for _, addr := range others {
+		if _, err := call(addr, "replicaof", candidate.Host, candidate.Port); err != nil {
+			klogs.Logger.Warnf("error reconfiguring %s as a replica of %s: %s", addr, candidate.Addr(), err)
+		}
+	}
+
+	s.hello()
+
+	return nil
+}
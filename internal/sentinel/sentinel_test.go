@@ -0,0 +1,198 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package sentinel
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// fakeInstance answers PING, ROLE and REPLICAOF like a primary or a replica
+type fakeInstance struct {
+	listener   net.Listener
+	host, port string
+	role       protcl.Reply
+	replicaOf  []string // arguments of the last REPLICAOF
+	mux        sync.Mutex
+}
+
+func newFakeInstance(t *testing.T) *fakeInstance {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	f := &fakeInstance{listener: listener, host: host, port: port}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeInstance) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := protcl.NewStreamReader(conn)
+	for {
+		strs, err := reader.ReadCommand()
+		if err != nil {
+			return
+		}
+
+		f.mux.Lock()
+		switch strings.ToLower(strs[0]) {
+		case "ping":
+			conn.Write([]byte("+PONG\r\n"))
+		case "role":
+			conn.Write([]byte(f.role.Reply()))
+		case "replicaof":
+			f.replicaOf = strs[1:]
+			conn.Write([]byte("+OK\r\n"))
+		}
+		f.mux.Unlock()
+	}
+}
+
+func (f *fakeInstance) setRole(role protcl.Reply) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.role = role
+}
+
+func (f *fakeInstance) lastReplicaOf() []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return f.replicaOf
+}
+
+func replicaRole(host, port string, offset int) protcl.Reply {
+	p, _ := strconv.Atoi(port)
+
+	return protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewBulkStringReply(false, "slave"),
+		protcl.NewBulkStringReply(false, host),
+		protcl.NewIntegerReply(p),
+		protcl.NewBulkStringReply(false, "connected"),
+		protcl.NewIntegerReply(offset),
+	})
+}
+
+func TestSentinel_Failover(t *testing.T) {
+	assert := testifyAssert.New(t)
+	klogs.InitLoggers(config.AppConfig{LogType: "default"})
+
+	master, behind, ahead := newFakeInstance(t), newFakeInstance(t), newFakeInstance(t)
+	defer behind.listener.Close()
+	defer ahead.listener.Close()
+
+	master.setRole(protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewBulkStringReply(false, "master"),
+		protcl.NewIntegerReply(100),
+		protcl.NewArrayReply(false, []protcl.Reply{
+			stringsReply(behind.host, behind.port, "90"),
+			stringsReply(ahead.host, ahead.port, "100"),
+		}),
+	}))
+	behind.setRole(replicaRole(master.host, master.port, 90))
+	ahead.setRole(replicaRole(master.host, master.port, 100))
+
+	s := New(Config{Name: "kache", Host: master.host, Port: master.port, Quorum: 1,
+		DownAfter: 50 * time.Millisecond, FailoverTimeout: time.Minute})
+
+	s.tick()
+	s.tick()
+	assert.Len(s.replicas, 2)
+	assert.Equal(stringsReply(master.host, master.port), s.execute(&protcl.RespCommand{
+		Name: "sentinel", Args: []string{"get-master-addr-by-name", "kache"}}).Reply)
+	assert.Equal(protcl.NewArrayReply(true, nil), s.execute(&protcl.RespCommand{
+		Name: "sentinel", Args: []string{"get-master-addr-by-name", "foo"}}).Reply)
+
+	// the replica with the highest offset is promoted once the primary is down
+	master.listener.Close()
+	time.Sleep(60 * time.Millisecond)
+	s.tick()
+
+	host, port := s.Master()
+	assert.Equal(ahead.port, port)
+	assert.Equal(ahead.host, host)
+	assert.Equal([]string{"no", "one"}, ahead.lastReplicaOf())
+	assert.Equal([]string{ahead.host, ahead.port}, behind.lastReplicaOf())
+	assert.Equal(uint64(1), s.configEpoch)
+
+	// the former primary is kept as a replica to be reconfigured when it is back
+	assert.Contains(s.replicas, net.JoinHostPort(master.host, master.port))
+	assert.NotContains(s.replicas, net.JoinHostPort(ahead.host, ahead.port))
+}
+
+func TestSentinel_Vote(t *testing.T) {
+	assert := testifyAssert.New(t)
+	klogs.InitLoggers(config.AppConfig{LogType: "default"})
+
+	s := New(Config{Name: "kache", Host: "127.0.0.1", Port: "7088", Quorum: 2})
+	vote := func(epoch, runID string) string {
+		return s.execute(&protcl.RespCommand{Name: "sentinel",
+			Args: []string{"is-master-down-by-addr", "127.0.0.1", "7088", epoch, runID}}).RespReply()
+	}
+
+	assert.Equal("*3\r\n:0\r\n$1\r\n*\r\n:0\r\n", vote("0", "*"))
+	assert.Equal("*3\r\n:0\r\n$1\r\na\r\n:1\r\n", vote("1", "a"))
+	assert.Equal("*3\r\n:0\r\n$1\r\na\r\n:1\r\n", vote("1", "b"))
+	assert.Equal("*3\r\n:0\r\n$1\r\nb\r\n:2\r\n", vote("2", "b"))
+	assert.Equal(uint64(2), s.currentEpoch)
+
+	// the primary of a more recent promotion wins
+	hello := func(port, epoch string) *protcl.Message {
+		return s.execute(&protcl.RespCommand{Name: "sentinel", Args: []string{"hello", "kache", "127.0.0.1", port, epoch}})
+	}
+	assert.Nil(hello("7089", "2").Err)
+	assert.Nil(hello("7090", "1").Err)
+	_, port := s.Master()
+	assert.Equal("7089", port)
+	assert.Contains(s.replicas, "127.0.0.1:7088")
+
+	assert.NotNil(s.execute(&protcl.RespCommand{Name: "sentinel", Args: []string{"master", "foo"}}).Err)
+	assert.NotNil(s.execute(&protcl.RespCommand{Name: "sentinel", Args: []string{"foo"}}).Err)
+	assert.Equal(protcl.NewSimpleStringReply("PONG"), s.execute(&protcl.RespCommand{Name: "ping"}).Reply)
+}
@@ -0,0 +1,300 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package sentinel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+var ErrNoSuchMaster = errors.New("no such master with that name")
+
+// Listen serves the commands of clients and other sentinels at addr
+func (s *Sentinel) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				klogs.Logger.Errorf("sentinel stopped accepting connections: %s", err)
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return nil
+}
+
+func (s *Sentinel) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := protcl.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		cmd, err := reader.ParseMessage()
+		if err == io.EOF {
+			return
+		}
+
+		message := protcl.NewMessage(nil, err)
+		if err == nil {
+			message = s.execute(cmd)
+		}
+
+		if message.Err != nil {
+			writer.WriteString(protcl.RespError(message.Err))
+		} else {
+			writer.WriteString(message.RespReply())
+		}
+
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (s *Sentinel) execute(cmd *protcl.RespCommand) *protcl.Message {
+	switch cmd.Name {
+	case "ping":
+		return protcl.NewMessage(protcl.NewSimpleStringReply("PONG"), nil)
+	case "sentinel":
+		if len(cmd.Args) == 0 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+		}
+
+		return s.sentinel(strings.ToLower(cmd.Args[0]), cmd.Args[1:])
+	}
+
+	return protcl.NewMessage(nil, &protcl.ErrUnknownCommand{Cmd: cmd.Name})
+}
+
+// sentinelArgs is the number of arguments of the SENTINEL subcommands
+var sentinelArgs = map[string]int{
+	"myid":                    0,
+	"get-master-addr-by-name": 1,
+	"master":                  1,
+	"replicas":                1,
+	"slaves":                  1,
+	"sentinels":               1,
+	"failover":                1,
+	"is-master-down-by-addr":  4,
+	"hello":                   4,
+}
+
+func (s *Sentinel) sentinel(subcommand string, args []string) *protcl.Message {
+	n, ok := sentinelArgs[subcommand]
+	if !ok {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: fmt.Errorf("unknown subcommand %s", subcommand)})
+	}
+
+	if len(args) != n {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "sentinel " + subcommand})
+	}
+
+	if subcommand == "myid" {
+		return protcl.NewMessage(protcl.NewBulkStringReply(false, s.id), nil)
+	}
+
+	if subcommand == "is-master-down-by-addr" {
+		return s.isMasterDownByAddr(args)
+	}
+
+	if args[0] != s.config.Name {
+		if subcommand == "get-master-addr-by-name" {
+			return protcl.NewMessage(protcl.NewArrayReply(true, nil), nil)
+		}
+
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrNoSuchMaster})
+	}
+
+	switch subcommand {
+	case "get-master-addr-by-name":
+		host, port := s.Master()
+		return protcl.NewMessage(stringsReply(host, port), nil)
+	case "master":
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		return protcl.NewMessage(stringsReply(
+			"name", s.config.Name,
+			"ip", s.master.Host,
+			"port", s.master.Port,
+			"flags", s.flags(s.master, "master"),
+			"num-slaves", strconv.Itoa(len(s.replicas)),
+			"num-other-sentinels", strconv.Itoa(len(s.config.Sentinels)),
+			"quorum", strconv.Itoa(s.config.Quorum),
+			"config-epoch", strconv.FormatUint(s.configEpoch, 10)), nil)
+	case "replicas", "slaves":
+		return protcl.NewMessage(s.replicasReply(), nil)
+	case "sentinels":
+		peers := make([]protcl.Reply, len(s.config.Sentinels))
+		for i, peer := range s.config.Sentinels {
+			host, port, _ := net.SplitHostPort(peer)
+			peers[i] = stringsReply("ip", host, "port", port)
+		}
+
+		return protcl.NewMessage(protcl.NewArrayReply(false, peers), nil)
+	case "failover":
+		return s.forceFailover()
+	}
+
+	return s.handleHello(args)
+}
+
+func stringsReply(strs ...string) *protcl.ArrayReply {
+	elems := make([]protcl.Reply, len(strs))
+	for i, str := range strs {
+		elems[i] = protcl.NewBulkStringReply(false, str)
+	}
+
+	return protcl.NewArrayReply(false, elems)
+}
+
+// flags describes the state of inst, the lock must be held
+func (s *Sentinel) flags(inst *Instance, role string) string {
+	flags := []string{role}
+	if inst.Down {
+		flags = append(flags, "s_down")
+	}
+
+	if inst == s.master && s.odown {
+		flags = append(flags, "o_down")
+	}
+
+	return strings.Join(flags, ",")
+}
+
+func (s *Sentinel) replicasReply() *protcl.ArrayReply {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	addrs := make([]string, 0, len(s.replicas))
+	for addr := range s.replicas {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	replicas := make([]protcl.Reply, len(addrs))
+	for i, addr := range addrs {
+		r := s.replicas[addr]
+		replicas[i] = stringsReply(
+			"ip", r.Host,
+			"port", r.Port,
+			"flags", s.flags(r, "slave"),
+			"master-host", r.MasterHost,
+			"master-port", r.MasterPort,
+			"slave-repl-offset", strconv.FormatInt(r.Offset, 10))
+	}
+
+	return protcl.NewArrayReply(false, replicas)
+}
+
+// isMasterDownByAddr replies whether the primary at the given address is down for this sentinel.
+// Unless the run id is *, it is a request for a vote in the given epoch, the first request of an epoch wins
+func (s *Sentinel) isMasterDownByAddr(args []string) *protcl.Message {
+	host, port, runID := args[0], args[1], args[3]
+	epoch, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[2]})
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	down := 0
+	if s.master.Host == host && s.master.Port == port && s.master.Down {
+		down = 1
+	}
+
+	leader, leaderEpoch := "*", uint64(0)
+	if runID != "*" {
+		if epoch > s.currentEpoch {
+			s.currentEpoch = epoch
+		}
+
+		// after voting for another sentinel, a failover is not attempted by this one for a while
+		if epoch > s.leaderEpoch {
+			s.leader, s.leaderEpoch = runID, epoch
+			s.failoverAt = time.Now()
+			klogs.Logger.Infof("+vote-for-leader %s %d", runID, epoch)
+		}
+
+		leader, leaderEpoch = s.leader, s.leaderEpoch
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewIntegerReply(down),
+		protcl.NewBulkStringReply(false, leader),
+		protcl.NewIntegerReply(int(leaderEpoch)),
+	}), nil)
+}
+
+// handleHello switches to the primary sent by another sentinel if it was promoted in a more recent epoch
+func (s *Sentinel) handleHello(args []string) *protcl.Message {
+	epoch, err := strconv.ParseUint(args[3], 10, 64)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: args[3]})
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if epoch > s.configEpoch {
+		s.switchMaster(args[1], args[2], epoch)
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// forceFailover promotes a replica without asking the other sentinels
+func (s *Sentinel) forceFailover() *protcl.Message {
+	s.mux.Lock()
+	s.currentEpoch++
+	epoch := s.currentEpoch
+	s.failoverAt = time.Now()
+	s.mux.Unlock()
+
+	if err := s.failover(epoch); err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
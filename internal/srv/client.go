@@ -0,0 +1,571 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/arch"
+	"github.com/kasvith/kache/internal/cluster"
+	"github.com/kasvith/kache/internal/cmds"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/internal/repl"
+)
+
+// client holds the state of a single connection
+type client struct {
+	id      uint64    // unique id assigned by the registry of clients
+	addr    string    // remote address of the connection
+	created time.Time // when the client connected
+	conn    net.Conn
+	writer  *bufio.Writer
+	wmux    sync.Mutex                   // guards writer, messages can be pushed from other connections
+	db      *db.DB                       // database selected with SELECT
+	tx      *protcl.RespCommand          // transaction queued after MULTI, nil when not in a transaction
+	txErr   bool                         // an invalid command was queued, the transaction will be aborted
+	watched map[*db.DB]map[string]uint64 // versions of the keys watched with WATCH, by their database
+
+	channels map[string]struct{} // channels subscribed with SUBSCRIBE
+	patterns map[string]struct{} // patterns subscribed with PSUBSCRIBE
+	pushes   chan protcl.Reply   // published messages waiting to be written by pushMessages
+	pushOnce sync.Once
+
+	listeningPort string     // port of a replica, sent with REPLCONF listening-port
+	link          *repl.Link // replication stream when the client is a replica, nil otherwise
+
+	asking bool // ASKING was sent, the next command may access a slot being imported
+
+	closed   <-chan struct{} // closed when the client closes the connection
+	killed   chan struct{}   // closed when the client is killed with CLIENT KILL or on shutdown
+	killOnce sync.Once
+	blocked  bool          // the client waits for a blocking command
+	waited   time.Duration // how long the last command was blocked or paused, it is not counted as execution time
+
+	smux   sync.Mutex // guards status, it is read by other connections with CLIENT LIST
+	status clientInfo
+}
+
+// clientInfo is the state of a client shown by CLIENT LIST, it is refreshed by the connection around
+// every command so that other connections don't access the state of the client
+type clientInfo struct {
+	name            string    // name set with CLIENT SETNAME
+	lastCmd         string    // name of the last command
+	lastInteraction time.Time // when the last command was received
+	db              int       // selected database
+	flags           string    // S replica, P subscriber, x in a transaction, b blocked, N none
+	sub, psub       int       // number of channels and patterns subscribed
+	multi           int       // number of commands queued in the transaction, -1 outside of it
+}
+
+func newClient(conn net.Conn) *client {
+	c := &client{
+		created:  time.Now(),
+		conn:     conn,
+		writer:   bufio.NewWriter(conn),
+		db:       DB,
+		watched:  make(map[*db.DB]map[string]uint64),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		pushes:   make(chan protcl.Reply, maxPendingMessages),
+		killed:   make(chan struct{}),
+	}
+
+	if conn != nil {
+		c.addr = conn.RemoteAddr().String()
+	}
+	c.status.lastInteraction = c.created
+	c.refresh()
+
+	return c
+}
+
+// kill flags the client to be disconnected, the connection is closed once its command completes
+func (c *client) kill() {
+	c.killOnce.Do(func() { close(c.killed) })
+}
+
+// disconnect kills the client and closes its connection right away
+func (c *client) disconnect() {
+	c.kill()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// interact records cmd as the last command of the client
+func (c *client) interact(cmd string) {
+	c.smux.Lock()
+	defer c.smux.Unlock()
+
+	c.status.lastCmd = cmd
+	c.status.lastInteraction = time.Now()
+}
+
+// refresh updates the info of the client from its state
+func (c *client) refresh() {
+	flags := ""
+	if c.link != nil {
+		flags += "S"
+	}
+	if c.subscriptions() > 0 {
+		flags += "P"
+	}
+	if c.tx != nil {
+		flags += "x"
+	}
+	if c.blocked {
+		flags += "b"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	multi := -1
+	if c.tx != nil {
+		multi = len(c.tx.Commands)
+	}
+
+	c.smux.Lock()
+	defer c.smux.Unlock()
+
+	c.status.db = c.db.ID()
+	c.status.flags = flags
+	c.status.sub, c.status.psub = len(c.channels), len(c.patterns)
+	c.status.multi = multi
+}
+
+// snapshot returns the info of the client
+func (c *client) snapshot() clientInfo {
+	c.smux.Lock()
+	defer c.smux.Unlock()
+
+	return c.status
+}
+
+// reply writes the result of a command to the client
+func (c *client) reply(message *protcl.Message) {
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+
+	if message.Err == nil {
+		c.writer.WriteString(message.RespReply())
+	} else {
+		c.writer.WriteString(protcl.RespError(message.Err))
+	}
+
+	c.writer.Flush()
+}
+
+// Write sends raw bytes to the client, the replication stream is written to replicas with it
+func (c *client) Write(p []byte) (int, error) {
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+
+	n, err := c.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, c.writer.Flush()
+}
+
+// Close closes the connection of the client
+func (c *client) Close() error {
+	return c.conn.Close()
+}
+
+// Push queues a published message to the client, implements pubsub.Subscriber. It never blocks as
+// messages are published under the lock of the database, a client which falls behind is disconnected
+func (c *client) Push(reply protcl.Reply) {
+	c.pushOnce.Do(func() { go c.pushMessages() })
+
+	select {
+	case <-c.killed:
+		// the subscriptions are removed once the connection closes
+		return
+	default:
+	}
+
+	select {
+	case c.pushes <- reply:
+	default:
+		klogs.Logger.Warnf("%s: disconnecting subscriber, more than %d messages are pending", c.addr, maxPendingMessages)
+		c.disconnect()
+	}
+}
+
+// pushMessages writes the published messages to the client until it is killed or closed
+func (c *client) pushMessages() {
+	for {
+		select {
+		case reply := <-c.pushes:
+			c.reply(protcl.NewMessage(reply, nil))
+		case <-c.killed:
+			return
+		}
+	}
+}
+
+// serverCommands are the commands handled by the client instead of the CommandTable
+var serverCommands = map[string]bool{
+	"multi": true, "exec": true, "discard": true, "watch": true, "unwatch": true, "select": true,
+	"subscribe": true, "psubscribe": true, "unsubscribe": true, "punsubscribe": true, "replicaof": true,
+	"slaveof": true, "replconf": true, "psync": true, "role": true, "info": true, "asking": true,
+	"shutdown": true, "client": true, "config": true,
+}
+
+// execute executes a command issued by the client, transaction, subscription and replication commands
+// are handled here as they depend on the state of the connection. A nil message means no reply is sent
+func (c *client) execute(cmd *protcl.RespCommand) *protcl.Message {
+	if c.subscriptions() > 0 {
+		return c.executeSubscribed(cmd)
+	}
+
+	// ASKING only applies to the command which follows it
+	asking := c.asking
+	c.asking = false
+
+	if _, ok := localArgs[cmd.Name]; ok && c.tx != nil {
+		return c.queueLocal(cmd)
+	}
+
+	switch cmd.Name {
+	case "multi":
+		return c.multi(cmd)
+	case "exec":
+		return c.exec(cmd)
+	case "discard":
+		return c.discard(cmd)
+	case "watch":
+		return c.watch(cmd)
+	case "unwatch":
+		return c.unwatch(cmd)
+	case "select":
+		return c.selectDB(cmd)
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+		if c.tx != nil {
+			return c.txError(&protcl.ErrInsideMulti{Cmd: cmd.Name})
+		}
+
+		return c.executeSubscribed(cmd)
+	case "replicaof", "slaveof":
+		return c.replicaOf(cmd)
+	case "replconf":
+		return c.replConf(cmd)
+	case "psync":
+		return c.psync(cmd)
+	case "role":
+		return c.role(cmd)
+	case "info":
+		return c.info(cmd)
+	case "asking":
+		return c.ask(cmd)
+	case "shutdown":
+		return c.shutdownCmd(cmd)
+	case "client":
+		return c.clientCmd(cmd)
+	case "config":
+		return c.configCmd(cmd)
+	}
+
+	if readOnly(cmd) {
+		return c.txError(&protcl.ErrReadOnlyReplica{})
+	}
+
+	if err := route(cmd, asking); err != nil {
+		return c.txError(err)
+	}
+
+	if c.tx != nil {
+		return c.queue(cmd)
+	}
+
+	if !c.waitPause(writes(*cmd)) {
+		return nil
+	}
+
+	if command, ok := arch.CommandTable[cmd.Name]; ok && command.Blocking {
+		return c.block(cmd)
+	}
+
+	return dbCommand.Execute(c.db, cmd.Name, cmd.Args)
+}
+
+// block executes a blocking command, the connection is parked until the client is served, the
+// timeout elapses or the client disconnects
+func (c *client) block(cmd *protcl.RespCommand) *protcl.Message {
+	message, blocked := dbCommand.ExecuteBlocking(c.db, cmd.Name, cmd.Args)
+	if blocked == nil {
+		return message
+	}
+
+	c.blocked = true
+	c.refresh()
+	start := time.Now()
+	defer func() {
+		c.blocked = false
+		c.waited += time.Since(start)
+	}()
+
+	var timeout <-chan time.Time
+	if blocked.Timeout > 0 {
+		timer := time.NewTimer(blocked.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case message = <-blocked.Result:
+		return message
+	case <-timeout:
+		return blocked.Cancel()
+	case <-c.closed:
+		blocked.Cancel()
+		return nil
+	case <-shuttingDown:
+		blocked.Cancel()
+		return nil
+	case <-c.killed:
+		blocked.Cancel()
+		return nil
+	}
+}
+
+// close releases the resources held by the client
+func (c *client) close() {
+	if c.link != nil {
+		Primary.Detach(c.link)
+	}
+	c.unwatchAll()
+	c.unsubscribeAll()
+	// stops pushMessages
+	c.kill()
+}
+
+func (c *client) multi(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 0 {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if c.tx != nil {
+		return protcl.NewMessage(nil, &protcl.ErrNestedMulti{})
+	}
+
+	c.tx = &protcl.RespCommand{Name: cmd.Name, Multi: true}
+	c.txErr = false
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// queue adds cmd to the transaction, commands are validated before they are queued
+func (c *client) queue(cmd *protcl.RespCommand) *protcl.Message {
+	if _, err := dbCommand.Validate(cmd.Name, cmd.Args); err != nil {
+		return c.txError(err)
+	}
+
+	c.tx.Commands = append(c.tx.Commands, *cmd)
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("QUEUED"), nil)
+}
+
+// localArgs are the commands handled here which are queued in a transaction, with their minimum
+// and maximum number of arguments, -1 means no maximum
+var localArgs = map[string][2]int{
+	"select": {1, 1},
+	"info":   {0, 1},
+	"role":   {0, 0},
+	"client": {1, -1},
+	"config": {1, -1},
+}
+
+// queueLocal adds a command handled here to the transaction, it is executed by executeQueued
+func (c *client) queueLocal(cmd *protcl.RespCommand) *protcl.Message {
+	if args := localArgs[cmd.Name]; len(cmd.Args) < args[0] || (args[1] != -1 && len(cmd.Args) > args[1]) {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	c.tx.Commands = append(c.tx.Commands, *cmd)
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("QUEUED"), nil)
+}
+
+// executeQueued executes a command of localArgs queued in a transaction, it is called by EXEC while
+// the lock of the databases is held. The database selected by the client is returned
+func (c *client) executeQueued(d *db.DB, cmd protcl.RespCommand) (*db.DB, *protcl.Message) {
+	var message *protcl.Message
+	switch cmd.Name {
+	case "select":
+		message = c.selectDB(&cmd)
+	case "info":
+		message = c.infoLocked(&cmd)
+	case "role":
+		message = c.role(&cmd)
+	case "client":
+		message = c.clientCmd(&cmd)
+	case "config":
+		message = cmds.Config(d, cmd.Args)
+	default:
+		message = protcl.NewMessage(nil, &protcl.ErrUnknownCommand{Cmd: cmd.Name})
+	}
+
+	return c.db, message
+}
+
+// txError replies with err and flags the transaction to be aborted on EXEC
+func (c *client) txError(err error) *protcl.Message {
+	if c.tx != nil {
+		c.txErr = true
+	}
+
+	return protcl.NewMessage(nil, err)
+}
+
+func (c *client) exec(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 0 {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if c.tx == nil {
+		return protcl.NewMessage(nil, &protcl.ErrWithoutMulti{Cmd: cmd.Name})
+	}
+
+	tx, txErr := c.tx, c.txErr
+	c.tx, c.txErr = nil, false
+	defer c.unwatchAll()
+
+	if txErr {
+		return protcl.NewMessage(nil, &protcl.ErrExecAbort{})
+	}
+
+	if !c.waitPause(writes(tx.Commands...)) {
+		return nil
+	}
+
+	return dbCommand.ExecuteMulti(c.db, tx.Commands, c.watched, c.executeQueued)
+}
+
+func (c *client) discard(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 0 {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if c.tx == nil {
+		return protcl.NewMessage(nil, &protcl.ErrWithoutMulti{Cmd: cmd.Name})
+	}
+
+	c.tx, c.txErr = nil, false
+	c.unwatchAll()
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+func (c *client) watch(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) == 0 {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if c.tx != nil {
+		return c.txError(&protcl.ErrInsideMulti{Cmd: cmd.Name})
+	}
+
+	keys, ok := c.watched[c.db]
+	if !ok {
+		keys = make(map[string]uint64)
+		c.watched[c.db] = keys
+	}
+
+	c.db.Lock()
+	for _, key := range cmd.Args {
+		if _, ok := keys[key]; !ok {
+			keys[key] = c.db.Watch(key)
+		}
+	}
+	c.db.Unlock()
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+func (c *client) unwatch(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 0 {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if c.tx != nil {
+		return c.txError(&protcl.ErrInsideMulti{Cmd: cmd.Name})
+	}
+
+	c.unwatchAll()
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// unwatchAll forgets all the keys watched by the client
+func (c *client) unwatchAll() {
+	if len(c.watched) == 0 {
+		return
+	}
+
+	DB.Lock()
+	for d, keys := range c.watched {
+		for key := range keys {
+			d.Unwatch(key)
+		}
+	}
+	DB.Unlock()
+
+	c.watched = make(map[*db.DB]map[string]uint64)
+}
+
+// selectDB switches the database the commands of the client are executed on, only the first
+// database is available in cluster mode
+func (c *client) selectDB(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 1 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	index, err := strconv.Atoi(cmd.Args[0])
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: cmd.Args[0]})
+	}
+
+	if cluster.Default != nil && index != 0 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: cmds.ErrSelectInCluster})
+	}
+
+	d, err := DB.Select(index)
+	if err != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+	}
+
+	c.db = d
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
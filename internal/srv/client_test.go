@@ -0,0 +1,787 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/cluster"
+	"github.com/kasvith/kache/internal/cmds"
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+func TestMain(m *testing.M) {
+	klogs.InitLoggers(config.AppConfig{LogType: "default"})
+	config.Set(config.AppConfig{MaxMultiBlkLength: 1024})
+	InitDatabases(16)
+
+	os.Exit(m.Run())
+}
+
+func execute(c *client, name string, args ...string) *protcl.Message {
+	return c.execute(&protcl.RespCommand{Name: name, Args: args})
+}
+
+func TestClient_Transaction(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	assert.Equal(&protcl.ErrWithoutMulti{Cmd: "exec"}, execute(c, "exec").Err)
+	assert.Equal(&protcl.ErrWithoutMulti{Cmd: "discard"}, execute(c, "discard").Err)
+
+	assert.Nil(execute(c, "multi").Err)
+	assert.Equal(&protcl.ErrNestedMulti{}, execute(c, "multi").Err)
+	assert.Equal(protcl.NewSimpleStringReply("QUEUED"), execute(c, "set", "tx", "1").Reply)
+	assert.Equal(protcl.NewSimpleStringReply("QUEUED"), execute(c, "incr", "tx").Reply)
+	assert.Equal("*2\r\n+OK\r\n:2\r\n", execute(c, "exec").RespReply())
+
+	// queueing an invalid command aborts the transaction
+	execute(c, "multi")
+	execute(c, "incr", "tx")
+	assert.Equal(&protcl.ErrUnknownCommand{Cmd: "foo"}, execute(c, "foo").Err)
+	assert.Equal(&protcl.ErrExecAbort{}, execute(c, "exec").Err)
+	assert.Equal("$1\r\n2\r\n", execute(c, "get", "tx").RespReply())
+
+	// discard drops queued commands
+	execute(c, "multi")
+	execute(c, "incr", "tx")
+	assert.Nil(execute(c, "discard").Err)
+	assert.Equal("$1\r\n2\r\n", execute(c, "get", "tx").RespReply())
+}
+
+func TestClient_Watch(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+	other := newClient(nil)
+
+	assert.Nil(execute(c, "watch", "w").Err)
+	execute(c, "multi")
+	assert.Equal(&protcl.ErrInsideMulti{Cmd: "watch"}, execute(c, "watch", "w").Err)
+	execute(c, "discard")
+
+	// discard unwatches keys, another client can modify it freely
+	execute(other, "set", "w", "1")
+	execute(c, "multi")
+	execute(c, "set", "w", "2")
+	assert.Equal("*1\r\n+OK\r\n", execute(c, "exec").RespReply())
+
+	// modification by another client aborts exec
+	execute(c, "watch", "w")
+	execute(other, "set", "w", "3")
+	execute(c, "multi")
+	execute(c, "set", "w", "4")
+	assert.Equal("*-1\r\n", execute(c, "exec").RespReply())
+	assert.Equal("$1\r\n3\r\n", execute(c, "get", "w").RespReply())
+}
+
+func TestClient_SubscriberMode(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+
+	c := newClient(server)
+	publisher := newClient(nil)
+
+	// confirmations are written by the command itself, read them while it runs
+	read := func(want string) string {
+		buf := make([]byte, len(want))
+		io.ReadFull(conn, buf)
+		return string(buf)
+	}
+	subscribe := func(want string, name string, args ...string) {
+		done := make(chan *protcl.Message)
+		go func() { done <- execute(c, name, args...) }()
+		assert.Equal(want, read(want))
+		assert.Nil(<-done)
+	}
+
+	subscribe("*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n", "subscribe", "a", "b")
+	subscribe("*3\r\n$10\r\npsubscribe\r\n$2\r\nc*\r\n:3\r\n", "psubscribe", "c*")
+
+	// only subscription commands are allowed
+	assert.Equal(&protcl.ErrPubSubContext{Cmd: "get"}, execute(c, "get", "a").Err)
+	assert.Equal("*2\r\n$4\r\npong\r\n$0\r\n\r\n", execute(c, "ping").RespReply())
+
+	assert.Equal(protcl.NewIntegerReply(1), execute(publisher, "publish", "a", "msg").Reply)
+	assert.Equal("*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$3\r\nmsg\r\n", read("*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$3\r\nmsg\r\n"))
+	assert.Equal(protcl.NewIntegerReply(1), execute(publisher, "publish", "cat", "msg").Reply)
+	assert.Equal("*4\r\n$8\r\npmessage\r\n$2\r\nc*\r\n$3\r\ncat\r\n$3\r\nmsg\r\n", read("*4\r\n$8\r\npmessage\r\n$2\r\nc*\r\n$3\r\ncat\r\n$3\r\nmsg\r\n"))
+	assert.Equal("*2\r\n$1\r\nb\r\n:1\r\n", execute(publisher, "pubsub", "numsub", "b").RespReply())
+
+	assert.Equal("*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:2\r\n", execute(c, "unsubscribe", "a").RespReply())
+	assert.Equal("*3\r\n$12\r\npunsubscribe\r\n$2\r\nc*\r\n:1\r\n", execute(c, "punsubscribe").RespReply())
+	assert.Equal("*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:0\r\n", execute(c, "unsubscribe").RespReply())
+
+	// back to normal mode
+	assert.Equal("+PONG\r\n", execute(c, "ping").RespReply())
+	assert.Equal(protcl.NewIntegerReply(0), execute(publisher, "publish", "a", "msg").Reply)
+
+	// a message published while subscribing follows the confirmation
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for {
+			if execute(publisher, "publish", "d", "msg").Reply.(*protcl.IntegerReply).Value == 1 {
+				return
+			}
+		}
+	}()
+	subscribe("*3\r\n$9\r\nsubscribe\r\n$1\r\nd\r\n:1\r\n", "subscribe", "d")
+	<-published
+	assert.Equal("*3\r\n$7\r\nmessage\r\n$1\r\nd\r\n$3\r\nmsg\r\n", read("*3\r\n$7\r\nmessage\r\n$1\r\nd\r\n$3\r\nmsg\r\n"))
+	execute(c, "unsubscribe")
+}
+
+func TestClient_SlowSubscriber(t *testing.T) {
+	// nothing reads from the other end, writes to the subscriber never complete
+	server, conn := net.Pipe()
+	defer conn.Close()
+
+	c := newClient(server)
+	publisher := newClient(nil)
+	defer c.close()
+
+	// only the confirmation is read
+	subscribed := make(chan struct{})
+	go func() {
+		execute(c, "subscribe", "slow")
+		close(subscribed)
+	}()
+	io.ReadFull(conn, make([]byte, len("*3\r\n$9\r\nsubscribe\r\n$4\r\nslow\r\n:1\r\n")))
+	<-subscribed
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i <= maxPendingMessages+1; i++ {
+			execute(publisher, "publish", "slow", "msg")
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+
+	select {
+	case <-c.killed:
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber was not disconnected")
+	}
+}
+
+func TestClient_ClusterRoute(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	cluster.Default, err = cluster.New("127.0.0.1", 7000, time.Second, filepath.Join(dir, "nodes.conf"))
+	assert.Nil(err)
+	defer func() { cluster.Default = nil }()
+
+	assert.Equal(&protcl.ErrClusterDown{}, execute(c, "get", "bar").Err)
+	assert.Nil(execute(c, "cluster", "addslots", "5061").Err)
+	assert.Nil(execute(c, "set", "bar", "1").Err)
+	assert.Equal(&protcl.ErrCrossSlot{}, execute(c, "del", "bar", "foo").Err)
+	assert.Equal(protcl.NewIntegerReply(1), execute(c, "del", "bar", "{bar}foo").Reply)
+
+	// keyless commands are not routed
+	assert.Equal(protcl.NewIntegerReply(5061), execute(c, "cluster", "keyslot", "bar").Reply)
+	assert.Nil(execute(c, "ping").Err)
+
+	assert.Nil(execute(c, "select", "0").Err)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrSelectInCluster}, execute(c, "select", "1").Err)
+}
+
+func TestClient_Migrate(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(conn)
+		}
+	}()
+
+	source := db.NewDB()
+	dbCommand.Execute(source, "set", []string{"m1", "v", "px", "60000"})
+	dbCommand.Execute(source, "rpush", []string{"m2", "a", "b"})
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	migrate := func(args ...string) *protcl.Message {
+		return dbCommand.Execute(source, "migrate", append([]string{host, port}, args...))
+	}
+	assert.Equal(protcl.NewSimpleStringReply("NOKEY"), migrate("missing", "0", "1000").Reply)
+	assert.Equal(protcl.NewSimpleStringReply("OK"), migrate("m1", "0", "1000", "copy").Reply)
+	assert.Equal(protcl.NewIntegerReply(1), dbCommand.Execute(source, "exists", []string{"m1"}).Reply)
+
+	// existing keys are replaced only with REPLACE
+	assert.NotNil(migrate("", "0", "1000", "keys", "m1", "m2").Err)
+	assert.Equal(protcl.NewIntegerReply(1), dbCommand.Execute(source, "exists", []string{"m1"}).Reply)
+	assert.Equal(protcl.NewIntegerReply(0), dbCommand.Execute(source, "exists", []string{"m2"}).Reply)
+	assert.Equal(protcl.NewSimpleStringReply("OK"), migrate("m1", "0", "1000", "replace").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), dbCommand.Execute(source, "exists", []string{"m1"}).Reply)
+
+	c := newClient(nil)
+	assert.Equal("$1\r\nv\r\n", execute(c, "get", "m1").RespReply())
+	assert.True(execute(c, "pttl", "m1").Reply.(*protcl.IntegerReply).Value > 0)
+	assert.Equal("*2\r\n$1\r\na\r\n$1\r\nb\r\n", execute(c, "lrange", "m2", "0", "-1").RespReply())
+
+	assert.Equal(&protcl.ErrSyntax{}, migrate("m1", "0", "1000", "foo").Err)
+
+	// keys are restored in the database selected on the target
+	dbCommand.Execute(source, "set", []string{"m5", "v"})
+	assert.NotNil(migrate("m5", "16", "1000").Err)
+	assert.Equal(protcl.NewSimpleStringReply("OK"), migrate("m5", "1", "1000").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "exists", "m5").Reply)
+	execute(c, "select", "1")
+	assert.Equal("$1\r\nv\r\n", execute(c, "get", "m5").RespReply())
+	execute(c, "del", "m5")
+	execute(c, "select", "0")
+
+	// payloads holding line feeds are sent as they are
+	dbCommand.Execute(source, "set", []string{"m6", "0123456789"})
+	dbCommand.Execute(source, "set", []string{"m7", "a\r\nb\n"})
+	assert.True(strings.Contains(dbCommand.Execute(source, "dump", []string{"m6"}).Reply.(*protcl.BulkStringReply).Value, "\n"))
+	assert.Equal(protcl.NewSimpleStringReply("OK"), migrate("", "0", "1000", "keys", "m6", "m7").Reply)
+	assert.Equal("$10\r\n0123456789\r\n", execute(c, "get", "m6").RespReply())
+	assert.Equal("$5\r\na\r\nb\n\r\n", execute(c, "get", "m7").RespReply())
+	execute(c, "del", "m6", "m7")
+
+	// DUMP and RESTORE
+	payload := execute(c, "dump", "m2").Reply.(*protcl.BulkStringReply).Value
+	assert.Equal(&protcl.ErrBusyKey{}, execute(c, "restore", "m2", "0", payload).Err)
+	assert.Nil(execute(c, "restore", "m3", "0", payload).Err)
+	assert.Equal("*2\r\n$1\r\na\r\n$1\r\nb\r\n", execute(c, "lrange", "m3", "0", "-1").RespReply())
+	assert.NotNil(execute(c, "restore", "m4", "0", "bad").Err)
+	assert.Equal(protcl.NewBulkStringReply(true, ""), execute(c, "dump", "missing").Reply)
+	execute(c, "del", "m1", "m2", "m3")
+}
+
+func TestClient_Info(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	info := execute(c, "info", "stats").Reply.(*protcl.BulkStringReply).Value
+	assert.Contains(info, "# Stats\r\ntotal_connections_received:")
+	assert.Contains(info, "\r\nrejected_connections:0\r\ntimedout_connections:0\r\nevicted_keys:0\r\n")
+
+	// keys looked up by read commands are counted
+	hits, misses := DB.KeyspaceStats()
+	execute(c, "set", "info", "1")
+	execute(c, "get", "info")
+	execute(c, "get", "missing")
+	info = execute(c, "info", "stats").Reply.(*protcl.BulkStringReply).Value
+	assert.Contains(info, fmt.Sprintf("keyspace_hits:%d\r\nkeyspace_misses:%d\r\n", hits+1, misses+1))
+
+	SetBuildInfo("1.0.0", "now", "abc")
+	info = execute(c, "info").Reply.(*protcl.BulkStringReply).Value
+	assert.Contains(info, "# Server\r\nkache_version:1.0.0\r\nkache_git_sha1:abc\r\nkache_build_time:now\r\n")
+	assert.Contains(info, "# Clients\r\nconnected_clients:")
+	assert.Contains(info, "maxmemory_policy:noeviction\r\n")
+	assert.Contains(info, "# Replication\r\n")
+	assert.Contains(info, "# Keyspace\r\ndb0:keys=")
+	assert.NotContains(info, "# Commandstats")
+	assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "info"}, execute(c, "info", "a", "b").Err)
+
+	// databases without keys are not shown
+	execute(c, "select", "5")
+	execute(c, "set", "info", "1", "ex", "100")
+	info = execute(c, "info", "keyspace").Reply.(*protcl.BulkStringReply).Value
+	assert.Contains(info, "db5:keys=1,expires=1\r\n")
+	assert.NotContains(info, "db4:")
+	execute(c, "del", "info")
+	execute(c, "select", "0")
+	execute(c, "del", "info")
+
+	recordCommand("info", 3*time.Microsecond)
+	recordCommand("info", 5*time.Microsecond)
+	info = execute(c, "info", "commandstats").Reply.(*protcl.BulkStringReply).Value
+	assert.Contains(info, "cmdstat_info:calls=2,usec=8,usec_per_call=4.00\r\n")
+
+	// unknown commands are not recorded
+	recordCommand("nosuchcommand", time.Microsecond)
+	assert.NotContains(execute(c, "info", "commandstats").Reply.(*protcl.BulkStringReply).Value, "nosuchcommand")
+	assert.Contains(execute(c, "info", "all").Reply.(*protcl.BulkStringReply).Value, "# Commandstats\r\n")
+}
+
+func TestClient_Memory(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	execute(c, "rpush", "mem", "a", "b", "c")
+	usage := execute(c, "memory", "usage", "mem").Reply.(*protcl.IntegerReply).Value
+	assert.True(usage > 0)
+	assert.Equal(protcl.NewIntegerReply(usage), execute(c, "memory", "usage", "mem", "samples", "0").Reply)
+	assert.Equal(protcl.NewBulkStringReply(true, ""), execute(c, "memory", "usage", "missing").Reply)
+	assert.Equal(&protcl.ErrSyntax{}, execute(c, "memory", "usage", "mem", "foo", "1").Err)
+
+	stats := execute(c, "memory", "stats").RespReply()
+	assert.Contains(stats, "$10\r\nkeys.count\r\n:")
+	assert.Contains(stats, "$16\r\nmaxmemory.policy\r\n$10\r\nnoeviction\r\n")
+
+	assert.Equal("*1\r\n*3\r\n$3\r\nmem\r\n$4\r\nlist\r\n:"+strconv.Itoa(usage)+"\r\n",
+		execute(c, "memory", "bigkeys", "count", "1").RespReply())
+	assert.Equal(&protcl.ErrUnknownCommand{Cmd: "memory foo"}, execute(c, "memory", "foo").Err)
+	execute(c, "del", "mem")
+}
+
+func TestClient_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	execute(c, "set", "scan:a", "1")
+	execute(c, "rpush", "scan:b", "1")
+	execute(c, "hset", "scan:h", "f1", "1", "f2", "2")
+	execute(c, "sadd", "scan:s", "x", "y")
+
+	assert.Equal("*1\r\n$6\r\nscan:a\r\n", execute(c, "keys", "scan:[a]").RespReply())
+	assert.Equal("*2\r\n$1\r\n0\r\n*1\r\n$6\r\nscan:b\r\n",
+		execute(c, "scan", "0", "match", "scan:*", "count", "1000", "type", "list").RespReply())
+	assert.Equal("*2\r\n$1\r\n0\r\n*2\r\n$2\r\nf2\r\n$1\r\n2\r\n",
+		execute(c, "hscan", "scan:h", "0", "match", "*2").RespReply())
+	assert.Contains([]string{"*2\r\n$1\r\n0\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n", "*2\r\n$1\r\n0\r\n*2\r\n$1\r\ny\r\n$1\r\nx\r\n"},
+		execute(c, "sscan", "scan:s", "0").RespReply())
+	assert.Equal("*2\r\n$1\r\n0\r\n*0\r\n", execute(c, "sscan", "missing", "0").RespReply())
+
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrInvalidCursor}, execute(c, "scan", "x").Err)
+	assert.Equal(&protcl.ErrSyntax{}, execute(c, "scan", "0", "count").Err)
+	assert.Equal(&protcl.ErrSyntax{}, execute(c, "hscan", "scan:h", "0", "type", "hash").Err)
+	assert.Equal(&protcl.ErrWrongType{}, execute(c, "sscan", "scan:h", "0").Err)
+	assert.NotNil(execute(c, "scan", "0", "type", "foo").Err)
+	assert.NotNil(execute(c, "randomkey").Reply)
+
+	execute(c, "del", "scan:a", "scan:b", "scan:h", "scan:s")
+}
+
+func TestClient_KeySpace(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	execute(c, "sadd", "ks:s", "a", "b")
+	execute(c, "set", "ks:str", "v", "px", "60000")
+	assert.Equal(protcl.NewSimpleStringReply("set"), execute(c, "type", "ks:s").Reply)
+	assert.Equal(protcl.NewSimpleStringReply("none"), execute(c, "type", "ks:missing").Reply)
+
+	// copies are deep and keep the expiration
+	assert.Equal(protcl.NewIntegerReply(1), execute(c, "copy", "ks:s", "ks:copy").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "copy", "ks:str", "ks:copy").Reply)
+	assert.Equal(protcl.NewIntegerReply(1), execute(c, "copy", "ks:str", "ks:str2", "replace").Reply)
+	assert.True(execute(c, "pttl", "ks:str2").Reply.(*protcl.IntegerReply).Value > 0)
+	execute(c, "srem", "ks:copy", "a")
+	assert.Equal(protcl.NewIntegerReply(2), execute(c, "scard", "ks:s").Reply)
+
+	assert.Equal(protcl.NewSimpleStringReply("OK"), execute(c, "rename", "ks:str2", "ks:renamed").Reply)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrNoSuchKey}, execute(c, "rename", "ks:str2", "ks:x").Err)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "renamenx", "ks:renamed", "ks:s").Reply)
+	assert.Equal(protcl.NewIntegerReply(1), execute(c, "renamenx", "ks:renamed", "ks:str2").Reply)
+
+	assert.Equal(protcl.NewIntegerReply(2), execute(c, "touch", "ks:s", "ks:copy", "ks:missing").Reply)
+	assert.Equal(protcl.NewIntegerReply(4), execute(c, "unlink", "ks:s", "ks:copy", "ks:str", "ks:str2").Reply)
+	assert.Equal(&protcl.ErrSyntax{}, execute(c, "flushdb", "foo").Err)
+}
+
+func TestClient_Flush(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	execute(c, "set", "flush:a", "1")
+	assert.True(execute(c, "dbsize").Reply.(*protcl.IntegerReply).Value > 0)
+	assert.Nil(execute(c, "flushall", "async").Err)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "dbsize").Reply)
+
+	execute(c, "set", "flush:a", "1")
+	assert.Nil(execute(c, "flushdb").Err)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "dbsize").Reply)
+}
+
+func TestClient_Select(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+	other := newClient(nil)
+
+	assert.Equal(&protcl.ErrGeneric{Err: db.ErrInvalidDBIndex}, execute(c, "select", "16").Err)
+	assert.Equal(&protcl.ErrCastFailedToInt{Val: "a"}, execute(c, "select", "a").Err)
+	assert.Nil(execute(c, "select", "1").Err)
+	execute(c, "set", "select:a", "1")
+	assert.Equal(protcl.NewIntegerReply(0), execute(other, "exists", "select:a").Reply)
+
+	// MOVE
+	assert.Equal(protcl.NewIntegerReply(1), execute(c, "move", "select:a", "0").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "move", "select:a", "0").Reply)
+	assert.Equal(protcl.NewIntegerReply(1), execute(other, "exists", "select:a").Reply)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrSameDB}, execute(other, "move", "select:a", "0").Err)
+	assert.Equal(&protcl.ErrGeneric{Err: db.ErrInvalidDBIndex}, execute(other, "move", "select:a", "16").Err)
+
+	// SWAPDB aborts the transactions watching keys of the swapped databases
+	execute(other, "watch", "select:a")
+	assert.Nil(execute(c, "swapdb", "0", "1").Err)
+	assert.Equal("$1\r\n1\r\n", execute(c, "get", "select:a").RespReply())
+	assert.Equal(protcl.NewIntegerReply(0), execute(other, "exists", "select:a").Reply)
+	execute(other, "multi")
+	execute(other, "set", "select:a", "2")
+	assert.Equal("*-1\r\n", execute(other, "exec").RespReply())
+
+	// SELECT is queued and switches the database of the commands queued after it
+	queued := protcl.NewSimpleStringReply("QUEUED")
+	execute(c, "multi")
+	assert.Equal(queued, execute(c, "select", "0").Reply)
+	assert.Equal(queued, execute(c, "set", "select:c", "1").Reply)
+	assert.Equal(queued, execute(c, "client", "id").Reply)
+	assert.Equal(queued, execute(c, "info", "keyspace").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), execute(other, "exists", "select:c").Reply)
+	rep := execute(c, "exec").RespReply()
+	assert.Contains(rep, "*4\r\n+OK\r\n+OK\r\n:0\r\n$")
+	assert.Contains(rep, "db0:keys=")
+	assert.Equal(0, c.db.ID())
+	assert.Equal(protcl.NewIntegerReply(1), execute(other, "exists", "select:c").Reply)
+
+	execute(c, "multi")
+	assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "select"}, execute(c, "select").Err)
+	assert.Equal(&protcl.ErrExecAbort{}, execute(c, "exec").Err)
+
+	// FLUSHALL removes the keys of every database
+	execute(other, "set", "select:b", "1")
+	assert.Nil(execute(c, "flushall").Err)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "dbsize").Reply)
+	assert.Equal(protcl.NewIntegerReply(0), execute(other, "dbsize").Reply)
+}
+
+func TestClient_Blocking(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+	other := newClient(nil)
+
+	// the timeout elapses
+	start := time.Now()
+	assert.Equal("*-1\r\n", execute(c, "blpop", "blocked", "0.05").RespReply())
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+
+	// a push by another client wakes the client up
+	replies := make(chan *protcl.Message)
+	go func() {
+		replies <- execute(c, "blpop", "blocked", "0")
+	}()
+
+	waitBlocked(1)
+	execute(other, "rpush", "blocked", "v")
+	assert.Equal("*2\r\n$7\r\nblocked\r\n$1\r\nv\r\n", (<-replies).RespReply())
+
+	// nothing is replied to a client which disconnected
+	closed := make(chan struct{})
+	c.closed = closed
+	go func() {
+		replies <- execute(c, "bzpopmax", "blocked", "0")
+	}()
+	close(closed)
+	assert.Nil(<-replies)
+	waitBlocked(0)
+}
+
+// waitBlocked waits until clients are blocked on n keys
+func waitBlocked(n int) {
+	for {
+		DB.Lock()
+		blocked := DB.BlockedKeys()
+		DB.Unlock()
+
+		if blocked == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClients_Admit(t *testing.T) {
+	assert := testifyAssert.New(t)
+	clients := &Clients{}
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	assert.True(clients.admit(server, 1))
+
+	// the connection beyond the limit receives an error and is closed
+	server, conn = net.Pipe()
+	go clients.admit(server, 1)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("-ERR max number of clients reached\r\n", reply)
+
+	rejected, _ := clients.stats()
+	assert.Equal(1, rejected)
+	assert.Equal(1, clients.count())
+}
+
+func TestHandleConnection_IdleTimeout(t *testing.T) {
+	assert := testifyAssert.New(t)
+	conf := config.Get()
+	conf.MaxTimeout = 1
+	config.Set(conf)
+	defer func() {
+		conf.MaxTimeout = 0
+		config.Set(conf)
+	}()
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	ConnectedClients.increase()
+	go handleConnection(server)
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("PING\r\n"))
+	reply, _ := reader.ReadString('\n')
+	assert.Equal("+PONG\r\n", reply)
+
+	// an idle client is disconnected once the timeout elapses
+	start := time.Now()
+	_, err := reader.ReadString('\n')
+	assert.Equal(io.EOF, err)
+	assert.True(time.Since(start) >= 900*time.Millisecond)
+
+	_, timedOut := ConnectedClients.stats()
+	assert.Equal(1, timedOut)
+}
+
+func TestClient_Shutdown(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	assert.Equal(&protcl.ErrSyntax{}, execute(c, "shutdown", "later").Err)
+	assert.Equal(&protcl.ErrWrongNumberOfArgs{Cmd: "shutdown"}, execute(c, "shutdown", "save", "now").Err)
+
+	execute(c, "multi")
+	assert.Equal(&protcl.ErrInsideMulti{Cmd: "shutdown"}, execute(c, "shutdown").Err)
+	execute(c, "discard")
+
+	// nothing is replied, the server is asked to shut down
+	assert.Nil(execute(c, "shutdown", "NOSAVE"))
+	assert.Equal(shutdownNoSave, <-shutdownRequests)
+}
+
+func TestClients_CloseAll(t *testing.T) {
+	// nothing reads from the other end, the reply is stuck until the connection is closed
+	server, conn := net.Pipe()
+	defer conn.Close()
+
+	c := newClient(server)
+	ConnectedClients.register(c)
+	defer ConnectedClients.unregister(c)
+
+	written := make(chan struct{})
+	go func() {
+		c.reply(protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil))
+		close(written)
+	}()
+
+	ConnectedClients.closeAll()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("closing the clients did not unblock a stuck write")
+	}
+}
+
+func TestPersistOnShutdown(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	conf := config.Get()
+	conf.Dir, conf.DbFilename = dir, "dump.kdb"
+	config.Set(conf)
+	path := filepath.Join(dir, "dump.kdb")
+
+	assert.Nil(persistOnShutdown(shutdownDefault, false))
+	assert.Nil(persistOnShutdown(shutdownNoSave, true))
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err))
+
+	assert.Nil(persistOnShutdown(shutdownDefault, true))
+	_, err = os.Stat(path)
+	assert.Nil(err)
+}
+
+func TestClient_ClientCommand(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+	other := newClient(nil)
+	ConnectedClients.register(c)
+	ConnectedClients.register(other)
+	defer ConnectedClients.unregister(c)
+	defer ConnectedClients.unregister(other)
+
+	assert.Equal(protcl.NewIntegerReply(int(c.id)), execute(c, "client", "id").Reply)
+	assert.Equal(protcl.NewBulkStringReply(true, ""), execute(c, "client", "getname").Reply)
+	assert.Equal(&protcl.ErrGeneric{Err: ErrInvalidClientName}, execute(c, "client", "setname", "a b").Err)
+	assert.Nil(execute(c, "client", "setname", "worker").Err)
+	assert.Equal(protcl.NewBulkStringReply(false, "worker"), execute(c, "client", "getname").Reply)
+	assert.Equal(&protcl.ErrUnknownCommand{Cmd: "client foo"}, execute(c, "client", "foo").Err)
+
+	other.writer = bufio.NewWriter(ioutil.Discard)
+	other.interact("subscribe")
+	execute(other, "subscribe", "news")
+	other.refresh()
+	defer execute(other, "unsubscribe")
+
+	info := execute(c, "client", "info").Reply.(*protcl.BulkStringReply).Value
+	assert.Contains(info, "name=worker ")
+	assert.Contains(info, "flags=N db=0 sub=0 psub=0 multi=-1")
+
+	list := execute(c, "client", "list", "type", "pubsub").Reply.(*protcl.BulkStringReply).Value
+	assert.Equal(fmt.Sprintf("id=%d addr= name= age=0 idle=0 flags=P db=0 sub=1 psub=0 multi=-1 cmd=subscribe\n", other.id), list)
+	list = execute(c, "client", "list", "id", strconv.Itoa(int(c.id))).Reply.(*protcl.BulkStringReply).Value
+	assert.Contains(list, "name=worker")
+	assert.NotContains(list, "flags=P")
+	assert.NotNil(execute(c, "client", "list", "type", "foo").Err)
+
+	// the client running the command is skipped by default
+	assert.Equal(&protcl.ErrGeneric{Err: ErrNoSuchClient}, execute(c, "client", "kill", "127.0.0.1:1").Err)
+	assert.Equal(protcl.NewIntegerReply(0), execute(c, "client", "kill", "id", strconv.Itoa(int(c.id))).Reply)
+	assert.Equal(protcl.NewIntegerReply(1), execute(c, "client", "kill", "type", "pubsub").Reply)
+	killed := false
+	select {
+	case <-other.killed:
+		killed = true
+	default:
+	}
+	assert.True(killed)
+}
+
+func TestClient_Pause(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+	other := newClient(nil)
+
+	assert.Equal(&protcl.ErrGeneric{Err: ErrInvalidPauseTimeout}, execute(c, "client", "pause", "-1").Err)
+	assert.Equal(&protcl.ErrSyntax{}, execute(c, "client", "pause", "10", "some").Err)
+
+	// only write commands are held
+	assert.Nil(execute(c, "client", "pause", "50", "write").Err)
+	start := time.Now()
+	execute(other, "get", "paused")
+	assert.True(time.Since(start) < 50*time.Millisecond)
+	other.waited = 0
+	execute(other, "set", "paused", "1")
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+	// the time held is not counted as execution time
+	assert.True(other.waited >= 40*time.Millisecond)
+
+	// unpausing resumes the held clients
+	assert.Nil(execute(c, "client", "pause", "10000").Err)
+	done := make(chan struct{})
+	go func() {
+		execute(other, "get", "paused")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(execute(c, "client", "unpause").Err)
+	<-done
+	execute(other, "del", "paused")
+}
+
+func TestClient_Config(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+	d := db.NewDB()
+	c.db = d
+
+	saved := config.Get()
+	defer config.Set(saved)
+	config.Set(config.AppConfig{Port: 7088, MaxClients: 100, MaxMemory: "0", MaxMemoryPolicy: db.NoEviction, MaxMemorySamples: 5})
+
+	assert.Equal("*4\r\n$10\r\nmaxclients\r\n$3\r\n100\r\n$10\r\nmaxtimeout\r\n$1\r\n0\r\n",
+		execute(c, "config", "get", "MAXclients", "max*out").RespReply())
+	assert.Equal("*2\r\n$4\r\nport\r\n$4\r\n7088\r\n", execute(c, "config", "get", "port", "nothing").RespReply())
+
+	assert.Nil(execute(c, "config", "set", "maxClients", "10").Err)
+	assert.Equal(10, config.Get().MaxClients)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrUnknownConfig}, execute(c, "config", "set", "foo", "1").Err)
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrImmutableConfig}, execute(c, "config", "set", "port", "1").Err)
+	assert.NotNil(execute(c, "config", "set", "maxclients", "-1").Err)
+	assert.NotNil(execute(c, "config", "set", "maxmemorySamples", "0").Err)
+	assert.NotNil(execute(c, "config", "set", "maxmemoryPolicy", "sometimes").Err)
+	assert.Equal(db.NoEviction, config.Get().MaxMemoryPolicy)
+
+	// the memory limit is applied right away
+	execute(c, "set", "a", "1")
+	assert.Nil(execute(c, "config", "set", "maxmemoryPolicy", db.AllKeysRandom).Err)
+	assert.Nil(execute(c, "config", "set", "maxmemory", "1").Err)
+	assert.Equal(0, d.Size())
+	maxMemory, policy := d.MaxMemory()
+	assert.Equal(int64(1), maxMemory)
+	assert.Equal(db.AllKeysRandom, policy)
+	assert.NotNil(execute(c, "config", "set", "maxmemory", "lots").Err)
+
+	// rewrite keeps the comments and the line endings of the file
+	config.File = ""
+	assert.Equal(&protcl.ErrGeneric{Err: cmds.ErrNoConfigFile}, execute(c, "config", "rewrite").Err)
+
+	dir, err := ioutil.TempDir("", "kache")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	config.File = filepath.Join(dir, "kache.toml")
+	defer func() { config.File = "" }()
+	assert.Nil(ioutil.WriteFile(config.File, []byte("# limits\r\rport=7088\rmaxClients = 5 # per node\rlogfile = \"a#b\" # where\rsave = [\r  \"900 1\",\r]\r"), 0644))
+	assert.Nil(execute(c, "config", "rewrite").Err)
+
+	// inline comments are kept and values spanning several lines are left as is
+	data, err := ioutil.ReadFile(config.File)
+	assert.Nil(err)
+	assert.Equal("# limits\r\rport=7088\rmaxClients = 10 # per node\rlogfile = \"\" # where\rsave = [\r  \"900 1\",\r]\r"+
+		"maxmemory=\"1\"\rmaxmemoryPolicy=\"allkeys-random\"\r", string(data))
+
+	// the config is queued in a transaction
+	execute(c, "multi")
+	assert.Equal(protcl.NewSimpleStringReply("QUEUED"), execute(c, "config", "get", "port").Reply)
+	assert.Equal("*1\r\n*2\r\n$4\r\nport\r\n$4\r\n7088\r\n", execute(c, "exec").RespReply())
+}
@@ -0,0 +1,383 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/arch"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+var (
+	ErrNoSuchClient        = errors.New("no such client")
+	ErrInvalidClientName   = errors.New("client names cannot contain spaces, newlines or special characters")
+	ErrInvalidPauseTimeout = errors.New("timeout is not an integer or out of range")
+)
+
+// clientPause holds the commands of the clients while CLIENT PAUSE is in effect
+var clientPause struct {
+	mux     sync.Mutex
+	until   time.Time
+	all     bool          // all commands are held, otherwise only the write commands
+	resumed chan struct{} // closed when the clients are unpaused
+}
+
+// pauseClients holds the commands of the clients for d, only the write commands unless all is set
+func pauseClients(d time.Duration, all bool) {
+	clientPause.mux.Lock()
+	defer clientPause.mux.Unlock()
+
+	clientPause.until = time.Now().Add(d)
+	clientPause.all = all
+	if clientPause.resumed == nil {
+		clientPause.resumed = make(chan struct{})
+	}
+}
+
+// unpauseClients resumes the clients held by CLIENT PAUSE
+func unpauseClients() {
+	clientPause.mux.Lock()
+	defer clientPause.mux.Unlock()
+
+	clientPause.until = time.Time{}
+	if clientPause.resumed != nil {
+		close(clientPause.resumed)
+		clientPause.resumed = nil
+	}
+}
+
+// pausedFor returns how long a command is held, along with a channel closed if the clients are resumed earlier
+func pausedFor(write bool) (time.Duration, <-chan struct{}) {
+	clientPause.mux.Lock()
+	defer clientPause.mux.Unlock()
+
+	if !clientPause.all && !write {
+		return 0, nil
+	}
+
+	return time.Until(clientPause.until), clientPause.resumed
+}
+
+// writes reports whether one of the commands modifies the key space
+func writes(commands ...protcl.RespCommand) bool {
+	for _, cmd := range commands {
+		if command, ok := arch.CommandTable[cmd.Name]; (ok && command.ModifyKeySpace) || cmd.Name == "publish" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// waitPause holds the client while the clients are paused, false is returned if the client was
+// disconnected meanwhile
+func (c *client) waitPause(write bool) bool {
+	start := time.Now()
+	defer func() { c.waited += time.Since(start) }()
+
+	for {
+		wait, resumed := pausedFor(write)
+		if wait <= 0 {
+			return true
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-resumed:
+			timer.Stop()
+		case <-c.closed:
+			timer.Stop()
+			return false
+		case <-c.killed:
+			timer.Stop()
+			return false
+		case <-shuttingDown:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// clientType returns the type of a client as used by CLIENT LIST and CLIENT KILL
+func clientType(info clientInfo) string {
+	switch {
+	case strings.Contains(info.flags, "S"):
+		return "replica"
+	case strings.Contains(info.flags, "P"):
+		return "pubsub"
+	}
+
+	return "normal"
+}
+
+// parseClientType parses the type of clients given to CLIENT LIST and CLIENT KILL
+func parseClientType(t string) (string, error) {
+	switch t = strings.ToLower(t); t {
+	case "normal", "replica", "pubsub", "master":
+		return t, nil
+	case "slave":
+		return "replica", nil
+	}
+
+	return "", fmt.Errorf("unknown client type '%s'", t)
+}
+
+// describe returns the line of the client shown by CLIENT LIST and CLIENT INFO
+func (c *client) describe(now time.Time) string {
+	info := c.snapshot()
+
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d cmd=%s\n",
+		c.id, c.addr, info.name, int(now.Sub(c.created).Seconds()), int(now.Sub(info.lastInteraction).Seconds()),
+		info.flags, info.db, info.sub, info.psub, info.multi, info.lastCmd)
+}
+
+// clientCmd manages the connections with the ID, INFO, LIST, SETNAME, GETNAME, KILL, PAUSE and UNPAUSE
+// subcommands
+func (c *client) clientCmd(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) == 0 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	sub, args := strings.ToLower(cmd.Args[0]), cmd.Args[1:]
+	switch sub {
+	case "id":
+		if len(args) != 0 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "client " + sub})
+		}
+
+		return protcl.NewMessage(protcl.NewIntegerReply(int(c.id)), nil)
+	case "info":
+		if len(args) != 0 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "client " + sub})
+		}
+
+		return protcl.NewMessage(protcl.NewBulkStringReply(false, c.describe(time.Now())), nil)
+	case "list":
+		return clientList(args)
+	case "getname":
+		if len(args) != 0 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "client " + sub})
+		}
+
+		name := c.snapshot().name
+		return protcl.NewMessage(protcl.NewBulkStringReply(name == "", name), nil)
+	case "setname":
+		return c.clientSetName(args)
+	case "kill":
+		return c.clientKill(args)
+	case "pause":
+		return clientPauseCmd(args)
+	case "unpause":
+		if len(args) != 0 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "client " + sub})
+		}
+
+		unpauseClients()
+		return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+	}
+
+	return protcl.NewMessage(nil, &protcl.ErrUnknownCommand{Cmd: "client " + cmd.Args[0]})
+}
+
+// clientList replies with a line for each client, TYPE and ID filter the clients which are listed
+func clientList(args []string) *protcl.Message {
+	var typ string
+	var ids map[uint64]bool
+
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "type":
+			if len(args) != 2 {
+				return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+			}
+
+			t, err := parseClientType(args[1])
+			if err != nil {
+				return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+			}
+			typ = t
+		case "id":
+			if len(args) < 2 {
+				return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+			}
+
+			ids = make(map[uint64]bool)
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseUint(arg, 10, 64)
+				if err != nil {
+					return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: arg})
+				}
+				ids[id] = true
+			}
+		default:
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+	}
+
+	now := time.Now()
+	builder := strings.Builder{}
+	for _, cl := range ConnectedClients.list() {
+		if (typ != "" && clientType(cl.snapshot()) != typ) || (ids != nil && !ids[cl.id]) {
+			continue
+		}
+		builder.WriteString(cl.describe(now))
+	}
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, builder.String()), nil)
+}
+
+func (c *client) clientSetName(args []string) *protcl.Message {
+	if len(args) != 1 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "client setname"})
+	}
+
+	for _, r := range args[0] {
+		if r < '!' || r > '~' {
+			return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrInvalidClientName})
+		}
+	}
+
+	c.smux.Lock()
+	c.status.name = args[0]
+	c.smux.Unlock()
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// clientKill disconnects the client with the given address, or the clients matching the ID, ADDR
+// and TYPE filters. The client running the command is skipped unless SKIPME no is given
+func (c *client) clientKill(args []string) *protcl.Message {
+	if len(args) == 0 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "client kill"})
+	}
+
+	// the old form only takes the address of a client
+	if len(args) == 1 {
+		for _, cl := range ConnectedClients.list() {
+			if cl.addr == args[0] {
+				c.killClient(cl)
+				return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+			}
+		}
+
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrNoSuchClient})
+	}
+
+	if len(args)%2 != 0 {
+		return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+	}
+
+	var id uint64
+	var addr, typ string
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		val := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "id":
+			n, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return protcl.NewMessage(nil, &protcl.ErrCastFailedToInt{Val: val})
+			}
+			id = n
+		case "addr":
+			addr = val
+		case "type":
+			t, err := parseClientType(val)
+			if err != nil {
+				return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: err})
+			}
+			typ = t
+		case "skipme":
+			switch strings.ToLower(val) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+			}
+		default:
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+	}
+
+	killed := 0
+	for _, cl := range ConnectedClients.list() {
+		if (id != 0 && cl.id != id) || (addr != "" && cl.addr != addr) ||
+			(typ != "" && clientType(cl.snapshot()) != typ) || (skipMe && cl == c) {
+			continue
+		}
+
+		c.killClient(cl)
+		killed++
+	}
+
+	return protcl.NewMessage(protcl.NewIntegerReply(killed), nil)
+}
+
+// killClient disconnects cl, a client killing itself is disconnected once it receives the reply
+func (c *client) killClient(cl *client) {
+	if cl == c {
+		cl.kill()
+		return
+	}
+
+	cl.disconnect()
+}
+
+// clientPauseCmd holds the commands of the clients for the given milliseconds, WRITE only holds
+// the write commands and ALL, the default, holds every command
+func clientPauseCmd(args []string) *protcl.Message {
+	if len(args) != 1 && len(args) != 2 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: "client pause"})
+	}
+
+	ms, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || ms < 0 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: ErrInvalidPauseTimeout})
+	}
+
+	all := true
+	if len(args) == 2 {
+		switch strings.ToLower(args[1]) {
+		case "all":
+		case "write":
+			all = false
+		default:
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+	}
+
+	pauseClients(time.Duration(ms)*time.Millisecond, all)
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
@@ -26,15 +26,21 @@ package srv
 
 import (
 	"net"
+	"sort"
 	"sync"
 
 	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
 )
 
 var ConnectedClients Clients
 
 type Clients struct {
 	numClients int
+	rejected   int                // connections rejected because of the limit of clients
+	timedOut   int                // connections closed because the client was idle for too long
+	lastID     uint64             // id of the last registered client
+	clients    map[uint64]*client // connected clients by their id
 	mux        sync.Mutex
 }
 
@@ -52,9 +58,53 @@ func (c *Clients) decrease() {
 	c.numClients--
 }
 
+func (c *Clients) count() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.numClients
+}
+
+// stats returns the number of rejected and timed out connections
+func (c *Clients) stats() (rejected, timedOut int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.rejected, c.timedOut
+}
+
+// admit registers a new connection, the connection is rejected with an error and closed when max
+// clients are already connected. 0 means no limit
+func (c *Clients) admit(conn net.Conn, max int) bool {
+	c.mux.Lock()
+	if max > 0 && c.numClients >= max {
+		c.rejected++
+		c.mux.Unlock()
+
+		klogs.Logger.Info("rejected client from ", conn.RemoteAddr(), ", max number of clients reached")
+		conn.Write([]byte(protcl.RespError(&protcl.ErrMaxClients{})))
+		conn.Close()
+		return false
+	}
+	c.mux.Unlock()
+
+	recordConnection()
+	c.logOnConnect(conn)
+	return true
+}
+
+// logOnTimeout logs a connection closed because the client was idle for too long
+func (c *Clients) logOnTimeout(conn net.Conn) {
+	c.mux.Lock()
+	c.timedOut++
+	c.mux.Unlock()
+
+	klogs.Logger.Info("closing idle client from ", conn.RemoteAddr())
+}
+
 func logOpenedClients() {
-	if ConnectedClients.numClients > 0 {
-		klogs.Logger.Info(ConnectedClients.numClients, " connections are now open")
+	if n := ConnectedClients.count(); n > 0 {
+		klogs.Logger.Info(n, " connections are now open")
 		return
 	}
 
@@ -72,3 +122,47 @@ func (c *Clients) logOnConnect(conn net.Conn) {
 	c.increase()
 	logOpenedClients()
 }
+
+// register adds cl to the registry and assigns its id
+func (c *Clients) register(cl *client) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.clients == nil {
+		c.clients = make(map[uint64]*client)
+	}
+
+	c.lastID++
+	cl.id = c.lastID
+	c.clients[cl.id] = cl
+}
+
+// unregister removes cl from the registry
+func (c *Clients) unregister(cl *client) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.clients, cl.id)
+}
+
+// list returns the registered clients ordered by their id
+func (c *Clients) list() []*client {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	clients := make([]*client, 0, len(c.clients))
+	for _, cl := range c.clients {
+		clients = append(clients, cl)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+
+	return clients
+}
+
+// closeAll closes the connections of all the registered clients, which also unblocks the handlers
+// stuck writing to a client which does not read
+func (c *Clients) closeAll() {
+	for _, cl := range c.list() {
+		cl.disconnect()
+	}
+}
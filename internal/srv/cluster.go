@@ -0,0 +1,100 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kasvith/kache/internal/arch"
+	"github.com/kasvith/kache/internal/cluster"
+	"github.com/kasvith/kache/internal/cmds"
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// startCluster joins the cluster and starts the cluster bus when cluster mode is enabled
+func startCluster(config config.AppConfig) {
+	if !config.ClusterEnabled {
+		return
+	}
+
+	path := filepath.Join(config.Dir, config.ClusterConfigFile)
+	timeout := time.Duration(config.ClusterNodeTimeout) * time.Millisecond
+	c, err := cluster.New(config.Host, config.Port, timeout, path)
+	if err != nil {
+		klogs.Logger.Fatalf("error loading the cluster config from %s: %s", path, err)
+	}
+
+	busAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port+cluster.BusPortOffset))
+	if err := c.Listen(busAddr); err != nil {
+		klogs.Logger.Fatalf("error starting the cluster bus on %s: %s", busAddr, err)
+	}
+
+	cluster.Default = c
+	go c.Run()
+	klogs.Logger.Infof("cluster mode enabled, node %s listening to the cluster bus on %s", c.MyID(), busAddr)
+}
+
+// route checks whether the keys of cmd are served by this node when cluster mode is enabled,
+// asking allows accessing the keys of a slot which is being imported
+func route(cmd *protcl.RespCommand, asking bool) error {
+	if cluster.Default == nil {
+		return nil
+	}
+
+	command, ok := arch.CommandTable[cmd.Name]
+	if !ok {
+		return nil
+	}
+
+	// keys restored by MIGRATE always belong to a slot which is being imported
+	asking = asking || cmd.Name == "restore-asking"
+
+	return cluster.Default.Route(command.Keys(cmd.Args), asking, func(key string) bool {
+		DB.Lock()
+		defer DB.Unlock()
+
+		return DB.Exists(key) == 1
+	})
+}
+
+// ask flags the next command of the client to be executed even if its slot is being imported
+func (c *client) ask(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 0 {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if cluster.Default == nil {
+		return c.txError(&protcl.ErrGeneric{Err: cmds.ErrClusterDisabled})
+	}
+
+	c.asking = true
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
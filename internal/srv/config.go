@@ -0,0 +1,45 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"strings"
+
+	"github.com/kasvith/kache/internal/cmds"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// configCmd inspects and changes the settings of the server, the config file is written by REWRITE
+// without holding the lock of the databases
+func (c *client) configCmd(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) == 1 && strings.ToLower(cmd.Args[0]) == "rewrite" {
+		return cmds.ConfigRewrite()
+	}
+
+	c.db.Lock()
+	defer c.db.Unlock()
+
+	return cmds.Config(c.db, cmd.Args)
+}
@@ -0,0 +1,185 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// build information of the server shown by INFO
+var buildInfo struct {
+	version, buildTime, gitHash string
+}
+
+// SetBuildInfo sets the version of the server and when and from which commit it was built
+func SetBuildInfo(version, buildTime, gitHash string) {
+	buildInfo.version, buildInfo.buildTime, buildInfo.gitHash = version, buildTime, gitHash
+}
+
+// infoSections are the sections of INFO in the order they are shown, each one returns its lines while
+// the lock of the databases is held. Sections which are not shown by default are only shown with all
+// or when they are requested
+var infoSections = []struct {
+	name     string
+	lines    func() []string
+	optional bool
+}{
+	{"server", serverInfo, false},
+	{"clients", clientsInfo, false},
+	{"memory", memoryInfo, false},
+	{"stats", statsInfo, false},
+	{"replication", replicationInfo, false},
+	{"commandstats", commandStatsInfo, true},
+	{"keyspace", keyspaceInfo, false},
+}
+
+// info replies with the sections of INFO, all of them are shown when no section is given
+func (c *client) info(cmd *protcl.RespCommand) *protcl.Message {
+	DB.Lock()
+	defer DB.Unlock()
+
+	return c.infoLocked(cmd)
+}
+
+// infoLocked replies with the sections of INFO, the caller must hold the lock of the databases
+func (c *client) infoLocked(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) > 1 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	section := "default"
+	if len(cmd.Args) == 1 {
+		section = strings.ToLower(cmd.Args[0])
+	}
+
+	var sections []string
+	for _, s := range infoSections {
+		if section == "all" || (section == "default" && !s.optional) || section == s.name {
+			sections = append(sections, "# "+strings.Title(s.name)+"\r\n"+strings.Join(s.lines(), "\r\n")+"\r\n")
+		}
+	}
+
+	return protcl.NewMessage(protcl.NewBulkStringReply(false, strings.Join(sections, "\r\n")), nil)
+}
+
+// memoryInfo returns the memory section of INFO
+func memoryInfo() []string {
+	maxMemory, policy := DB.MaxMemory()
+
+	return []string{
+		fmt.Sprintf("used_memory:%d", DB.UsedMemory()),
+		fmt.Sprintf("maxmemory:%d", maxMemory),
+		"maxmemory_policy:" + policy,
+	}
+}
+
+// serverInfo returns the server section of INFO
+func serverInfo() []string {
+	uptime := time.Since(serverStats.started)
+
+	return []string{
+		"kache_version:" + buildInfo.version,
+		"kache_git_sha1:" + buildInfo.gitHash,
+		"kache_build_time:" + buildInfo.buildTime,
+		"os:" + runtime.GOOS,
+		"arch:" + runtime.GOARCH,
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("tcp_port:%d", config.Get().Port),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime.Seconds())),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime.Hours()/24)),
+	}
+}
+
+// clientsInfo returns the clients section of INFO
+func clientsInfo() []string {
+	blocked := 0
+	for _, cl := range ConnectedClients.list() {
+		if strings.Contains(cl.snapshot().flags, "b") {
+			blocked++
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("connected_clients:%d", ConnectedClients.count()),
+		fmt.Sprintf("blocked_clients:%d", blocked),
+		fmt.Sprintf("maxclients:%d", config.Get().MaxClients),
+	}
+}
+
+// statsInfo returns the stats section of INFO
+func statsInfo() []string {
+	rejected, timedOut := ConnectedClients.stats()
+
+	serverStats.mux.Lock()
+	connections, processed := serverStats.connections, serverStats.processed
+	serverStats.mux.Unlock()
+
+	hits, misses := DB.KeyspaceStats()
+
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", connections),
+		fmt.Sprintf("total_commands_processed:%d", processed),
+		fmt.Sprintf("instantaneous_ops_per_sec:%d", opsPerSec()),
+		fmt.Sprintf("rejected_connections:%d", rejected),
+		fmt.Sprintf("timedout_connections:%d", timedOut),
+		fmt.Sprintf("evicted_keys:%d", DB.Evicted()),
+		fmt.Sprintf("keyspace_hits:%d", hits),
+		fmt.Sprintf("keyspace_misses:%d", misses),
+	}
+}
+
+// commandStatsInfo returns the commandstats section of INFO, the calls of each executed command and
+// the time spent executing them in microseconds
+func commandStatsInfo() []string {
+	var lines []string
+	for _, stat := range commandStats() {
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f",
+			stat.name, stat.calls, stat.usec, float64(stat.usec)/float64(stat.calls)))
+	}
+
+	return lines
+}
+
+// keyspaceInfo returns the keyspace section of INFO, the number of keys and keys with an expiration
+// of each database holding keys
+func keyspaceInfo() []string {
+	var lines []string
+	for i := 0; i < DB.Databases(); i++ {
+		d, _ := DB.Select(i)
+		if d.Size() > 0 {
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d", i, d.Size(), d.Expires()))
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,123 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/internal/pubsub"
+)
+
+// maxPendingMessages is the number of published messages queued for a subscriber before it is disconnected
+const maxPendingMessages = 1024
+
+// subscriptions returns the number of channels and patterns the client is subscribed to,
+// a client with subscriptions is in subscriber mode
+func (c *client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// executeSubscribed executes commands allowed in subscriber mode
+func (c *client) executeSubscribed(cmd *protcl.RespCommand) *protcl.Message {
+	switch cmd.Name {
+	case "subscribe":
+		return c.subscribe(cmd, c.channels, pubsub.Default.Subscribe)
+	case "psubscribe":
+		return c.subscribe(cmd, c.patterns, pubsub.Default.PSubscribe)
+	case "unsubscribe":
+		return c.unsubscribe(cmd, c.channels, pubsub.Default.Unsubscribe)
+	case "punsubscribe":
+		return c.unsubscribe(cmd, c.patterns, pubsub.Default.PUnsubscribe)
+	case "ping":
+		if len(cmd.Args) > 1 {
+			return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+		}
+
+		arg := ""
+		if len(cmd.Args) == 1 {
+			arg = cmd.Args[0]
+		}
+
+		return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{
+			protcl.NewBulkStringReply(false, "pong"),
+			protcl.NewBulkStringReply(false, arg),
+		}), nil)
+	}
+
+	return protcl.NewMessage(nil, &protcl.ErrPubSubContext{Cmd: cmd.Name})
+}
+
+// subscribe adds the subscriptions given in args, replying once for each of them. Each reply is written
+// before the client is registered with the hub, otherwise a message pushed by pushMessages could precede it
+func (c *client) subscribe(cmd *protcl.RespCommand, subs map[string]struct{}, fn func(pubsub.Subscriber, string) bool) *protcl.Message {
+	if len(cmd.Args) == 0 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	for _, name := range cmd.Args {
+		subs[name] = struct{}{}
+		c.reply(protcl.NewMessage(pubsub.NewSubscriptionReply(cmd.Name, name, c.subscriptions()), nil))
+		fn(c, name)
+	}
+
+	return nil
+}
+
+// unsubscribe removes the subscriptions given in args or all of them when no args are given
+func (c *client) unsubscribe(cmd *protcl.RespCommand, subs map[string]struct{}, fn func(pubsub.Subscriber, string) bool) *protcl.Message {
+	names := cmd.Args
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+	}
+
+	// nothing to unsubscribe, still the client is notified
+	if len(names) == 0 {
+		return protcl.NewMessage(pubsub.NewSubscriptionReply(cmd.Name, "", c.subscriptions()), nil)
+	}
+
+	replies := make([]protcl.Reply, len(names))
+	for i, name := range names {
+		fn(c, name)
+		delete(subs, name)
+		replies[i] = pubsub.NewSubscriptionReply(cmd.Name, name, c.subscriptions())
+	}
+
+	return protcl.NewMessage(protcl.NewMultiReply(replies), nil)
+}
+
+// unsubscribeAll removes every subscription of the client
+func (c *client) unsubscribeAll() {
+	for channel := range c.channels {
+		pubsub.Default.Unsubscribe(c, channel)
+	}
+
+	for pattern := range c.patterns {
+		pubsub.Default.PUnsubscribe(c, pattern)
+	}
+
+	c.channels = make(map[string]struct{})
+	c.patterns = make(map[string]struct{})
+}
@@ -0,0 +1,263 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kasvith/kache/internal/arch"
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/internal/repl"
+)
+
+var (
+	// Primary streams the commands which modified the key space to the replicas of the server
+	Primary = repl.NewPrimary(1024 * 1024)
+
+	replica *repl.Replica // connection to the primary, nil when the server is not a replica
+	replMux sync.Mutex    // guards replica
+)
+
+// startReplication registers the replication stream and connects to the primary given in config
+func startReplication(config config.AppConfig) {
+	Primary = repl.NewPrimary(config.ReplBacklogSize)
+	DB.AddFeed(Primary)
+
+	if config.ReplicaOf == "" {
+		return
+	}
+
+	fields := strings.Fields(config.ReplicaOf)
+	if len(fields) != 2 {
+		klogs.Logger.Fatalf("invalid replicaof %q, excepted <host> <port>", config.ReplicaOf)
+	}
+
+	replicaOf(fields[0], fields[1])
+}
+
+// replicaOf makes the server a replica of the primary at host and port
+func replicaOf(host, port string) {
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	if replica != nil {
+		replica.Stop()
+	}
+
+	replay := arch.NewReplay(DB)
+	replica = repl.NewReplica(host, port, config.Get().Port, DB, Primary, func(cmd string, args []string) {
+		applyReplicated(replay, cmd, args)
+	})
+	replica.Start()
+	klogs.Logger.Infof("replicating from %s", net.JoinHostPort(host, port))
+}
+
+// applyReplicated executes a command streamed by the primary on the database it selected
+func applyReplicated(replay *arch.Replay, cmd string, args []string) {
+	if message := replay.Apply(cmd, args); message.Err != nil {
+		klogs.Logger.Debugf("error applying replicated %s: %s", cmd, message.Err)
+	}
+}
+
+// currentReplica returns the connection to the primary, nil if the server is not a replica
+func currentReplica() *repl.Replica {
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	return replica
+}
+
+// readOnly reports whether cmd is a write rejected because the server is a read only replica
+func readOnly(cmd *protcl.RespCommand) bool {
+	if !config.Get().ReplicaReadOnly || currentReplica() == nil {
+		return false
+	}
+
+	command, ok := arch.CommandTable[cmd.Name]
+	return ok && command.ModifyKeySpace
+}
+
+func (c *client) replicaOf(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 2 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if strings.ToLower(cmd.Args[0]) == "no" && strings.ToLower(cmd.Args[1]) == "one" {
+		replMux.Lock()
+		if replica != nil {
+			replica.Stop()
+			replica = nil
+			Primary.Promote()
+			klogs.Logger.Info("replication stopped, the server is a primary now")
+		}
+		replMux.Unlock()
+
+		return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+	}
+
+	host, port := cmd.Args[0], cmd.Args[1]
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: fmt.Errorf("invalid port %s", port)})
+	}
+
+	if r := currentReplica(); r != nil && r.Host == host && r.Port == port {
+		return protcl.NewMessage(protcl.NewSimpleStringReply("OK Already connected to specified primary"), nil)
+	}
+
+	replicaOf(host, port)
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// replConf handles the configuration sent by a replica before and during the replication,
+// acknowledgements are not replied to
+func (c *client) replConf(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args)%2 != 0 {
+		return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+	}
+
+	for i := 0; i < len(cmd.Args); i += 2 {
+		switch strings.ToLower(cmd.Args[i]) {
+		case "listening-port":
+			c.listeningPort = cmd.Args[i+1]
+		case "ack":
+			offset, err := strconv.ParseInt(cmd.Args[i+1], 10, 64)
+			if err == nil && c.link != nil {
+				c.link.Ack(offset)
+			}
+
+			return nil
+		}
+	}
+
+	return protcl.NewMessage(protcl.NewSimpleStringReply("OK"), nil)
+}
+
+// psync attaches the client as a replica, the reply and the replication stream are written by the link
+func (c *client) psync(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 2 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if c.link != nil {
+		return protcl.NewMessage(nil, &protcl.ErrGeneric{Err: fmt.Errorf("replication already started")})
+	}
+
+	offset, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		offset = -1
+	}
+
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	c.link = Primary.Sync(DB, c, host, cmd.Args[0], offset)
+	c.link.SetPort(c.listeningPort)
+
+	return nil
+}
+
+// role replies with the role of the server in the replication
+func (c *client) role(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) != 0 {
+		return protcl.NewMessage(nil, &protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if r := currentReplica(); r != nil {
+		state, offset := r.State()
+		port, _ := strconv.Atoi(r.Port)
+
+		return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{
+			protcl.NewBulkStringReply(false, "slave"),
+			protcl.NewBulkStringReply(false, r.Host),
+			protcl.NewIntegerReply(port),
+			protcl.NewBulkStringReply(false, state),
+			protcl.NewIntegerReply(int(offset)),
+		}), nil)
+	}
+
+	links := Primary.Links()
+	replicas := make([]protcl.Reply, len(links))
+	for i, link := range links {
+		replicas[i] = protcl.NewArrayReply(false, []protcl.Reply{
+			protcl.NewBulkStringReply(false, link.Addr),
+			protcl.NewBulkStringReply(false, link.Port),
+			protcl.NewBulkStringReply(false, strconv.FormatInt(link.Offset, 10)),
+		})
+	}
+
+	return protcl.NewMessage(protcl.NewArrayReply(false, []protcl.Reply{
+		protcl.NewBulkStringReply(false, "master"),
+		protcl.NewIntegerReply(int(Primary.Offset())),
+		protcl.NewArrayReply(false, replicas),
+	}), nil)
+}
+
+// replicationInfo returns the replication section of INFO
+func replicationInfo() []string {
+	var lines []string
+
+	if r := currentReplica(); r != nil {
+		state, offset := r.State()
+		status := "down"
+		if state == repl.ReplicaConnected {
+			status = "up"
+		}
+
+		syncing, readOnly := 0, 0
+		if state == repl.ReplicaSync {
+			syncing = 1
+		}
+		if config.Get().ReplicaReadOnly {
+			readOnly = 1
+		}
+
+		lines = append(lines,
+			"role:slave",
+			"master_host:"+r.Host,
+			"master_port:"+r.Port,
+			"master_link_status:"+status,
+			fmt.Sprintf("master_sync_in_progress:%d", syncing),
+			fmt.Sprintf("slave_repl_offset:%d", offset),
+			fmt.Sprintf("slave_read_only:%d", readOnly))
+	} else {
+		lines = append(lines, "role:master")
+	}
+
+	links := Primary.Links()
+	lines = append(lines, fmt.Sprintf("connected_slaves:%d", len(links)))
+	for i, link := range links {
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%s,state=%s,offset=%d,lag=%d",
+			i, link.Addr, link.Port, link.State, link.Offset, int64(link.LastAck.Seconds())))
+	}
+
+	return append(lines,
+		"master_replid:"+Primary.ID(),
+		fmt.Sprintf("master_repl_offset:%d", Primary.Offset()))
+}
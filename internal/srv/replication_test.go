@@ -0,0 +1,143 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protcl"
+	"github.com/kasvith/kache/internal/repl"
+)
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+
+	return cond()
+}
+
+func TestReplication(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(conn)
+		}
+	}()
+
+	DB.AddFeed(Primary)
+	defer DB.RemoveFeed(Primary)
+	dbCommand.Execute(DB, "set", []string{"before", "1"})
+
+	replicaDB := db.NewDB()
+	replicaPrimary := repl.NewPrimary(1024)
+	replicaDB.AddFeed(replicaPrimary)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	startReplica := func() *repl.Replica {
+		r := repl.NewReplica(host, port, 7089, replicaDB, replicaPrimary, func(cmd string, args []string) {
+			dbCommand.Apply(replicaDB, cmd, args)
+		})
+		r.Start()
+
+		return r
+	}
+	synced := func(r *repl.Replica) func() bool {
+		return func() bool {
+			state, offset := r.State()
+			return state == repl.ReplicaConnected && offset == Primary.Offset()
+		}
+	}
+
+	// the keys written before are sent with a full resynchronization
+	r := startReplica()
+	assert.True(waitFor(synced(r)))
+	assert.Equal(1, replicaDB.Exists("before"))
+	assert.Equal(Primary.ID(), replicaPrimary.ID())
+	assert.Equal(Primary.Offset(), replicaPrimary.Offset())
+
+	// writes are streamed
+	dbCommand.Execute(DB, "rpush", []string{"list", "a", "b"})
+	dbCommand.Execute(DB, "set", []string{"ttl", "v", "ex", "100"})
+	assert.True(waitFor(synced(r)))
+	assert.Equal(Primary.Offset(), replicaPrimary.Offset())
+	assert.Equal("*2\r\n$1\r\na\r\n$1\r\nb\r\n", dbCommand.Execute(replicaDB, "lrange", []string{"list", "0", "-1"}).RespReply())
+	assert.Equal(DB.TTL("ttl")/1000, replicaDB.TTL("ttl")/1000)
+
+	links := Primary.Links()
+	assert.Len(links, 1)
+	assert.Equal("7089", links[0].Port)
+
+	// a replica which reconnects continues from its offset, keys only it has are kept
+	r.Stop()
+	assert.True(waitFor(func() bool { return len(Primary.Links()) == 0 }))
+	dbCommand.Execute(DB, "del", []string{"before"})
+	replicaDB.Lock()
+	replicaDB.Set("local", db.NewDataNode(db.TypeString, -1, "1"))
+	replicaDB.Unlock()
+
+	r = startReplica()
+	defer r.Stop()
+	assert.True(waitFor(synced(r)))
+	assert.Equal(0, replicaDB.Exists("before"))
+	assert.Equal(1, replicaDB.Exists("local"))
+}
+
+func TestReplication_ReadOnly(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newClient(nil)
+
+	conf := config.Get()
+	conf.ReplicaReadOnly = true
+	config.Set(conf)
+	replMux.Lock()
+	replica = repl.NewReplica("127.0.0.1", "1", 0, DB, Primary, nil)
+	replMux.Unlock()
+	defer func() {
+		replMux.Lock()
+		replica = nil
+		replMux.Unlock()
+	}()
+
+	assert.Equal(&protcl.ErrReadOnlyReplica{}, execute(c, "set", "k", "v").Err)
+	assert.Nil(execute(c, "get", "k").Reply)
+	assert.Equal("*5\r\n$5\r\nslave\r\n$9\r\n127.0.0.1\r\n:1\r\n$7\r\nconnect\r\n:0\r\n", execute(c, "role").RespReply())
+}
@@ -0,0 +1,168 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persist"
+	"github.com/kasvith/kache/internal/protcl"
+)
+
+// how data is persisted on shutdown, by default a snapshot is saved if save rules are configured
+const (
+	shutdownDefault = iota
+	shutdownSave
+	shutdownNoSave
+)
+
+const (
+	// how long connections are given to complete their command once the server shuts down
+	shutdownTimeout = 5 * time.Second
+	// how long the handlers are waited for once the connections are closed
+	shutdownCloseTimeout = time.Second
+)
+
+var (
+	shutdownRequests = make(chan int, 1) // shutdowns requested with SHUTDOWN
+	shuttingDown     = make(chan struct{})
+	handlers         sync.WaitGroup // running connection handlers
+)
+
+// waitShutdown waits for a signal or a SHUTDOWN command and returns how data should be persisted
+func waitShutdown() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case sig := <-signals:
+		klogs.Logger.Infof("received %s, shutting down", sig)
+		return shutdownDefault
+	case mode := <-shutdownRequests:
+		klogs.Logger.Info("shutdown requested by a client")
+		return mode
+	}
+}
+
+// shutdown stops accepting connections and lets the connected clients complete their command before
+// their connections are closed, clients which don't complete within the timeout are disconnected.
+// Data is persisted once no command can run anymore
+func shutdown(listener net.Listener, mode int, saveByDefault bool) {
+	close(shuttingDown)
+	listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		klogs.Logger.Warnf("clients did not complete within %s, closing their connections", shutdownTimeout)
+		ConnectedClients.closeAll()
+
+		select {
+		case <-done:
+		case <-time.After(shutdownCloseTimeout):
+			klogs.Logger.Warnf("connection handlers did not stop within %s", shutdownCloseTimeout)
+		}
+	}
+
+	if err := persistOnShutdown(mode, saveByDefault); err != nil {
+		klogs.Logger.Errorf("error persisting data on shutdown: %s", err)
+	}
+
+	klogs.Logger.Info("kache is now ready to exit, bye bye")
+}
+
+// persistOnShutdown saves a snapshot unless NOSAVE was requested, and flushes the append only file
+func persistOnShutdown(mode int, saveByDefault bool) error {
+	// a running background save is waited for, it would make the foreground save fail
+	for persist.Saving() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	DB.Lock()
+	defer DB.Unlock()
+
+	if persist.AppendOnly != nil {
+		aof := persist.AppendOnly
+		DB.RemoveFeed(aof)
+		persist.AppendOnly = nil
+
+		if err := aof.Close(); err != nil {
+			return err
+		}
+	}
+
+	if mode == shutdownSave || (mode == shutdownDefault && saveByDefault) {
+		klogs.Logger.Infof("saving the snapshot to %s before exiting", persist.SnapshotPath())
+		return persist.Save(DB)
+	}
+
+	return nil
+}
+
+// shutdownCmd requests the server to shut down, SAVE forces a snapshot to be saved and NOSAVE skips
+// it. Nothing is replied, the connection is closed once the server shuts down
+func (c *client) shutdownCmd(cmd *protcl.RespCommand) *protcl.Message {
+	if len(cmd.Args) > 1 {
+		return c.txError(&protcl.ErrWrongNumberOfArgs{Cmd: cmd.Name})
+	}
+
+	if c.tx != nil {
+		return c.txError(&protcl.ErrInsideMulti{Cmd: cmd.Name})
+	}
+
+	mode := shutdownDefault
+	if len(cmd.Args) == 1 {
+		switch strings.ToLower(cmd.Args[0]) {
+		case "save":
+			mode = shutdownSave
+		case "nosave":
+			mode = shutdownNoSave
+		default:
+			return protcl.NewMessage(nil, &protcl.ErrSyntax{})
+		}
+	}
+
+	// a shutdown already requested is not overridden
+	select {
+	case shutdownRequests <- mode:
+	default:
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package srv
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/arch"
+)
+
+// commandStat is the number of calls of a command and the time spent executing them
+type commandStat struct {
+	calls int64
+	usec  int64
+}
+
+// how often the number of processed commands is sampled, and the number of samples averaged to
+// compute the operations per second
+const (
+	opsSampleInterval = 100 * time.Millisecond
+	opsSamples        = 16
+)
+
+// serverStats are the statistics of the server shown by INFO
+var serverStats = struct {
+	mux         sync.Mutex
+	started     time.Time
+	connections int64 // connections accepted since the server started
+	processed   int64 // commands processed since the server started
+	commands    map[string]*commandStat
+
+	samples    [opsSamples]int64 // operations per second of the last samples
+	sampleIdx  int
+	lastSample int64 // commands processed at the last sample
+}{started: time.Now(), commands: make(map[string]*commandStat)}
+
+// recordCommand counts a command executed in d, unknown commands are not counted so that clients
+// can't grow the statistics with arbitrary names
+func recordCommand(name string, d time.Duration) {
+	if _, ok := arch.CommandTable[name]; !ok && !serverCommands[name] {
+		return
+	}
+
+	serverStats.mux.Lock()
+	defer serverStats.mux.Unlock()
+
+	stat, ok := serverStats.commands[name]
+	if !ok {
+		stat = &commandStat{}
+		serverStats.commands[name] = stat
+	}
+
+	stat.calls++
+	stat.usec += d.Nanoseconds() / 1000
+	serverStats.processed++
+}
+
+// recordConnection counts an accepted connection
+func recordConnection() {
+	serverStats.mux.Lock()
+	defer serverStats.mux.Unlock()
+
+	serverStats.connections++
+}
+
+// sampleOps samples the number of processed commands periodically to compute the operations per second
+func sampleOps() {
+	ticker := time.NewTicker(opsSampleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		serverStats.mux.Lock()
+		ops := (serverStats.processed - serverStats.lastSample) * int64(time.Second/opsSampleInterval)
+		serverStats.samples[serverStats.sampleIdx] = ops
+		serverStats.sampleIdx = (serverStats.sampleIdx + 1) % opsSamples
+		serverStats.lastSample = serverStats.processed
+		serverStats.mux.Unlock()
+	}
+}
+
+// opsPerSec returns the average of the sampled operations per second
+func opsPerSec() int64 {
+	serverStats.mux.Lock()
+	defer serverStats.mux.Unlock()
+
+	sum := int64(0)
+	for _, ops := range serverStats.samples {
+		sum += ops
+	}
+
+	return sum / opsSamples
+}
+
+// namedCommandStat is the statistic of a command along with its name
+type namedCommandStat struct {
+	name string
+	commandStat
+}
+
+// commandStats returns the statistics of the executed commands ordered by their names
+func commandStats() []namedCommandStat {
+	serverStats.mux.Lock()
+	defer serverStats.mux.Unlock()
+
+	stats := make([]namedCommandStat, 0, len(serverStats.commands))
+	for name, stat := range serverStats.commands {
+		stats = append(stats, namedCommandStat{name: name, commandStat: *stat})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].name < stats[j].name })
+
+	return stats
+}
@@ -25,11 +25,11 @@
 package srv
 
 import (
-	"bufio"
 	"io"
 	"net"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/kasvith/kache/internal/arch"
 	"github.com/kasvith/kache/internal/config"
@@ -41,41 +41,122 @@ import (
 var DB = db.NewDB()
 var dbCommand = &arch.DBCommand{}
 
+// InitDatabases creates the n databases of the server, DB is the first one
+func InitDatabases(n int) {
+	DB = db.NewDBs(n)[0]
+}
+
+// how often keys with an expiration are sampled for removal
+const activeExpireInterval = 100 * time.Millisecond
+
+// expireKeys runs the active expiry cycle of the database periodically
+func expireKeys(d *db.DB) {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.ActiveExpireCycle()
+	}
+}
+
+// parsed is a command read from a connection, or the error met while parsing it
+type parsed struct {
+	command *protcl.RespCommand
+	err     error
+}
+
+// readCommands parses the commands sent on a connection, closed is closed once the connection is
+// closed by the client. Commands are read in the background so that a blocked client notices it,
+// reading stops once done is closed
+func readCommands(reader *protcl.Reader, commands chan<- parsed, closed chan<- struct{}, done <-chan struct{}) {
+	defer close(closed)
+
+	for {
+		command, err := reader.ParseMessage()
+		if err == io.EOF {
+			return
+		}
+
+		select {
+		case commands <- parsed{command: command, err: err}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// idleTimeout returns how long a client can stay idle before it is disconnected, 0 means forever.
+// Subscribers and replicas are not disconnected since they only wait for messages
+func (c *client) idleTimeout() time.Duration {
+	if c.subscriptions() > 0 || c.link != nil {
+		return 0
+	}
+
+	return time.Duration(config.Get().MaxTimeout) * time.Second
+}
+
 func handleConnection(conn net.Conn) {
 	// TODO determine client type by first issued command to kache, this can improve performance
 
-	reader := protcl.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+	commands := make(chan parsed)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go readCommands(protcl.NewReader(conn), commands, closed, done)
+
+	client := newClient(conn)
+	client.closed = closed
+	ConnectedClients.register(client)
 	defer conn.Close()
+	defer client.close()
+	defer ConnectedClients.unregister(client)
+	defer close(done)
+	defer ConnectedClients.logOnDisconnect(conn)
 
 	for {
-		command, err := reader.ParseMessage()
+		// blocked clients are parked in execute, so the idle timer never runs while they wait
+		var timer *time.Timer
+		var idle <-chan time.Time
+		if timeout := client.idleTimeout(); timeout > 0 {
+			timer = time.NewTimer(timeout)
+			idle = timer.C
+		}
 
-		if err != nil {
-			// if eof stop now
-			if err == io.EOF {
-				break
-			}
+		var p parsed
+		select {
+		case p = <-commands:
+		case <-closed:
+			return
+		case <-idle:
+			ConnectedClients.logOnTimeout(conn)
+			return
+		case <-shuttingDown:
+			return
+		case <-client.killed:
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
 
+		if p.err != nil {
 			// anything else should be sent to client with prefix ERR
-			klogs.Logger.Debug(conn.RemoteAddr(), ": ", err.Error())
-			writer.WriteString(protcl.RespError(err))
-			writer.Flush()
+			klogs.Logger.Debug(conn.RemoteAddr(), ": ", p.err.Error())
+			client.reply(protcl.NewMessage(nil, p.err))
 			continue
 		}
 
-		message := dbCommand.Execute(DB, command.Name, command.Args)
+		client.interact(p.command.Name)
+		start := time.Now()
+		client.waited = 0
+		message := client.execute(p.command)
+		recordCommand(p.command.Name, time.Since(start)-client.waited)
+		client.refresh()
 
-		if message.Err == nil {
-			writer.WriteString(message.RespReply())
-		} else {
-			writer.WriteString(protcl.RespError(message.Err))
+		if message != nil {
+			client.reply(message)
 		}
-
-		writer.Flush()
 	}
-
-	ConnectedClients.logOnDisconnect(conn)
 }
 
 func Start(config config.AppConfig) {
@@ -89,18 +170,40 @@ func Start(config config.AppConfig) {
 
 	klogs.Logger.Infof("application is ready to accept connections on port %d", config.Port)
 
+	go expireKeys(DB)
+	go sampleOps()
+	startReplication(config)
+	startCluster(config)
+	go accept(listener)
+
+	shutdown(listener, waitShutdown(), len(config.Save) > 0)
+}
+
+// accept accepts connections until the listener is closed on shutdown
+func accept(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 
 		if err != nil {
-			klogs.Logger.Error("Error on connection with", conn.RemoteAddr().String(), ":", err.Error())
-			conn.Close()
+			select {
+			case <-shuttingDown:
+				return
+			default:
+			}
+
+			klogs.Logger.Error("error accepting a connection: ", err.Error())
 			continue // we skip malformed user
 		}
 
 		// client connected
-		ConnectedClients.logOnConnect(conn)
+		if !ConnectedClients.admit(conn, config.Get().MaxClients) {
+			continue
+		}
 
-		go handleConnection(conn)
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			handleConnection(conn)
+		}()
 	}
 }
@@ -26,30 +26,56 @@ package hashmap
 
 import (
 	"errors"
+	"math"
 	"strconv"
 	"sync"
 	"unicode/utf8"
+
+	"github.com/kasvith/kache/pkg/types/scan"
 )
 
+// ErrNaNOrInf is returned when a float increment would store NaN or Infinity
+var ErrNaNOrInf = errors.New("increment would produce NaN or Infinity")
+
 type HashMap struct {
 	m   map[string]string
+	idx *scan.Index // fields of m, iterated by Scan
 	mux *sync.RWMutex
 }
 
 func New() *HashMap {
-	return &HashMap{m: make(map[string]string), mux: &sync.RWMutex{}}
+	return &HashMap{m: make(map[string]string), idx: scan.New(), mux: &sync.RWMutex{}}
+}
+
+// add stores a new field, the lock must be held
+func (m *HashMap) add(key, value string) {
+	m.m[key] = value
+	m.idx.Add(key)
+}
+
+// Copy returns a new hash map holding the same fields
+func (m *HashMap) Copy() *HashMap {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	dup := &HashMap{m: make(map[string]string, len(m.m)), idx: scan.New(), mux: &sync.RWMutex{}}
+	for key, val := range m.m {
+		dup.add(key, val)
+	}
+
+	return dup
 }
 
 func (m *HashMap) Set(key, value string) int {
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
-	if value, found := m.m[key]; found {
+	if _, found := m.m[key]; found {
 		m.m[key] = value
 		return 0
 	}
 
-	m.m[key] = value
+	m.add(key, value)
 	return 1
 }
 
@@ -61,7 +87,7 @@ func (m *HashMap) Setx(key, value string) int {
 		return 0
 	}
 
-	m.m[key] = value
+	m.add(key, value)
 	return 1
 }
 
@@ -74,7 +100,11 @@ func (m *HashMap) SetBulk(fields []string) (string, error) {
 	}
 
 	for i := 0; i < len(fields); i += 2 {
-		m.m[fields[i]] = fields[i+1]
+		if _, found := m.m[fields[i]]; found {
+			m.m[fields[i]] = fields[i+1]
+		} else {
+			m.add(fields[i], fields[i+1])
+		}
 	}
 
 	return "OK", nil
@@ -144,6 +174,36 @@ func (m *HashMap) Fields() []string {
 	return paris
 }
 
+// Sample returns at most n fields followed by their values, the fields are in no particular order
+func (m *HashMap) Sample(n int) []string {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	pairs := make([]string, 0, 2*n)
+	for key, val := range m.m {
+		if len(pairs) >= 2*n {
+			break
+		}
+		pairs = append(pairs, key, val)
+	}
+
+	return pairs
+}
+
+// Scan returns the fields of the buckets starting from cursor followed by their values and the cursor
+// to continue with, see scan.Index for the guarantees of the iteration
+func (m *HashMap) Scan(cursor uint64, count int) ([]string, uint64) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	var pairs []string
+	cursor = m.idx.Scan(cursor, count, func(key string) {
+		pairs = append(pairs, key, m.m[key])
+	})
+
+	return pairs, cursor
+}
+
 func (m *HashMap) Delete(keys []string) int {
 	m.mux.Lock()
 	defer m.mux.Unlock()
@@ -152,6 +212,7 @@ func (m *HashMap) Delete(keys []string) int {
 	for _, key := range keys {
 		if _, found := m.m[key]; found {
 			delete(m.m, key)
+			m.idx.Remove(key)
 			deleted++
 		}
 	}
@@ -159,6 +220,17 @@ func (m *HashMap) Delete(keys []string) int {
 	return deleted
 }
 
+// Clear removes all the fields of the hash
+func (m *HashMap) Clear() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for key := range m.m {
+		delete(m.m, key)
+	}
+	m.idx = scan.New()
+}
+
 func (m *HashMap) Exists(key string) int {
 	m.mux.RLock()
 	defer m.mux.RUnlock()
@@ -179,7 +251,7 @@ func (m *HashMap) IncrementBy(key string, amount int) (int, error) {
 	target, found := m.m[key]
 
 	if !found {
-		m.m[key] = strconv.Itoa(amount)
+		m.add(key, strconv.Itoa(amount))
 		return amount, nil
 	}
 
@@ -203,7 +275,11 @@ func (m *HashMap) IncrementByFloat(key string, amount float64) (float64, error)
 	target, found := m.m[key]
 
 	if !found {
-		m.m[key] = strconv.FormatFloat(amount, 'f', 6, 64)
+		if math.IsNaN(amount) || math.IsInf(amount, 0) {
+			return 0, ErrNaNOrInf
+		}
+
+		m.add(key, strconv.FormatFloat(amount, 'f', -1, 64))
 		return amount, nil
 	}
 
@@ -215,7 +291,11 @@ func (m *HashMap) IncrementByFloat(key string, amount float64) (float64, error)
 	}
 
 	newVal := targetVal + amount
-	m.m[key] = strconv.FormatFloat(newVal, 'f', 6, 64)
+	if math.IsNaN(newVal) || math.IsInf(newVal, 0) {
+		return 0, ErrNaNOrInf
+	}
+
+	m.m[key] = strconv.FormatFloat(newVal, 'f', -1, 64)
 
 	return newVal, nil
 }
@@ -25,6 +25,7 @@
 package hashmap
 
 import (
+	"math"
 	"strconv"
 	"testing"
 
@@ -42,6 +43,7 @@ func TestHashMap_Set(t *testing.T) {
 	rep = hm.Set("mykey", "updated")
 	assert.Len(hm.m, 1)
 	assert.Equal(0, rep)
+	assert.Equal("updated", hm.Get("mykey"))
 
 	rep = hm.Set("mykey1", "myval")
 	assert.Len(hm.m, 2)
@@ -176,6 +178,14 @@ func TestHashMap_IncrementByFloat(t *testing.T) {
 	assert.Equal(nil, err)
 	assert.Equal(5.5, fl)
 
+	fl, err = hm.IncrementByFloat("counter", 0.1234567)
+	assert.Equal(nil, err)
+	assert.Equal("5.6234567", hm.Get("counter"))
+
+	fl, err = hm.IncrementByFloat("counter", math.Inf(1))
+	assert.Equal(ErrNaNOrInf, err)
+	assert.Equal("5.6234567", hm.Get("counter"))
+
 	hm.Set("key", "val")
 	fl, err = hm.IncrementByFloat("key", 1.5)
 	assert.Equal("invalid type, excepted float", err.Error())
@@ -262,3 +272,54 @@ func TestHashMap_Vals(t *testing.T) {
 	assert.Len(vals, 10)
 	assert.ElementsMatch(elements, vals)
 }
+
+func TestHashMap_Copy(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hm := New()
+	hm.Set("key1", "val1")
+
+	dup := hm.Copy()
+	hm.Set("key1", "updated")
+	hm.Set("key2", "val2")
+
+	assert.Equal(1, dup.Len())
+	assert.Equal("val1", dup.Get("key1"))
+}
+
+func TestHashMap_Sample(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hm := New()
+	hm.SetBulk([]string{"key1", "val1", "key2", "val2"})
+
+	assert.Len(hm.Sample(1), 2)
+	assert.ElementsMatch([]string{"key1", "val1", "key2", "val2"}, hm.Sample(5))
+
+	pair := hm.Sample(1)
+	assert.Equal(hm.Get(pair[0]), pair[1])
+}
+
+func TestHashMap_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	m := New()
+	m.SetBulk([]string{"a", "1", "b", "2"})
+	m.IncrementBy("c", 3)
+	m.Delete([]string{"b"})
+
+	pairs, cursor := m.Scan(0, 10)
+	assert.Equal(uint64(0), cursor)
+	assert.ElementsMatch([]string{"a", "1", "c", "3"}, pairs)
+
+	pairs, _ = m.Copy().Scan(0, 10)
+	assert.ElementsMatch([]string{"a", "1", "c", "3"}, pairs)
+}
+
+func TestHashMap_Clear(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hm := New()
+	hm.SetBulk([]string{"key1", "val1", "key2", "val2"})
+	hm.Clear()
+
+	assert.Equal(0, hm.Len())
+	pairs, _ := hm.Scan(0, 10)
+	assert.Empty(pairs)
+}
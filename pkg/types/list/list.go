@@ -99,6 +99,25 @@ func (list *TList) TPush(val []string) error {
 	}
 }
 
+// Copy returns a new list holding the same elements
+func (list *TList) Copy() *TList {
+	list.mux.RLock()
+	defer list.mux.RUnlock()
+
+	l := New()
+	l.list.PushBackList(list.list)
+
+	return l
+}
+
+// Clear removes all the elements of the list
+func (list *TList) Clear() {
+	list.mux.Lock()
+	defer list.mux.Unlock()
+
+	list.list.Init()
+}
+
 // Head Gets head of the list
 func (list *TList) Head() *list.Element {
 	return list.list.Front()
@@ -443,3 +443,25 @@ func TestTList_TrimHead(t *testing.T) {
 	assert.Equal(8, l.Len())
 	assert.Equal([]string{"7", "6", "5", "4", "3", "2", "1", "0"}, l.Range(0, -1))
 }
+
+func TestTList_Copy(t *testing.T) {
+	assert := testifyAssert.New(t)
+	l := New()
+	l.TPush([]string{"a", "b", "c"})
+
+	dup := l.Copy()
+	l.HPop()
+
+	assert.Equal([]string{"a", "b", "c"}, dup.Range(0, -1))
+	assert.Equal([]string{"b", "c"}, l.Range(0, -1))
+}
+
+func TestTList_Clear(t *testing.T) {
+	assert := testifyAssert.New(t)
+	l := New()
+	l.TPush([]string{"a", "b", "c"})
+	l.Clear()
+
+	assert.Equal(0, l.Len())
+	assert.Empty(l.Range(0, -1))
+}
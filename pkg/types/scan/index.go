@@ -0,0 +1,173 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package scan
+
+import (
+	"math/bits"
+	"math/rand"
+)
+
+// the index never shrinks below this number of buckets
+const minBuckets = 4
+
+// Index keeps strings in buckets addressed by their hash so that they can be iterated with a cursor
+// while strings are added and removed. The cursor is the next bucket to visit with its bits reversed,
+// which is the scheme used by Redis: when the number of buckets doubles or halves between two calls,
+// the buckets which were already visited map to buckets which come before the cursor. Every string
+// present for the full iteration is returned at least once, strings may be returned more than once
+// when the index shrinks. An Index is not safe for concurrent use
+type Index struct {
+	buckets [][]string
+	n       int
+}
+
+func New() *Index {
+	return &Index{buckets: make([][]string, minBuckets)}
+}
+
+// NewFromSlice returns an index holding strs, which must not contain duplicates
+func NewFromSlice(strs []string) *Index {
+	idx := New()
+	for _, s := range strs {
+		idx.Add(s)
+	}
+
+	return idx
+}
+
+// hash is the 64 bit FNV-1a hash of s
+func hash(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+
+	return h
+}
+
+func (idx *Index) mask() uint64 {
+	return uint64(len(idx.buckets) - 1)
+}
+
+// Len returns the number of strings in the index
+func (idx *Index) Len() int {
+	return idx.n
+}
+
+// Add adds s to the index, callers must make sure that s is not in the index already
+func (idx *Index) Add(s string) {
+	b := hash(s) & idx.mask()
+	idx.buckets[b] = append(idx.buckets[b], s)
+	idx.n++
+
+	if idx.n > len(idx.buckets) {
+		idx.resize(len(idx.buckets) * 2)
+	}
+}
+
+// Remove removes s from the index, nothing happens if s is not in the index
+func (idx *Index) Remove(s string) {
+	b := hash(s) & idx.mask()
+	bucket := idx.buckets[b]
+
+	for i, str := range bucket {
+		if str == s {
+			last := len(bucket) - 1
+			bucket[i] = bucket[last]
+			bucket[last] = ""
+			idx.buckets[b] = bucket[:last]
+			idx.n--
+			break
+		}
+	}
+
+	if len(idx.buckets) > minBuckets && idx.n < len(idx.buckets)/8 {
+		idx.resize(len(idx.buckets) / 2)
+	}
+}
+
+// resize moves the strings to size buckets, size must be a power of two
+func (idx *Index) resize(size int) {
+	buckets := make([][]string, size)
+	mask := uint64(size - 1)
+
+	for _, bucket := range idx.buckets {
+		for _, s := range bucket {
+			b := hash(s) & mask
+			buckets[b] = append(buckets[b], s)
+		}
+	}
+
+	idx.buckets = buckets
+}
+
+// Scan passes the strings of the buckets starting from cursor to fn, until at least count strings were
+// passed or count*10 buckets were visited. The cursor to continue the iteration is returned, iteration
+// starts and ends with a cursor of 0. fn must not modify the index
+func (idx *Index) Scan(cursor uint64, count int, fn func(s string)) uint64 {
+	if count <= 0 {
+		count = 1
+	}
+
+	// no more than every bucket is visited, which also keeps count*10 from overflowing
+	visits := len(idx.buckets)
+	if count < visits/10 {
+		visits = count * 10
+	}
+
+	mask := idx.mask()
+	found := 0
+	for ; visits > 0; visits-- {
+		for _, s := range idx.buckets[cursor&mask] {
+			fn(s)
+			found++
+		}
+
+		// increment the reversed cursor, the bits above the mask are set so that the carry reaches
+		// the masked bits
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+
+		if cursor == 0 || found >= count {
+			break
+		}
+	}
+
+	return cursor
+}
+
+// Random returns a random string of the index, false is returned if the index is empty
+func (idx *Index) Random() (string, bool) {
+	if idx.n == 0 {
+		return "", false
+	}
+
+	for {
+		bucket := idx.buckets[rand.Intn(len(idx.buckets))]
+		if len(bucket) > 0 {
+			return bucket[rand.Intn(len(bucket))], true
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package scan
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestIndex_AddRemove(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := New()
+
+	for i := 0; i < 100; i++ {
+		idx.Add(strconv.Itoa(i))
+	}
+	assert.Equal(100, idx.Len())
+	assert.Equal(128, len(idx.buckets))
+
+	for i := 0; i < 100; i++ {
+		idx.Remove(strconv.Itoa(i))
+	}
+	idx.Remove("missing")
+	assert.Equal(0, idx.Len())
+	assert.Equal(minBuckets, len(idx.buckets))
+
+	_, ok := idx.Random()
+	assert.False(ok)
+	idx.Add("a")
+	s, ok := idx.Random()
+	assert.True(ok)
+	assert.Equal("a", s)
+}
+
+func TestIndex_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := New()
+	for i := 0; i < 1000; i++ {
+		idx.Add(strconv.Itoa(i))
+	}
+
+	// strings present for the whole iteration are returned even when the index grows and shrinks
+	seen := make(map[string]bool)
+	var cursor uint64
+	for round := 0; ; round++ {
+		cursor = idx.Scan(cursor, 10, func(s string) { seen[s] = true })
+		if cursor == 0 {
+			break
+		}
+
+		switch {
+		case round == 5:
+			for i := 1000; i < 5000; i++ {
+				idx.Add(strconv.Itoa(i))
+			}
+		case round == 20:
+			for i := 100; i < 5000; i++ {
+				idx.Remove(strconv.Itoa(i))
+			}
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.True(seen[strconv.Itoa(i)], i)
+	}
+
+	// an empty index completes right away
+	assert.Equal(uint64(0), New().Scan(0, 10, func(s string) {}))
+
+	// a huge count visits every bucket at once
+	n := 0
+	assert.Equal(uint64(0), idx.Scan(0, math.MaxInt64, func(s string) { n++ }))
+	assert.Equal(idx.Len(), n)
+}
@@ -26,15 +26,23 @@ package set
 
 import (
 	"sync"
+
+	"github.com/kasvith/kache/pkg/types/scan"
 )
 
 type Set struct {
 	m   map[string]int
+	idx *scan.Index // elements of m, iterated by Scan
 	mux *sync.RWMutex
 }
 
 func New() *Set {
-	return &Set{m: make(map[string]int), mux: &sync.RWMutex{}}
+	return &Set{m: make(map[string]int), idx: scan.New(), mux: &sync.RWMutex{}}
+}
+
+// newFromMap returns a set holding the keys of m
+func newFromMap(m map[string]int) *Set {
+	return &Set{m: m, idx: scan.NewFromSlice(elems(m)), mux: &sync.RWMutex{}}
 }
 
 func NewFromSlice(data []string) *Set {
@@ -43,7 +51,7 @@ func NewFromSlice(data []string) *Set {
 		m[value] = 1
 	}
 
-	return &Set{m: m, mux: &sync.RWMutex{}}
+	return newFromMap(m)
 }
 
 func (set *Set) getMap() map[string]int {
@@ -59,6 +67,11 @@ func (set *Set) getMap() map[string]int {
 	return m
 }
 
+// Copy returns a new set holding the same elements
+func (set *Set) Copy() *Set {
+	return newFromMap(set.getMap())
+}
+
 func (set *Set) Add(keys []string) int {
 	set.mux.Lock()
 	defer set.mux.Unlock()
@@ -68,6 +81,7 @@ func (set *Set) Add(keys []string) int {
 	for _, key := range keys {
 		if _, found := set.m[key]; !found {
 			set.m[key] = 1
+			set.idx.Add(key)
 			added++
 		}
 	}
@@ -101,6 +115,47 @@ func (set *Set) Elems() []string {
 	return elems(set.m)
 }
 
+// Sample returns at most n elements in no particular order
+func (set *Set) Sample(n int) []string {
+	set.mux.RLock()
+	defer set.mux.RUnlock()
+
+	res := make([]string, 0, n)
+	for key := range set.m {
+		if len(res) >= n {
+			break
+		}
+		res = append(res, key)
+	}
+
+	return res
+}
+
+// Scan returns the elements of the buckets starting from cursor and the cursor to continue with, see
+// scan.Index for the guarantees of the iteration
+func (set *Set) Scan(cursor uint64, count int) ([]string, uint64) {
+	set.mux.RLock()
+	defer set.mux.RUnlock()
+
+	var res []string
+	cursor = set.idx.Scan(cursor, count, func(s string) {
+		res = append(res, s)
+	})
+
+	return res, cursor
+}
+
+// Clear removes all the elements of the set
+func (set *Set) Clear() {
+	set.mux.Lock()
+	defer set.mux.Unlock()
+
+	for key := range set.m {
+		delete(set.m, key)
+	}
+	set.idx = scan.New()
+}
+
 func duplicateMap(m map[string]int) map[string]int {
 	dup := make(map[string]int)
 	for key, value := range m {
@@ -136,7 +191,7 @@ func (set *Set) DiffS(sets []Set) *Set {
 		}
 	}
 
-	return &Set{m: dup, mux: &sync.RWMutex{}}
+	return newFromMap(dup)
 }
 
 func (set *Set) Exists(key string) int {
@@ -158,8 +213,7 @@ func Intersection(sets []Set) []string {
 		}
 	}
 
-	minSet := &sets[minSetIdx]
-	sets = append(sets[:minSetIdx], sets[minSetIdx+1:]...)
+	minSet := sets[minSetIdx]
 
 	// an empty set means empty intersection
 	if minSet.Card() == 0 {
@@ -171,6 +225,9 @@ func Intersection(sets []Set) []string {
 	for _, v := range minSet.Elems() {
 		allIntersected := true
 		for i := 0; i < len(sets); i++ {
+			if i == minSetIdx {
+				continue
+			}
 			if sets[i].Exists(v) == 0 {
 				allIntersected = false
 				break
@@ -194,8 +251,12 @@ func Move(key string, src, dest *Set) int {
 
 	if _, found := src.m[key]; found {
 		delete(src.m, key)
+		src.idx.Remove(key)
 		dest.mux.Lock()
-		dest.m[key] = 1
+		if _, found := dest.m[key]; !found {
+			dest.m[key] = 1
+			dest.idx.Add(key)
+		}
 		dest.mux.Unlock()
 		return 1
 	}
@@ -212,6 +273,7 @@ func (set *Set) Delete(keys []string) int {
 	for _, key := range keys {
 		if _, ok := set.m[key]; ok {
 			delete(set.m, key)
+			set.idx.Remove(key)
 			deleted++
 		}
 	}
@@ -256,7 +318,7 @@ func UnionS(sets []Set) *Set {
 		}
 	}
 
-	return &Set{m: m, mux: &sync.RWMutex{}}
+	return newFromMap(m)
 }
 
 // TODO implement pop and randomelement
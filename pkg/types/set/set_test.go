@@ -104,6 +104,16 @@ func TestIntersection(t *testing.T) {
 	set4.Add([]string{"x"})
 	inter = Intersection([]Set{*set1, *set2, *set3, *set4})
 	assert.ElementsMatch([]string{}, inter)
+
+	// the smallest set in the middle
+	a, b, c := New(), New(), New()
+	a.Add([]string{"1", "2", "3"})
+	b.Add([]string{"9"})
+	c.Add([]string{"1", "2"})
+	sets := []Set{*a, *b, *c}
+	inter = Intersection(sets)
+	assert.ElementsMatch([]string{}, inter)
+	assert.Equal(1, sets[1].Card())
 }
 
 func TestIntersectionS(t *testing.T) {
@@ -192,3 +202,55 @@ func TestUnionS(t *testing.T) {
 	union := UnionS([]Set{*set1, *set2, *set3})
 	assert.ElementsMatch([]string{"a", "b", "c", "d", "g", "f"}, union.Elems())
 }
+
+func TestSet_Copy(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := NewFromSlice([]string{"a", "b"})
+
+	dup := set.Copy()
+	set.Add([]string{"c"})
+
+	assert.ElementsMatch([]string{"a", "b"}, dup.Elems())
+	assert.Equal(3, set.Card())
+}
+
+func TestSet_Sample(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := NewFromSlice([]string{"a", "b", "c"})
+
+	assert.Len(set.Sample(2), 2)
+	assert.ElementsMatch([]string{"a", "b", "c"}, set.Sample(5))
+	assert.Subset([]string{"a", "b", "c"}, set.Sample(1))
+}
+
+func TestSet_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := NewFromSlice([]string{"a", "b", "c"})
+	set.Delete([]string{"b"})
+	Move("d", NewFromSlice([]string{"d"}), set)
+
+	var elems []string
+	for cursor := uint64(0); ; {
+		var found []string
+		found, cursor = set.Scan(cursor, 1)
+		elems = append(elems, found...)
+		if cursor == 0 {
+			break
+		}
+	}
+	assert.ElementsMatch([]string{"a", "c", "d"}, elems)
+
+	found, cursor := set.Copy().Scan(0, 10)
+	assert.ElementsMatch([]string{"a", "c", "d"}, found)
+	assert.Equal(uint64(0), cursor)
+}
+
+func TestSet_Clear(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := NewFromSlice([]string{"a", "b"})
+	set.Clear()
+
+	assert.Equal(0, set.Card())
+	elems, _ := set.Scan(0, 10)
+	assert.Empty(elems)
+}
@@ -0,0 +1,230 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package zset
+
+import "math/rand"
+
+const (
+	maxLevel    = 32
+	levelFactor = 0.25 // probability of a node to have one more level
+)
+
+type level struct {
+	forward *node
+	span    int // number of nodes skipped by forward, used to compute ranks
+}
+
+type node struct {
+	member   string
+	score    float64
+	backward *node
+	levels   []level
+}
+
+// less reports whether the node orders before score and member, nodes are ordered by score and
+// then by member
+func (n *node) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// skipList orders the members of a sorted set, ranks are 1 based
+type skipList struct {
+	header *node
+	tail   *node
+	length int
+	level  int
+}
+
+func newSkipList() *skipList {
+	return &skipList{header: &node{levels: make([]level, maxLevel)}, level: 1}
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && rand.Float64() < levelFactor {
+		lvl++
+	}
+
+	return lvl
+}
+
+// insert adds a member which is not in the list yet
+func (sl *skipList) insert(score float64, member string) {
+	var update [maxLevel]*node
+	var rank [maxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.less(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	lvl := randomLevel()
+	if lvl > sl.level {
+		for i := sl.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = lvl
+	}
+
+	x = &node{member: member, score: score, levels: make([]level, lvl)}
+	for i := 0; i < lvl; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	for i := lvl; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete removes the member with score, false is returned if it is not in the list
+func (sl *skipList) delete(score float64, member string) bool {
+	var update [maxLevel]*node
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.less(score, member) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+
+	for sl.level > 1 && sl.header.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+
+	return true
+}
+
+// rank returns the rank of the member with score, 0 if it is not in the list
+func (sl *skipList) rank(score float64, member string) int {
+	x, rank := sl.last(func(n *node) bool {
+		return n.less(score, member) || (n.score == score && n.member == member)
+	})
+
+	if x == nil || x.score != score || x.member != member {
+		return 0
+	}
+
+	return rank
+}
+
+// byRank returns the node at rank, nil if rank is out of range
+func (sl *skipList) byRank(rank int) *node {
+	if rank < 1 || rank > sl.length {
+		return nil
+	}
+
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// first returns the first node accepted by gteMin and its rank, gteMin must accept every node after the
+// first one it accepts
+func (sl *skipList) first(gteMin func(*node) bool) (*node, int) {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !gteMin(x.levels[i].forward) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+
+	return x.levels[0].forward, rank + 1
+}
+
+// last returns the last node accepted by lteMax and its rank, lteMax must accept every node before the
+// last one it accepts. A nil node is returned if lteMax accepts none
+func (sl *skipList) last(lteMax func(*node) bool) (*node, int) {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && lteMax(x.levels[i].forward) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+
+	if x == sl.header {
+		return nil, 0
+	}
+
+	return x, rank
+}
@@ -0,0 +1,439 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package zset
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/kasvith/kache/pkg/types/scan"
+)
+
+var ErrNaN = errors.New("resulting score is not a number (NaN)")
+
+// Entry is a member of a sorted set along with its score
+type Entry struct {
+	Member string
+	Score  float64
+}
+
+// Range selects the members of a sorted set between a minimum and a maximum
+type Range interface {
+	gteMin(n *node) bool
+	lteMax(n *node) bool
+}
+
+// ScoreRange selects the members with a score between Min and Max
+type ScoreRange struct {
+	Min, Max                   float64
+	MinExclusive, MaxExclusive bool
+}
+
+func (r ScoreRange) gteMin(n *node) bool {
+	if r.MinExclusive {
+		return n.score > r.Min
+	}
+
+	return n.score >= r.Min
+}
+
+func (r ScoreRange) lteMax(n *node) bool {
+	if r.MaxExclusive {
+		return n.score < r.Max
+	}
+
+	return n.score <= r.Max
+}
+
+// LexRange selects the members between Min and Max in lexicographical order, the order is only
+// meaningful when all the members have the same score. An unbounded side accepts every member
+type LexRange struct {
+	Min, Max                   string
+	MinExclusive, MaxExclusive bool
+	MinUnbounded, MaxUnbounded bool
+}
+
+func (r LexRange) gteMin(n *node) bool {
+	switch {
+	case r.MinUnbounded:
+		return true
+	case r.MinExclusive:
+		return n.member > r.Min
+	}
+
+	return n.member >= r.Min
+}
+
+func (r LexRange) lteMax(n *node) bool {
+	switch {
+	case r.MaxUnbounded:
+		return true
+	case r.MaxExclusive:
+		return n.member < r.Max
+	}
+
+	return n.member <= r.Max
+}
+
+// ZSet is a set of members ordered by their score, members with the same score are ordered
+// lexicographically
+type ZSet struct {
+	dict map[string]float64
+	sl   *skipList
+	idx  *scan.Index // members of dict, iterated by Scan
+	mux  *sync.RWMutex
+}
+
+func New() *ZSet {
+	return &ZSet{dict: make(map[string]float64), sl: newSkipList(), idx: scan.New(), mux: &sync.RWMutex{}}
+}
+
+// NewFromEntries returns a sorted set holding entries, the last score of a member wins
+func NewFromEntries(entries []Entry) *ZSet {
+	z := New()
+	for _, e := range entries {
+		z.set(e.Member, e.Score)
+	}
+
+	return z
+}
+
+// set stores member with score, the lock must be held. true is returned if member is new
+func (z *ZSet) set(member string, score float64) bool {
+	if old, found := z.dict[member]; found {
+		if old != score {
+			z.sl.delete(old, member)
+			z.sl.insert(score, member)
+			z.dict[member] = score
+		}
+
+		return false
+	}
+
+	z.dict[member] = score
+	z.sl.insert(score, member)
+	z.idx.Add(member)
+
+	return true
+}
+
+// remove deletes member, the lock must be held
+func (z *ZSet) remove(member string) bool {
+	score, found := z.dict[member]
+	if !found {
+		return false
+	}
+
+	delete(z.dict, member)
+	z.sl.delete(score, member)
+	z.idx.Remove(member)
+
+	return true
+}
+
+// Copy returns a new sorted set holding the same members
+func (z *ZSet) Copy() *ZSet {
+	return NewFromEntries(z.Entries())
+}
+
+// Add sets the score of member, 1 is returned if member was added and 0 if its score was updated
+func (z *ZSet) Add(member string, score float64) int {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	if z.set(member, score) {
+		return 1
+	}
+
+	return 0
+}
+
+// IncrBy increments the score of member by delta and returns the new score, a missing member is added
+// with a score of delta
+func (z *ZSet) IncrBy(member string, delta float64) (float64, error) {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	score := z.dict[member] + delta
+	if math.IsNaN(score) {
+		return 0, ErrNaN
+	}
+
+	z.set(member, score)
+
+	return score, nil
+}
+
+// Remove deletes members and returns the number of members deleted
+func (z *ZSet) Remove(members []string) int {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	removed := 0
+	for _, member := range members {
+		if z.remove(member) {
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Card returns the number of members
+func (z *ZSet) Card() int {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	return len(z.dict)
+}
+
+// Score returns the score of member, false is returned if it is not a member
+func (z *ZSet) Score(member string) (float64, bool) {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	score, found := z.dict[member]
+	return score, found
+}
+
+// Rank returns the 0 based position of member ordered by ascending scores, or by descending scores
+// when reverse is set. false is returned if it is not a member
+func (z *ZSet) Rank(member string, reverse bool) (int, bool) {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	score, found := z.dict[member]
+	if !found {
+		return 0, false
+	}
+
+	rank := z.sl.rank(score, member)
+	if reverse {
+		return z.sl.length - rank, true
+	}
+
+	return rank - 1, true
+}
+
+// normalizeRanks converts negative ranks to ranks from the end and clamps them, false is returned
+// when the range is empty
+func (z *ZSet) normalizeRanks(start, stop int) (int, int, bool) {
+	n := z.sl.length
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+
+	return start, stop, start <= stop && start < n
+}
+
+// RangeByRank returns the members from start to stop, both inclusive and 0 based. Negative ranks
+// count from the end. Ranks are taken by descending scores when reverse is set
+func (z *ZSet) RangeByRank(start, stop int, reverse bool) []Entry {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	start, stop, ok := z.normalizeRanks(start, stop)
+	if !ok {
+		return []Entry{}
+	}
+
+	res := make([]Entry, 0, stop-start+1)
+	if reverse {
+		for x := z.sl.byRank(z.sl.length - start); x != nil && len(res) < cap(res); x = x.backward {
+			res = append(res, Entry{Member: x.member, Score: x.score})
+		}
+		return res
+	}
+
+	for x := z.sl.byRank(start + 1); x != nil && len(res) < cap(res); x = x.levels[0].forward {
+		res = append(res, Entry{Member: x.member, Score: x.score})
+	}
+
+	return res
+}
+
+// RemoveRangeByRank deletes the members from start to stop as selected by RangeByRank and returns the
+// number of members deleted
+func (z *ZSet) RemoveRangeByRank(start, stop int) int {
+	entries := z.RangeByRank(start, stop, false)
+
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	for _, e := range entries {
+		z.remove(e.Member)
+	}
+
+	return len(entries)
+}
+
+// Range returns the members selected by r, starting from the highest score when reverse is set. offset
+// members are skipped and at most count members are returned, a negative count returns all of them
+func (z *ZSet) Range(r Range, reverse bool, offset, count int) []Entry {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	res := []Entry{}
+	if offset < 0 {
+		return res
+	}
+
+	var x *node
+	if reverse {
+		x, _ = z.sl.last(r.lteMax)
+	} else {
+		x, _ = z.sl.first(r.gteMin)
+	}
+
+	for ; x != nil && count != 0; offset-- {
+		if reverse && !r.gteMin(x) || !reverse && !r.lteMax(x) {
+			break
+		}
+
+		if offset <= 0 {
+			res = append(res, Entry{Member: x.member, Score: x.score})
+			count--
+		}
+
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.levels[0].forward
+		}
+	}
+
+	return res
+}
+
+// Count returns the number of members selected by r
+func (z *ZSet) Count(r Range) int {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	first, firstRank := z.sl.first(r.gteMin)
+	if first == nil || !r.lteMax(first) {
+		return 0
+	}
+
+	_, lastRank := z.sl.last(r.lteMax)
+
+	return lastRank - firstRank + 1
+}
+
+// RemoveRange deletes the members selected by r and returns the number of members deleted
+func (z *ZSet) RemoveRange(r Range) int {
+	entries := z.Range(r, false, 0, -1)
+
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	for _, e := range entries {
+		z.remove(e.Member)
+	}
+
+	return len(entries)
+}
+
+// PopMin removes and returns at most count members with the lowest scores
+func (z *ZSet) PopMin(count int) []Entry {
+	return z.pop(count, false)
+}
+
+// PopMax removes and returns at most count members with the highest scores, the highest first
+func (z *ZSet) PopMax(count int) []Entry {
+	return z.pop(count, true)
+}
+
+func (z *ZSet) pop(count int, max bool) []Entry {
+	if count <= 0 {
+		return []Entry{}
+	}
+
+	entries := z.RangeByRank(0, count-1, max)
+
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	for _, e := range entries {
+		z.remove(e.Member)
+	}
+
+	return entries
+}
+
+// Entries returns all the members ordered by ascending scores
+func (z *ZSet) Entries() []Entry {
+	return z.RangeByRank(0, -1, false)
+}
+
+// Sample returns at most n members in no particular order
+func (z *ZSet) Sample(n int) []string {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	res := make([]string, 0, n)
+	for member := range z.dict {
+		if len(res) >= n {
+			break
+		}
+		res = append(res, member)
+	}
+
+	return res
+}
+
+// Scan returns the members of the buckets starting from cursor along with their scores and the cursor
+// to continue with, see scan.Index for the guarantees of the iteration
+func (z *ZSet) Scan(cursor uint64, count int) ([]Entry, uint64) {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	var res []Entry
+	cursor = z.idx.Scan(cursor, count, func(member string) {
+		res = append(res, Entry{Member: member, Score: z.dict[member]})
+	})
+
+	return res, cursor
+}
+
+// Clear removes all the members of the sorted set
+func (z *ZSet) Clear() {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	z.dict = make(map[string]float64)
+	z.sl = newSkipList()
+	z.idx = scan.New()
+}
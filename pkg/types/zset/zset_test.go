@@ -0,0 +1,174 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package zset
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func members(entries []Entry) []string {
+	res := make([]string, len(entries))
+	for i, e := range entries {
+		res[i] = e.Member
+	}
+
+	return res
+}
+
+func TestZSet_Add(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := New()
+
+	assert.Equal(1, z.Add("a", 2))
+	assert.Equal(1, z.Add("b", 1))
+	assert.Equal(0, z.Add("a", 0))
+	assert.Equal(2, z.Card())
+
+	score, ok := z.Score("a")
+	assert.True(ok)
+	assert.Equal(0.0, score)
+	_, ok = z.Score("c")
+	assert.False(ok)
+	assert.Equal([]Entry{{"a", 0}, {"b", 1}}, z.Entries())
+
+	score, err := z.IncrBy("c", 1.5)
+	assert.Nil(err)
+	assert.Equal(1.5, score)
+	z.Add("inf", math.Inf(1))
+	_, err = z.IncrBy("inf", math.Inf(-1))
+	assert.Equal(ErrNaN, err)
+
+	assert.Equal(2, z.Remove([]string{"a", "inf", "missing"}))
+	assert.Equal([]string{"b", "c"}, members(z.Entries()))
+}
+
+func TestZSet_Rank(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := New()
+
+	// members with the same score are ordered lexicographically
+	for i := 0; i < 1000; i++ {
+		z.Add(strconv.Itoa(i), float64(i/10))
+	}
+
+	expected := make([]string, 1000)
+	for i := range expected {
+		expected[i] = strconv.Itoa(i)
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		a, _ := strconv.Atoi(expected[i])
+		b, _ := strconv.Atoi(expected[j])
+		return a/10 < b/10 || (a/10 == b/10 && expected[i] < expected[j])
+	})
+
+	for i, member := range expected {
+		rank, ok := z.Rank(member, false)
+		assert.True(ok)
+		assert.Equal(i, rank)
+
+		rank, _ = z.Rank(member, true)
+		assert.Equal(999-i, rank)
+	}
+
+	_, ok := z.Rank("missing", false)
+	assert.False(ok)
+
+	assert.Equal(expected[10:20], members(z.RangeByRank(10, 19, false)))
+	assert.Equal(expected[998:], members(z.RangeByRank(-2, 2000, false)))
+	assert.Equal([]string{expected[999], expected[998]}, members(z.RangeByRank(0, 1, true)))
+	assert.Equal([]Entry{}, z.RangeByRank(5, 4, false))
+	assert.Equal([]Entry{}, z.RangeByRank(1000, 1001, false))
+
+	assert.Equal(500, z.RemoveRangeByRank(0, 499))
+	rank, _ := z.Rank(expected[500], false)
+	assert.Equal(0, rank)
+}
+
+func TestZSet_RangeByScore(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := NewFromEntries([]Entry{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 4}, {"e", 5}})
+
+	assert.Equal([]string{"b", "c", "d"}, members(z.Range(ScoreRange{Min: 2, Max: 4}, false, 0, -1)))
+	assert.Equal([]string{"c"}, members(z.Range(ScoreRange{Min: 2, Max: 4, MinExclusive: true, MaxExclusive: true}, false, 0, -1)))
+	assert.Equal([]string{"d", "c", "b"}, members(z.Range(ScoreRange{Min: 2, Max: 4}, true, 0, -1)))
+	assert.Equal([]string{"c", "d"}, members(z.Range(ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, false, 2, 2)))
+	assert.Equal([]string{"c", "b"}, members(z.Range(ScoreRange{Min: 1, Max: 4}, true, 1, 2)))
+	assert.Equal([]Entry{}, z.Range(ScoreRange{Min: 4, Max: 2}, false, 0, -1))
+	assert.Equal([]Entry{}, z.Range(ScoreRange{Min: 6, Max: 7}, true, 0, -1))
+
+	assert.Equal(3, z.Count(ScoreRange{Min: 2, Max: 4}))
+	assert.Equal(0, z.Count(ScoreRange{Min: 4, Max: 2}))
+	assert.Equal(5, z.Count(ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}))
+
+	assert.Equal(2, z.RemoveRange(ScoreRange{Min: 4, Max: math.Inf(1)}))
+	assert.Equal([]string{"a", "b", "c"}, members(z.Entries()))
+}
+
+func TestZSet_RangeByLex(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := NewFromEntries([]Entry{{"a", 0}, {"b", 0}, {"c", 0}, {"d", 0}})
+
+	assert.Equal([]string{"a", "b"}, members(z.Range(LexRange{MinUnbounded: true, Max: "b"}, false, 0, -1)))
+	assert.Equal([]string{"c", "d"}, members(z.Range(LexRange{Min: "b", MinExclusive: true, MaxUnbounded: true}, false, 0, -1)))
+	assert.Equal([]string{"c", "b"}, members(z.Range(LexRange{Min: "aa", Max: "c"}, true, 0, -1)))
+	assert.Equal(2, z.Count(LexRange{Min: "b", Max: "d", MaxExclusive: true}))
+	assert.Equal(4, z.RemoveRange(LexRange{MinUnbounded: true, MaxUnbounded: true}))
+	assert.Equal(0, z.Card())
+}
+
+func TestZSet_Pop(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := NewFromEntries([]Entry{{"a", 1}, {"b", 2}, {"c", 3}})
+
+	assert.Equal([]Entry{{"a", 1}}, z.PopMin(1))
+	assert.Equal([]Entry{{"c", 3}, {"b", 2}}, z.PopMax(5))
+	assert.Equal([]Entry{}, z.PopMin(1))
+	assert.Equal([]Entry{}, z.PopMax(0))
+}
+
+func TestZSet_CopyScanClear(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := NewFromEntries([]Entry{{"a", 1}, {"b", 2}})
+
+	dup := z.Copy()
+	dup.Add("c", 3)
+	assert.Equal(2, z.Card())
+
+	entries, cursor := z.Scan(0, 10)
+	assert.Equal(uint64(0), cursor)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Member < entries[j].Member })
+	assert.Equal([]Entry{{"a", 1}, {"b", 2}}, entries)
+	assert.Len(z.Sample(1), 1)
+
+	z.Clear()
+	assert.Equal(0, z.Card())
+	assert.Equal([]Entry{}, z.Entries())
+	assert.Equal(3, dup.Card())
+}
@@ -0,0 +1,142 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package util
+
+// GlobMatch reports whether str matches the glob style pattern. A star matches any
+// sequence of characters, ? matches a single character, [abc] matches one of the
+// characters in the bracket where ranges like [a-z] and negation like [^abc] are
+// allowed, and a backslash matches the next character literally
+func GlobMatch(pattern, str string) bool {
+	p, s := 0, 0
+
+	// position to backtrack when a mismatch happens after a star
+	starP, starS := -1, -1
+
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				// collapse consecutive stars
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+
+				if p == len(pattern) {
+					return true
+				}
+
+				starP, starS = p, s
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				if matched, next, ok := matchBracket(pattern, p, str[s]); ok && matched {
+					p = next
+					s++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == str[s] {
+					p += 2
+					s++
+					continue
+				}
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+
+		// mismatch, let the last star consume one more character
+		if starP == -1 {
+			return false
+		}
+
+		starS++
+		p, s = starP, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchBracket matches c against the bracket expression starting at pattern[start],
+// returns the position after the bracket. ok is false when the bracket is not terminated
+func matchBracket(pattern string, start int, c byte) (matched bool, next int, ok bool) {
+	p := start + 1
+	negate := false
+
+	if p < len(pattern) && pattern[p] == '^' {
+		negate = true
+		p++
+	}
+
+	for first := true; p < len(pattern); first = false {
+		if pattern[p] == ']' && !first {
+			if negate {
+				matched = !matched
+			}
+
+			return matched, p + 1, true
+		}
+
+		if pattern[p] == '\\' && p+1 < len(pattern) {
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+			p++
+			continue
+		}
+
+		if p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']' {
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			p += 3
+			continue
+		}
+
+		if pattern[p] == c {
+			matched = true
+		}
+		p++
+	}
+
+	return false, p, false
+}
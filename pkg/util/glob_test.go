@@ -0,0 +1,72 @@
+/*
+ * MIT License
+ *
+ * Copyright (c)  2018 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package util
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	matches := [][2]string{
+		{"*", ""},
+		{"*", "anything"},
+		{"news.*", "news.tech"},
+		{"news.*", "news."},
+		{"h?llo", "hello"},
+		{"h*llo", "heeeello"},
+		{"h*llo", "hllo"},
+		{"h[ae]llo", "hallo"},
+		{"h[^e]llo", "hallo"},
+		{"h[a-b]llo", "hbllo"},
+		{"*a*b*", "xxaxxbxx"},
+		{"user:*:name", "user:10:name"},
+		{`h\*llo`, "h*llo"},
+		{`h[\]]llo`, "h]llo"},
+	}
+
+	for _, m := range matches {
+		assert.True(GlobMatch(m[0], m[1]), m[0]+" should match "+m[1])
+	}
+
+	mismatches := [][2]string{
+		{"", "a"},
+		{"news.*", "new.tech"},
+		{"h?llo", "hllo"},
+		{"h[ae]llo", "hillo"},
+		{"h[^e]llo", "hello"},
+		{"h[a-b]llo", "hcllo"},
+		{"*a*b", "xxaxxbxx"},
+		{`h\*llo`, "hello"},
+		{"h[ae", "ha"},
+	}
+
+	for _, m := range mismatches {
+		assert.False(GlobMatch(m[0], m[1]), m[0]+" should not match "+m[1])
+	}
+}
@@ -27,12 +27,24 @@ package util
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
 var (
 	ErrUnbalancedQuotes = errors.New("unbalanced quotes")
 )
 
+// memoryUnits are the multipliers of the units accepted by ParseMemory, longest suffixes first
+var memoryUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+}
+
 // ToString Convert an interface to string
 func ToString(i interface{}) string {
 	if s, ok := i.(string); ok {
@@ -98,3 +110,23 @@ func appendIfBufferNotEmpty(buf *bytes.Buffer, list []string) []string {
 
 	return list
 }
+
+// ParseMemory parses an amount of memory such as 100mb into bytes. k, m and g are powers of 1000
+// while kb, mb and gb are powers of 1024, a number without a unit is in bytes
+func ParseMemory(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			str, mult = strings.TrimSuffix(str, unit.suffix), unit.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid amount of memory %q", s)
+	}
+
+	return n * mult, nil
+}
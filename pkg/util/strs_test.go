@@ -55,6 +55,21 @@ func TestSplitSpacesWithQuotes(t *testing.T) {
 
 }
 
+func TestParseMemory(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	for s, expected := range map[string]int64{"0": 0, "100": 100, "10b": 10, "1k": 1000, "1kb": 1024, "2MB": 2 * 1024 * 1024, "1g": 1000 * 1000 * 1000} {
+		n, err := ParseMemory(s)
+		assert.Nil(err, s)
+		assert.Equal(expected, n, s)
+	}
+
+	for _, s := range []string{"", "mb", "-1", "1tb", "1.5mb"} {
+		_, err := ParseMemory(s)
+		assert.NotNil(err, s)
+	}
+}
+
 func BenchmarkSplitSpacesWithQuotes(b *testing.B) {
 	testString := ` foo     bar "foo bar bar"    foo     bar "foo bar bar" foo     bar "foo bar bar" foo     bar "foo bar bar"`
 	for i := 0; i < b.N; i++ {